@@ -0,0 +1,284 @@
+package todo
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"html/template"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andybalholm/brotli"
+)
+
+// The web app, with its bower components when installed before the build.
+//
+//go:embed polymer
+var embedded embed.FS
+
+// templateNames are the server-side templates, not served as assets.
+var templateNames = []string{"index.html", "todos.html"}
+
+// ImmutableCache is the cache control of the fingerprinted assets.
+const ImmutableCache = "public, max-age=31536000, immutable"
+
+// Assets serves the static files and the templates of the web app,
+// embedded in the binary, or else read from disk in dev mode.
+//
+// The embedded files are fingerprinted, as in "app.0123456789abcdef.css",
+// and precompressed with gzip and brotli.
+type Assets struct {
+	fsys      fs.FS
+	dev       bool
+	files     map[string]*asset
+	templates *template.Template
+}
+
+type asset struct {
+	fingerprint string
+	hash        string
+	ctype       string
+	data        []byte
+	gzip        []byte
+	brotli      []byte
+}
+
+var embeddedAssets struct {
+	once   sync.Once
+	assets *Assets
+	err    error
+}
+
+// EmbeddedAssets returns the assets embedded in the binary,
+// loaded once.
+func EmbeddedAssets() (*Assets, error) {
+	var e = &embeddedAssets
+	e.once.Do(func() {
+		var fsys, err = fs.Sub(embedded, "polymer")
+		if err != nil {
+			e.err = err
+			return
+		}
+		e.assets, e.err = loadAssets(fsys)
+	})
+	return e.assets, e.err
+}
+
+// DevAssets returns the assets of the given directory, served
+// without caching and with the templates parsed on each request,
+// for live editing.
+func DevAssets(dir string) (*Assets, error) {
+	var a = &Assets{fsys: os.DirFS(dir), dev: true}
+
+	// fail early on template errors
+	var _, err = a.Templates()
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// NewAssets returns the assets of the given configuration.
+func NewAssets(config Config) (*Assets, error) {
+	if config.Dev {
+		return DevAssets(config.StaticDir)
+	}
+	return EmbeddedAssets()
+}
+
+func loadAssets(fsys fs.FS) (*Assets, error) {
+	var a = &Assets{fsys: fsys, files: make(map[string]*asset)}
+
+	var err = fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		for _, tpl := range templateNames {
+			if name == tpl {
+				return nil
+			}
+		}
+
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+
+		var sum = sha256.Sum256(data)
+		var f = &asset{
+			hash:  hex.EncodeToString(sum[:8]),
+			ctype: mime.TypeByExtension(path.Ext(name)),
+			data:  data,
+		}
+		if len(f.ctype) == 0 {
+			f.ctype = http.DetectContentType(data)
+		}
+		f.fingerprint = fingerprint(name, f.hash)
+
+		f.gzip, err = compress(data, func(buf *bytes.Buffer) (compressor, error) {
+			return gzip.NewWriterLevel(buf, gzip.BestCompression)
+		})
+		if err != nil {
+			return err
+		}
+		f.brotli, err = compress(data, func(buf *bytes.Buffer) (compressor, error) {
+			return brotli.NewWriterLevel(buf, brotli.BestCompression), nil
+		})
+		if err != nil {
+			return err
+		}
+
+		a.files[name] = f
+		a.files[f.fingerprint] = f
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	a.templates, err = a.parseTemplates()
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+type compressor interface {
+	Write([]byte) (int, error)
+	Close() error
+}
+
+// compress returns the compressed data, or nil when not smaller.
+func compress(data []byte, writer func(*bytes.Buffer) (compressor, error)) ([]byte, error) {
+	var buf bytes.Buffer
+	var w, err = writer(&buf)
+	if err != nil {
+		return nil, err
+	}
+	_, err = w.Write(data)
+	if err != nil {
+		return nil, err
+	}
+	err = w.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	if buf.Len() >= len(data) {
+		return nil, nil
+	}
+	return buf.Bytes(), nil
+}
+
+// fingerprint inserts the hash before the extension of name.
+func fingerprint(name, hash string) string {
+	var ext = path.Ext(name)
+	return strings.TrimSuffix(name, ext) + "." + hash + ext
+}
+
+// Path returns the fingerprinted path of the named asset,
+// or the name itself in dev mode or for unknown assets.
+func (a *Assets) Path(name string) string {
+	if f, ok := a.files[name]; ok {
+		return f.fingerprint
+	}
+	return name
+}
+
+// Templates returns the templates, parsed again on each call in dev mode.
+func (a *Assets) Templates() (*template.Template, error) {
+	if a.dev {
+		return a.parseTemplates()
+	}
+	return a.templates, nil
+}
+
+func (a *Assets) parseTemplates() (*template.Template, error) {
+	return template.New("base").Funcs(template.FuncMap{
+		"jsstr": templateJSStr,
+		"asset": a.Path,
+	}).ParseFS(a.fsys, templateNames...)
+}
+
+// ServeHTTP serves the named asset.
+func (a *Assets) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var upath = r.URL.Path
+
+	if upath == "/" {
+		http.Redirect(w, r, "index.html", http.StatusMovedPermanently)
+		return
+	}
+
+	var name = strings.TrimPrefix(path.Clean("/"+upath), "/")
+
+	if a.dev {
+		w.Header().Set("Cache-Control", "no-cache")
+		http.ServeFileFS(w, r, a.fsys, name)
+		return
+	}
+
+	var f, ok = a.files[name]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	var header = w.Header()
+	header.Set("Content-Type", f.ctype)
+	header.Add("Vary", "Accept-Encoding")
+
+	// fingerprinted paths never change, others are revalidated
+	if name == f.fingerprint {
+		header.Set("Cache-Control", ImmutableCache)
+	} else {
+		header.Set("Cache-Control", "no-cache")
+	}
+
+	var data, etag = f.data, f.hash
+	var accept = r.Header.Get("Accept-Encoding")
+	switch {
+	case f.brotli != nil && acceptsEncoding(accept, "br"):
+		data, etag = f.brotli, f.hash+"-br"
+		header.Set("Content-Encoding", "br")
+	case f.gzip != nil && acceptsEncoding(accept, "gzip"):
+		data, etag = f.gzip, f.hash+"-gzip"
+		header.Set("Content-Encoding", "gzip")
+	}
+	header.Set("ETag", `"`+etag+`"`)
+
+	http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(data))
+}
+
+// acceptsEncoding returns whether the Accept-Encoding header
+// accepts the given content coding.
+func acceptsEncoding(header, coding string) bool {
+	for _, part := range strings.Split(header, ",") {
+		var params = strings.Split(part, ";")
+		var name = strings.TrimSpace(params[0])
+		if name != coding && name != "*" {
+			continue
+		}
+
+		for _, param := range params[1:] {
+			var q = strings.TrimSpace(param)
+			if strings.HasPrefix(q, "q=") {
+				var v, err = strconv.ParseFloat(q[2:], 64)
+				if err != nil || v == 0 {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	return false
+}
@@ -0,0 +1,60 @@
+package todo
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Users maps the bearer tokens of the api to the names of their users,
+// keying their rate limits. They are not authorized differently.
+type Users map[string]string
+
+// ParseUsers parses comma separated "user:token" pairs.
+// An empty string defines no users.
+func ParseUsers(s string) (Users, error) {
+	var users = make(Users)
+	if len(s) == 0 {
+		return users, nil
+	}
+
+	for _, pair := range strings.Split(s, ",") {
+		var parts = strings.SplitN(strings.TrimSpace(pair), ":", 2)
+		if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+			return nil, fmt.Errorf("auth: %q is not user:token", pair)
+		}
+		users[parts[1]] = parts[0]
+	}
+	return users, nil
+}
+
+// Authenticate returns the user of the given Authorization header value,
+// none without a bearer token, and whether the token is known.
+func (u Users) Authenticate(authorization string) (string, bool) {
+	var token = strings.TrimPrefix(authorization, "Bearer ")
+	if len(authorization) == 0 || token == authorization {
+		return "", true
+	}
+
+	var user, ok = u[token]
+	return user, ok
+}
+
+// Handler authenticates the requests of next by their bearer token.
+// Unknown tokens get a 401 response, the requests without are anonymous.
+func (u Users) Handler(next http.Handler) http.Handler {
+	var fn = func(w http.ResponseWriter, r *http.Request) {
+		var user, ok = u.Authenticate(r.Header.Get("Authorization"))
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="todo"`)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		if len(user) != 0 {
+			r = WithUser(r, user)
+		}
+		next.ServeHTTP(w, r)
+	}
+
+	return http.HandlerFunc(fn)
+}
@@ -0,0 +1,212 @@
+package todo
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// BackupVersion is the version of the backup archive format.
+const BackupVersion = 1
+
+// Backup defines a portable archive of all todos of a Store.
+type Backup struct {
+	Version  int       `json:"version"`
+	Created  time.Time `json:"created"`
+	Driver   string    `json:"driver"`
+	Count    int       `json:"count"`
+	Checksum string    `json:"checksum"`
+	Todos    Todos     `json:"todos"`
+}
+
+// Restored defines the outcome of a restore, and the ids given to the
+// todos of the backup when remapped.
+type Restored struct {
+	Count    int64             `json:"count"`
+	Created  int               `json:"created"`
+	Replaced int               `json:"replaced"`
+	DryRun   bool              `json:"dryRun"`
+	Remapped map[string]string `json:"remapped,omitempty"`
+}
+
+// NewBackup returns a backup of all todos of the given store.
+// The driver names the backend of the store.
+func NewBackup(store Store, driver string) Backup {
+	var todos = store.List()
+
+	return Backup{
+		Version:  BackupVersion,
+		Created:  time.Now().UTC(),
+		Driver:   driver,
+		Count:    len(todos),
+		Checksum: Checksum(todos),
+		Todos:    todos,
+	}
+}
+
+// WriteBackup writes the given backup as gzipped JSON.
+func WriteBackup(w io.Writer, b Backup) error {
+	var zw = gzip.NewWriter(w)
+
+	var err = json.NewEncoder(zw).Encode(b)
+	if err != nil {
+		zw.Close()
+		return err
+	}
+
+	return zw.Close()
+}
+
+// ReadBackup reads a backup written by WriteBackup
+// and checks its version, count and checksum.
+func ReadBackup(r io.Reader) (Backup, error) {
+	var b Backup
+
+	var zr, err = gzip.NewReader(r)
+	if err != nil {
+		return b, err
+	}
+	defer zr.Close()
+
+	err = json.NewDecoder(zr).Decode(&b)
+	if err != nil {
+		return b, err
+	}
+
+	if b.Version != BackupVersion {
+		return b, fmt.Errorf("backup: unsupported version %d", b.Version)
+	}
+	if b.Count != len(b.Todos) {
+		return b, fmt.Errorf("backup: expected %d todos but was %d", b.Count, len(b.Todos))
+	}
+	if sum := Checksum(b.Todos); b.Checksum != sum {
+		return b, fmt.Errorf("backup: checksum mismatch %s != %s", b.Checksum, sum)
+	}
+
+	return b, nil
+}
+
+// Restore loads the todos of the given backup into the given store,
+// keeping their ids and creation dates.
+// Nothing is written when dryRun is true.
+//
+// A backup of ids the store does not accept, such as a rethink backup,
+// of UUID ids, restored into an sql store, of integer ids, is restored
+// with new ids: all its todos are created, and Remapped maps their ids
+// in the backup to their ids in the store.
+func Restore(store Store, b Backup, dryRun bool) (Restored, error) {
+	var restored = Restored{DryRun: dryRun}
+
+	var remap bool
+	if checker, ok := store.(IDChecker); ok {
+		for _, t := range b.Todos {
+			if len(t.ID) != 0 && checker.CheckID(t.ID) != nil {
+				remap = true
+				break
+			}
+		}
+	}
+
+	for _, t := range b.Todos {
+		if remap {
+			restored.Created++
+			continue
+		}
+
+		var _, err = store.Find(t.ID)
+		switch err.(type) {
+		case nil:
+			restored.Replaced++
+		case NotFound:
+			restored.Created++
+		default:
+			return restored, err
+		}
+	}
+
+	if dryRun {
+		restored.Count = int64(len(b.Todos))
+		return restored, nil
+	}
+
+	var todos = make(Todos, len(b.Todos))
+	copy(todos, b.Todos)
+	if remap {
+		for i := range todos {
+			todos[i].ID = ""
+		}
+	}
+
+	var count, err = store.Import(todos)
+	restored.Count = count
+	if err != nil || !remap {
+		return restored, err
+	}
+
+	restored.Remapped = make(map[string]string, len(todos))
+	for i, t := range todos {
+		restored.Remapped[b.Todos[i].ID] = t.ID
+	}
+	return restored, nil
+}
+
+// Verify checks that the given store holds the todos of the given backup,
+// at the ids they were restored to, if remapped.
+func Verify(store Store, b Backup, remapped map[string]string) error {
+	var todos = make(Todos, 0, len(b.Todos))
+
+	for _, t := range b.Todos {
+		var id = t.ID
+		if restoredID, ok := remapped[id]; ok {
+			id = restoredID
+		}
+
+		var found, err = store.Find(id)
+		if err != nil {
+			return fmt.Errorf("backup: verify %s - %s", id, err)
+		}
+		found.ID = t.ID
+		todos = append(todos, found)
+	}
+
+	if len(todos) != b.Count {
+		return fmt.Errorf("backup: expected %d todos but was %d", b.Count, len(todos))
+	}
+	if sum := Checksum(todos); b.Checksum != sum {
+		return fmt.Errorf("backup: checksum mismatch %s != %s", b.Checksum, sum)
+	}
+
+	return nil
+}
+
+// Checksum returns the SHA-256 checksum of the given todos,
+// regardless of their order. Creation dates are compared to the second,
+// as in Todo.Equal, since backends do not keep the same precision.
+func Checksum(todos Todos) string {
+	var sorted = make(Todos, len(todos))
+	copy(sorted, todos)
+	sort.Sort(byID(sorted))
+
+	var h = sha256.New()
+	for _, t := range sorted {
+		fmt.Fprintf(h, "%q\t%q\t%q\t%d\n", t.ID, t.Title, t.Status, t.Created.Unix())
+	}
+
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+type byID Todos
+
+func (b byID) Len() int {
+	return len(b)
+}
+func (b byID) Swap(i, j int) {
+	b[i], b[j] = b[j], b[i]
+}
+func (b byID) Less(i, j int) bool {
+	return b[i].ID < b[j].ID
+}
@@ -0,0 +1,110 @@
+package todo
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// Batch operation names.
+const (
+	OpCreate = "create"
+	OpUpdate = "update"
+	OpDelete = "delete"
+)
+
+// MaxBatch is the maximum number of operations accepted in a batch.
+const MaxBatch = 1000
+
+// Operation defines a single change of a batch.
+// The todo id is required to update or delete a todo.
+type Operation struct {
+	Op   string `json:"op"`
+	Todo Todo   `json:"todo"`
+}
+
+// Result defines the outcome of an operation of a batch.
+type Result struct {
+	Op     string `json:"op"`
+	Status int    `json:"status"`
+	Todo   *Todo  `json:"todo,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Results defines the outcome of a batch.
+type Results []Result
+
+// Failed returns the result of the operation which caused the batch to fail,
+// or nil if all operations succeeded.
+func (rs Results) Failed() *Result {
+	for i := range rs {
+		if rs[i].Status >= http.StatusBadRequest &&
+			rs[i].Status != http.StatusFailedDependency {
+			return &rs[i]
+		}
+	}
+	return nil
+}
+
+// validateBatch checks the given operations before running them.
+func validateBatch(ops []Operation) error {
+	if len(ops) == 0 {
+		return BadRequest{fmt.Errorf("batch: no operations")}
+	}
+	if len(ops) > MaxBatch {
+		return BadRequest{fmt.Errorf("batch: too many operations (max %d)", MaxBatch)}
+	}
+
+	for i, op := range ops {
+		switch op.Op {
+		case OpCreate:
+		case OpUpdate, OpDelete:
+			if len(op.Todo.ID) == 0 {
+				return BadRequest{fmt.Errorf("batch: operation %d: missing id", i)}
+			}
+		default:
+			return BadRequest{fmt.Errorf("batch: operation %d: unknown op %q", i, op.Op)}
+		}
+	}
+
+	return nil
+}
+
+// newResult returns the result of the given operation.
+func newResult(op Operation, t *Todo, err error) Result {
+	var result = Result{Op: op.Op}
+
+	switch err.(type) {
+	case nil:
+		switch op.Op {
+		case OpCreate:
+			result.Status = http.StatusCreated
+			result.Todo = t
+		case OpUpdate:
+			result.Status = http.StatusOK
+			result.Todo = t
+		case OpDelete:
+			result.Status = http.StatusNoContent
+		}
+		return result
+	case BadRequest:
+		result.Status = http.StatusBadRequest
+	case NotFound:
+		result.Status = http.StatusNotFound
+	default:
+		result.Status = http.StatusInternalServerError
+	}
+
+	result.Error = err.Error()
+	return result
+}
+
+// rollbackResults marks as failed the results of a rolled back batch.
+func rollbackResults(results Results) {
+	for i := range results {
+		if results[i].Status < http.StatusBadRequest {
+			results[i].Status = http.StatusFailedDependency
+			results[i].Todo = nil
+			results[i].Error = "batch: rolled back"
+		}
+	}
+}
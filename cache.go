@@ -0,0 +1,399 @@
+package todo
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Cache keys of the reads of a Store.
+const (
+	cacheList   = "list"
+	cacheFilter = "filter:"
+	cacheFind   = "find:"
+)
+
+// cacheRewatch is the delay before watching the changes of the todos again.
+const cacheRewatch = 5 * time.Second
+
+// TodoWatcher is a Store notifying the changes of its todos by any
+// instance, as the rethink store does with its changefeed.
+type TodoWatcher interface {
+	// WatchTodos calls fn with the id of each changed todo,
+	// until the context is done or the watch fails.
+	WatchTodos(ctx context.Context, fn func(id string)) error
+}
+
+// CacheStats are the statistics of a Cache, by Store operation.
+type CacheStats struct {
+	Hits      map[string]uint64
+	Misses    map[string]uint64
+	Evictions uint64
+	Entries   int
+}
+
+// Cache is an in-process LRU cache of the reads of a Store, List, Filter
+// and Find, expiring after a TTL, and invalidated by the mutations of
+// the Store. The lists are invalidated by any mutation, the todos found
+// by the mutations of these todos only.
+//
+// Only the mutations of the Store of the cache are seen: the changes
+// of the other instances are seen once expired, or as notified by a
+// TodoWatcher with Watch.
+type Cache struct {
+	size int
+	ttl  time.Duration
+
+	mu      sync.Mutex
+	gen     uint64
+	lru     *list.List
+	entries map[string]*list.Element
+	stats   CacheStats
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+// cacheEntry is a cached todo, or list of todos.
+type cacheEntry struct {
+	key     string
+	todo    Todo
+	todos   Todos
+	expires time.Time
+}
+
+// NewCache returns a new Cache of the given number of entries,
+// expiring after the given TTL, never if 0.
+func NewCache(size int, ttl time.Duration) *Cache {
+	return &Cache{
+		size:    size,
+		ttl:     ttl,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+		stats: CacheStats{
+			Hits:   make(map[string]uint64),
+			Misses: make(map[string]uint64),
+		},
+	}
+}
+
+// Store returns the given store caching its reads.
+func (c *Cache) Store(store Store) Store {
+	return cacheStore{store, c}
+}
+
+// Watch invalidates the todos changed by any instance, as notified by
+// the given watcher, until the cache is closed. The cache is flushed and
+// the watch retried when it fails.
+func (c *Cache) Watch(watcher TodoWatcher) {
+	var ctx, cancel = context.WithCancel(context.Background())
+	c.cancel, c.done = cancel, make(chan struct{})
+
+	go func() {
+		defer close(c.done)
+
+		for {
+			var err = watcher.WatchTodos(ctx, func(id string) {
+				c.invalidate(func(todo Todo) bool { return todo.ID == id })
+			})
+			if ctx.Err() != nil {
+				return
+			}
+
+			slog.Error("cache: watch", "err", err)
+			c.Flush()
+
+			select {
+			case <-time.After(cacheRewatch):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Close stops watching the changes of the todos, if watching.
+func (c *Cache) Close() error {
+	if c.cancel != nil {
+		c.cancel()
+		<-c.done
+	}
+	return nil
+}
+
+// Stats returns the statistics of the cache.
+func (c *Cache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var stats = CacheStats{
+		Hits:      make(map[string]uint64, len(c.stats.Hits)),
+		Misses:    make(map[string]uint64, len(c.stats.Misses)),
+		Evictions: c.stats.Evictions,
+		Entries:   c.lru.Len(),
+	}
+	for op, n := range c.stats.Hits {
+		stats.Hits[op] = n
+	}
+	for op, n := range c.stats.Misses {
+		stats.Misses[op] = n
+	}
+	return stats
+}
+
+// Flush removes all the entries.
+func (c *Cache) Flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+	c.lru.Init()
+	c.entries = make(map[string]*list.Element)
+}
+
+// get returns the entry of key, if cached and not expired,
+// counting a hit or a miss of op, and the generation of the cache
+// to put the entry read on a miss.
+func (c *Cache) get(op, key string) (cacheEntry, uint64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var el, ok = c.entries[key]
+	if ok && c.ttl != 0 && time.Now().After(el.Value.(*cacheEntry).expires) {
+		c.remove(el)
+		ok = false
+	}
+	if !ok {
+		c.stats.Misses[op]++
+		return cacheEntry{}, c.gen, false
+	}
+
+	c.stats.Hits[op]++
+	c.lru.MoveToFront(el)
+	return *el.Value.(*cacheEntry), c.gen, true
+}
+
+// put caches an entry read at the given generation, unless invalidated since,
+// and evicts the least recently used entries over the size of the cache.
+func (c *Cache) put(gen uint64, entry cacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if gen != c.gen {
+		return
+	}
+
+	entry.expires = time.Now().Add(c.ttl)
+	if el, ok := c.entries[entry.key]; ok {
+		*el.Value.(*cacheEntry) = entry
+		c.lru.MoveToFront(el)
+		return
+	}
+
+	c.entries[entry.key] = c.lru.PushFront(&entry)
+	for c.lru.Len() > c.size {
+		c.remove(c.lru.Back())
+		c.stats.Evictions++
+	}
+}
+
+// remove removes an entry. It requires c.mu held.
+func (c *Cache) remove(el *list.Element) {
+	c.lru.Remove(el)
+	delete(c.entries, el.Value.(*cacheEntry).key)
+}
+
+// invalidate removes the lists, and the todos found matching.
+func (c *Cache) invalidate(match func(Todo) bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+	for key, el := range c.entries {
+		if !strings.HasPrefix(key, cacheFind) || match(el.Value.(*cacheEntry).todo) {
+			c.remove(el)
+		}
+	}
+}
+
+// invalidateIDs removes the lists, and the todos of the given ids.
+func (c *Cache) invalidateIDs(ids ...string) {
+	var set = make(map[string]bool, len(ids))
+	for _, id := range ids {
+		set[id] = true
+	}
+	c.invalidate(func(todo Todo) bool { return set[todo.ID] })
+}
+
+// writeCacheStats writes the statistics of a cache in the Prometheus text format.
+func writeCacheStats(w io.Writer, stats CacheStats) {
+	var counters = []struct {
+		name   string
+		help   string
+		counts map[string]uint64
+	}{
+		{"todo_cache_hits_total", "Number of store reads served by the cache.", stats.Hits},
+		{"todo_cache_misses_total", "Number of store reads missed by the cache.", stats.Misses},
+	}
+
+	for _, c := range counters {
+		if len(c.counts) == 0 {
+			continue
+		}
+
+		var ops = make([]string, 0, len(c.counts))
+		for op := range c.counts {
+			ops = append(ops, op)
+		}
+		sort.Strings(ops)
+
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+		for _, op := range ops {
+			fmt.Fprintf(w, "%s{op=\"%s\"} %d\n", c.name, op, c.counts[op])
+		}
+	}
+
+	fmt.Fprintf(w, "# HELP todo_cache_evictions_total Number of entries evicted from the cache.\n"+
+		"# TYPE todo_cache_evictions_total counter\ntodo_cache_evictions_total %d\n", stats.Evictions)
+	fmt.Fprintf(w, "# HELP todo_cache_entries Number of entries in the cache.\n"+
+		"# TYPE todo_cache_entries gauge\ntodo_cache_entries %d\n", stats.Entries)
+}
+
+// copyTodos returns a copy of a cached list, for the caller to modify.
+func copyTodos(todos Todos) Todos {
+	return append(make(Todos, 0, len(todos)), todos...)
+}
+
+// cacheStore caches the reads of a Store.
+// The empty lists are not cached, as the stores list none on errors.
+type cacheStore struct {
+	Store
+	cache *Cache
+}
+
+// CacheStats returns the statistics of the cache, for the metrics.
+func (s cacheStore) CacheStats() CacheStats {
+	return s.cache.Stats()
+}
+
+func (s cacheStore) List() Todos {
+	return s.list("List", cacheList, s.Store.List)
+}
+
+func (s cacheStore) Filter(status string) Todos {
+	return s.list("Filter", cacheFilter+status, func() Todos { return s.Store.Filter(status) })
+}
+
+// list returns the cached list of key, or else the one read.
+func (s cacheStore) list(op, key string, read func() Todos) Todos {
+	var entry, gen, ok = s.cache.get(op, key)
+	if ok {
+		return copyTodos(entry.todos)
+	}
+
+	var todos = read()
+	if len(todos) != 0 {
+		s.cache.put(gen, cacheEntry{key: key, todos: copyTodos(todos)})
+	}
+	return todos
+}
+
+func (s cacheStore) Find(id string) (Todo, error) {
+	var entry, gen, ok = s.cache.get("Find", cacheFind+id)
+	if ok {
+		return entry.todo, nil
+	}
+
+	var todo, err = s.Store.Find(id)
+	if err == nil {
+		s.cache.put(gen, cacheEntry{key: cacheFind + id, todo: todo})
+	}
+	return todo, err
+}
+
+func (s cacheStore) Save(t *Todo) error {
+	var err = s.Store.Save(t)
+	if err == nil {
+		s.cache.invalidateIDs(t.ID)
+	}
+	return err
+}
+
+func (s cacheStore) Delete(id string) error {
+	var err = s.Store.Delete(id)
+	if err == nil {
+		s.cache.invalidateIDs(id)
+	}
+	return err
+}
+
+func (s cacheStore) Patch(id string, patch TodoPatch) (Todo, error) {
+	var todo, err = s.Store.Patch(id, patch)
+	if err == nil {
+		s.cache.invalidateIDs(id)
+	}
+	return todo, err
+}
+
+// Clear invalidates the todos found with the cleared status.
+func (s cacheStore) Clear(status string) (int64, error) {
+	var count, err = s.Store.Clear(status)
+	if err == nil && count != 0 {
+		s.cache.invalidate(func(todo Todo) bool { return todo.Status == status })
+	}
+	return count, err
+}
+
+// Toggle invalidates the todos found without the toggled status.
+func (s cacheStore) Toggle(status string) (int64, error) {
+	var count, err = s.Store.Toggle(status)
+	if err == nil && count != 0 {
+		s.cache.invalidate(func(todo Todo) bool { return todo.Status != status })
+	}
+	return count, err
+}
+
+// Batch invalidates the todos of the operations, even if failed,
+// as the operations of a failed batch may be applied partly.
+func (s cacheStore) Batch(ops []Operation) (Results, error) {
+	var results, err = s.Store.Batch(ops)
+
+	var ids = make([]string, 0, len(ops)+len(results))
+	for _, op := range ops {
+		ids = append(ids, op.Todo.ID)
+	}
+	for _, result := range results {
+		if result.Todo != nil {
+			ids = append(ids, result.Todo.ID)
+		}
+	}
+	s.cache.invalidateIDs(ids...)
+
+	return results, err
+}
+
+func (s cacheStore) Import(todos Todos) (int64, error) {
+	var count, err = s.Store.Import(todos)
+
+	var ids = make([]string, len(todos))
+	for i, todo := range todos {
+		ids[i] = todo.ID
+	}
+	s.cache.invalidateIDs(ids...)
+
+	return count, err
+}
+
+func (s cacheStore) CreateTable() {
+	s.Store.CreateTable()
+	s.cache.Flush()
+}
+
+func (s cacheStore) WithContext(ctx context.Context) Store {
+	return cacheStore{s.Store.WithContext(ctx), s.cache}
+}
@@ -0,0 +1,259 @@
+package todo
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// ChangesPath is the path of the change log.
+const ChangesPath = "/api/changes"
+
+// RouteChanges is the route name of the change log.
+const RouteChanges = "Changes.List"
+
+// DefaultChangeLogSize is the number of changes kept by the change log.
+const DefaultChangeLogSize = 1000
+
+// Change is a change of a todo, saved or deleted, in the change log.
+type Change struct {
+	Seq  int64     `json:"seq"`
+	Type string    `json:"type"`
+	ID   string    `json:"id"`
+	Todo *Todo     `json:"todo,omitempty"`
+	Time time.Time `json:"time"`
+}
+
+// ChangeFeed is the response of the change log since a cursor.
+// The feed is reset, with all the todos, when the changes since the
+// cursor are not known: a first sync, a bulk change, changes older than
+// the kept ones, or a cursor of a previous server run.
+type ChangeFeed struct {
+	Cursor  string   `json:"cursor"`
+	Reset   bool     `json:"reset,omitempty"`
+	Todos   Todos    `json:"todos,omitempty"`
+	Changes []Change `json:"changes"`
+}
+
+// ChangeLog keeps the last changes of a Store in memory,
+// to serve them since a cursor, as of GET /api/changes?since=.
+// The mutations of the store are serialized, so that the changes
+// are logged in the order of the store.
+type ChangeLog struct {
+	mu      sync.Mutex
+	store   Store
+	epoch   string
+	seq     int64
+	changes []Change
+	size    int
+}
+
+// NewChangeLog returns a new ChangeLog keeping the given number of changes.
+func NewChangeLog(size int) *ChangeLog {
+	var b = make([]byte, 8)
+	rand.Read(b)
+
+	return &ChangeLog{epoch: hex.EncodeToString(b), size: size}
+}
+
+// Store returns the given store logging its changes.
+func (l *ChangeLog) Store(store Store) Store {
+	l.store = store
+	return changeStore{store, l}
+}
+
+// NewChangeRouter creates a new mux.Router and defines
+// the HTTP method of the change log path.
+func NewChangeRouter() *mux.Router {
+	var router = mux.NewRouter()
+	router.Methods("GET").Path(ChangesPath).Name(RouteChanges)
+	return router
+}
+
+// Register registers the change log handler in the given router.
+func (l *ChangeLog) Register(router *mux.Router) {
+	router.Get(RouteChanges).Handler(ErrorFunc(l.List))
+}
+
+// List handles the listing of the changes since a cursor.
+func (l *ChangeLog) List(w http.ResponseWriter, r *http.Request) error {
+	var feed, err = l.Since(r.Context(), r.URL.Query().Get("since"))
+	if err != nil {
+		return err // 400
+	}
+	return writeJSON(w, feed, http.StatusOK) // 200
+}
+
+// Since returns the changes after the given cursor,
+// or all the todos when the feed is reset.
+func (l *ChangeLog) Since(ctx context.Context, cursor string) (ChangeFeed, error) {
+	var epoch, seq, err = parseCursor(cursor)
+	if err != nil {
+		return ChangeFeed{}, BadRequest{err}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var feed = ChangeFeed{Cursor: l.cursor(), Changes: make([]Change, 0)}
+
+	var oldest = l.seq - int64(len(l.changes))
+	if epoch != l.epoch || seq < oldest || seq > l.seq {
+		feed.Reset = true
+		feed.Todos = l.store.WithContext(ctx).List()
+		return feed, nil
+	}
+
+	feed.Changes = append(feed.Changes, l.changes[len(l.changes)-int(l.seq-seq):]...)
+	return feed, nil
+}
+
+// cursor returns the cursor of the last change. It requires l.mu held.
+func (l *ChangeLog) cursor() string {
+	return l.epoch + "." + strconv.FormatInt(l.seq, 10)
+}
+
+// parseCursor returns the epoch and the sequence of a cursor,
+// none for an empty cursor.
+func parseCursor(cursor string) (string, int64, error) {
+	if len(cursor) == 0 {
+		return "", 0, nil
+	}
+
+	var i = strings.LastIndexByte(cursor, '.')
+	if i < 0 {
+		return "", 0, fmt.Errorf("changes: invalid cursor %q", cursor)
+	}
+
+	var seq, err = strconv.ParseInt(cursor[i+1:], 10, 64)
+	if err != nil || seq < 0 {
+		return "", 0, fmt.Errorf("changes: invalid cursor %q", cursor)
+	}
+	return cursor[:i], seq, nil
+}
+
+// append logs a change. It requires l.mu held.
+func (l *ChangeLog) append(typ, id string, todo *Todo) {
+	l.seq++
+	if len(l.changes) == l.size {
+		copy(l.changes, l.changes[1:])
+		l.changes = l.changes[:len(l.changes)-1]
+	}
+	l.changes = append(l.changes, Change{Seq: l.seq, Type: typ, ID: id, Todo: todo, Time: time.Now().UTC()})
+}
+
+// bulk logs a bulk change, resetting the feeds. It requires l.mu held.
+func (l *ChangeLog) bulk() {
+	l.seq++
+	l.changes = l.changes[:0]
+}
+
+// changeStore logs the changes of a Store.
+type changeStore struct {
+	Store
+	log *ChangeLog
+}
+
+func (s changeStore) saved(todo Todo) {
+	s.log.append(LiveSaved, todo.ID, &todo)
+}
+
+func (s changeStore) Save(t *Todo) error {
+	s.log.mu.Lock()
+	defer s.log.mu.Unlock()
+
+	var err = s.Store.Save(t)
+	if err == nil {
+		s.saved(*t)
+	}
+	return err
+}
+
+func (s changeStore) Delete(id string) error {
+	s.log.mu.Lock()
+	defer s.log.mu.Unlock()
+
+	var err = s.Store.Delete(id)
+	if err == nil {
+		s.log.append(LiveDeleted, id, nil)
+	}
+	return err
+}
+
+func (s changeStore) Patch(id string, patch TodoPatch) (Todo, error) {
+	s.log.mu.Lock()
+	defer s.log.mu.Unlock()
+
+	var todo, err = s.Store.Patch(id, patch)
+	if err == nil {
+		s.saved(todo)
+	}
+	return todo, err
+}
+
+func (s changeStore) Clear(status string) (int64, error) {
+	s.log.mu.Lock()
+	defer s.log.mu.Unlock()
+
+	var count, err = s.Store.Clear(status)
+	if err == nil && count != 0 {
+		s.log.bulk()
+	}
+	return count, err
+}
+
+func (s changeStore) Toggle(status string) (int64, error) {
+	s.log.mu.Lock()
+	defer s.log.mu.Unlock()
+
+	var count, err = s.Store.Toggle(status)
+	if err == nil && count != 0 {
+		s.log.bulk()
+	}
+	return count, err
+}
+
+// Batch logs a bulk change when failed, as the operations of a failed
+// batch may be applied partly.
+func (s changeStore) Batch(ops []Operation) (Results, error) {
+	s.log.mu.Lock()
+	defer s.log.mu.Unlock()
+
+	var results, err = s.Store.Batch(ops)
+	if err != nil {
+		s.log.bulk()
+		return results, err
+	}
+
+	for i, result := range results {
+		if result.Op == OpDelete {
+			s.log.append(LiveDeleted, ops[i].Todo.ID, nil)
+		} else if result.Todo != nil {
+			s.saved(*result.Todo)
+		}
+	}
+	return results, err
+}
+
+func (s changeStore) Import(todos Todos) (int64, error) {
+	s.log.mu.Lock()
+	defer s.log.mu.Unlock()
+
+	var count, err = s.Store.Import(todos)
+	if err == nil && count != 0 {
+		s.log.bulk()
+	}
+	return count, err
+}
+
+func (s changeStore) WithContext(ctx context.Context) Store {
+	return changeStore{s.Store.WithContext(ctx), s.log}
+}
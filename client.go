@@ -2,63 +2,321 @@ package todo
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"mime"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
 )
 
+// Client errors, matched with errors.Is by the StatusError
+// of the responses of their status.
+var (
+	ErrNotFound   = errors.New("client: not found")    // 404
+	ErrConflict   = errors.New("client: conflict")     // 409, 412
+	ErrValidation = errors.New("client: invalid todo") // 400, 422
+)
+
+// StatusError is an unexpected response status of the api,
+// with the error message of the response.
+type StatusError struct {
+	Status  int
+	Message string
+}
+
+func (e *StatusError) Error() string {
+	if len(e.Message) == 0 {
+		return fmt.Sprintf("client: %d %s", e.Status, http.StatusText(e.Status))
+	}
+	return fmt.Sprintf("client: %d %s: %s", e.Status, http.StatusText(e.Status), e.Message)
+}
+
+// Is reports whether the status is the one of the given client error.
+func (e *StatusError) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.Status == http.StatusNotFound
+	case ErrConflict:
+		return e.Status == http.StatusConflict || e.Status == http.StatusPreconditionFailed
+	case ErrValidation:
+		return e.Status == http.StatusBadRequest || e.Status == http.StatusUnprocessableEntity
+	}
+	return false
+}
+
+// DefaultClientTimeout is the timeout of the requests of the default http client.
+const DefaultClientTimeout = 30 * time.Second
+
+// maxErrorMessage bounds the error message read from a response.
+const maxErrorMessage = 512
+
 // Client communicates with the application API.
+// A Client is safe for concurrent use. It is configured by the options
+// given to NewClient, and its fields must not be changed after.
 type Client struct {
 	BaseURL string
-	router  *mux.Router
-	// context
-	Status int
+	Version string
+
+	router     *mux.Router
+	http       *http.Client
+	token      string
+	userAgent  string
+	maxRetries int
+	backoff    time.Duration
+	ctx        context.Context
+	key        string
+}
+
+// ClientOption configures a Client.
+type ClientOption func(*Client)
+
+// WithHTTPClient sets the http client sending the requests,
+// instead of one with DefaultClientTimeout.
+func WithHTTPClient(client *http.Client) ClientOption {
+	return func(c *Client) { c.http = client }
+}
+
+// WithToken sets the bearer token authenticating the requests.
+func WithToken(token string) ClientOption {
+	return func(c *Client) { c.token = token }
+}
+
+// WithUserAgent sets the User-Agent header of the requests.
+func WithUserAgent(userAgent string) ClientOption {
+	return func(c *Client) { c.userAgent = userAgent }
+}
+
+// WithVersion uses the paths of the given api version,
+// instead of the unversioned ones.
+func WithVersion(version string) ClientOption {
+	return func(c *Client) {
+		c.Version = version
+		c.router = NewRouterVersion(version)
+	}
+}
+
+// WithRetries sets the number of times a request is retried, and the
+// delay before the first retry, doubled for each next one. Rate limited
+// requests are retried, and idempotent requests are retried on network
+// errors and unavailable servers as well. 0 retries disables them.
+func WithRetries(retries int, backoff time.Duration) ClientOption {
+	return func(c *Client) {
+		c.maxRetries = retries
+		c.backoff = backoff
+	}
+}
+
+// NewClient creates a new todo client with specified baseURL,
+// using the unversioned api paths, configured by the given options.
+func NewClient(baseURL string, options ...ClientOption) *Client {
+	var c = &Client{
+		BaseURL:    baseURL,
+		Version:    APIv1,
+		router:     NewRouter(),
+		http:       &http.Client{Timeout: DefaultClientTimeout},
+		userAgent:  "todo-client/" + Version,
+		maxRetries: 3,
+		backoff:    100 * time.Millisecond,
+		ctx:        context.Background(),
+	}
+	for _, option := range options {
+		option(c)
+	}
+	return c
+}
+
+// NewClientVersion creates a new todo client with specified baseURL,
+// using the paths of the given api version.
+func NewClientVersion(baseURL, version string, options ...ClientOption) *Client {
+	return NewClient(baseURL, append([]ClientOption{WithVersion(version)}, options...)...)
+}
+
+// WithContext returns a copy of the client whose calls are cancelled
+// with the given context, and traced within its span.
+func (c *Client) WithContext(ctx context.Context) *Client {
+	var copy = *c
+	copy.ctx = ctx
+	return &copy
+}
+
+// WithIdempotencyKey returns a copy of the client sending the given key
+// with its POST requests, for the server to process them once. The keyed
+// requests are retried as idempotent ones.
+func (c *Client) WithIdempotencyKey(key string) *Client {
+	var copy = *c
+	copy.key = key
+	return &copy
+}
+
+// response is a response read by the client.
+type response struct {
+	status int
+	header http.Header
 	body   []byte
 }
 
-// NewClient creates a new todo client with specified baseURL.
-func NewClient(baseURL string) *Client {
-	return &Client{
-		BaseURL: baseURL,
-		router:  NewRouter(),
+// check returns a StatusError unless the response status is one of the expected.
+func (res response) check(expected ...int) error {
+	for _, status := range expected {
+		if res.status == status {
+			return nil
+		}
 	}
+	return &StatusError{Status: res.status, Message: res.message()}
 }
 
-func (c *Client) do(method, url string, payload interface{}) error {
-	c.Status = http.StatusBadRequest
-	c.body = []byte{}
+// message returns the error message of the response, the detail of a problem
+// or else the text of the body.
+func (res response) message() string {
+	var mtype, _, _ = mime.ParseMediaType(res.header.Get("Content-Type"))
+	if mtype == ProblemType {
+		var problem Problem
+		if json.Unmarshal(res.body, &problem) == nil {
+			return problem.Detail
+		}
+	}
 
-	var body io.Reader
-	if payload != nil {
-		var b, err = json.Marshal(payload)
-		if err != nil {
-			return err
+	var text = strings.TrimSpace(string(res.body))
+	if len(text) > maxErrorMessage {
+		text = text[:maxErrorMessage]
+	}
+	return text
+}
+
+// do sends the payload encoded as JSON, if any.
+func (c *Client) do(method, url string, payload interface{}) (response, error) {
+	if payload == nil {
+		return c.send(method, url, "", nil)
+	}
+
+	var data, err = json.Marshal(payload)
+	if err != nil {
+		return response{}, err
+	}
+	return c.send(method, url, "application/json", data)
+}
+
+// send sends a request, and retries it while rate limited, or if
+// idempotent, on network errors and unavailable servers.
+func (c *Client) send(method, url, ctype string, data []byte) (response, error) {
+	for retry := 0; ; retry++ {
+		var res, err = c.roundTrip(method, url, ctype, data)
+		if retry >= c.maxRetries || !retryable(method, len(c.key) != 0, res, err) || c.ctx.Err() != nil {
+			return res, err
+		}
+
+		select {
+		case <-time.After(c.retryAfter(res.header, retry)):
+		case <-c.ctx.Done():
+			return res, c.ctx.Err()
 		}
-		body = bytes.NewReader(b)
+	}
+}
+
+// retryable returns whether a request may be retried after the given outcome.
+// Rate limited requests were not processed.
+func retryable(method string, keyed bool, res response, err error) bool {
+	if err == nil && res.status == http.StatusTooManyRequests {
+		return true
+	}
+
+	switch {
+	case method == "GET", method == "HEAD", method == "PUT", method == "DELETE", method == "OPTIONS":
+	case method == "POST" && keyed:
+	default:
+		return false
 	}
 
-	var req, err = http.NewRequest(method, url, body)
 	if err != nil {
-		return err
+		return true
 	}
+	switch res.status {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
 
-	if body != nil {
-		req.Header.Set("Content-Type", "application/json")
+func (c *Client) roundTrip(method, url, ctype string, data []byte) (response, error) {
+	var body io.Reader
+	if data != nil {
+		body = bytes.NewReader(data)
 	}
 
-	res, err := http.DefaultClient.Do(req)
-	c.Status = res.StatusCode
+	var req, err = http.NewRequestWithContext(c.ctx, method, url, body)
 	if err != nil {
-		return err
+		return response{}, err
+	}
+
+	if data != nil {
+		req.Header.Set("Content-Type", ctype)
+	}
+	req.Header.Set("Accept", "application/json")
+	if len(c.userAgent) != 0 {
+		req.Header.Set("User-Agent", c.userAgent)
+	}
+	if len(c.token) != 0 {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	if len(c.key) != 0 && method == "POST" {
+		req.Header.Set(IdempotencyHeader, c.key)
+	}
+
+	var _, span = StartSpan(c.ctx, "HTTP "+method, SpanClient)
+	span.SetAttribute("http.method", method)
+	span.SetAttribute("http.url", url)
+	if span != nil {
+		req.Header.Set(TraceparentHeader, span.Traceparent())
+	} else if parent := SpanFromContext(c.ctx); parent != nil {
+		req.Header.Set(TraceparentHeader, parent.Traceparent())
+	}
+
+	res, err := c.http.Do(req)
+	if err != nil {
+		span.Finish(err)
+		return response{}, err
 	}
 	defer res.Body.Close()
 
-	c.body, err = ioutil.ReadAll(res.Body)
-	return err
+	span.SetAttribute("http.status_code", fmt.Sprint(res.StatusCode))
+	if res.StatusCode >= http.StatusInternalServerError {
+		span.Finish(errors.New(res.Status))
+	} else {
+		span.Finish(nil)
+	}
+
+	var r = response{status: res.StatusCode, header: res.Header}
+	r.body, err = ioutil.ReadAll(res.Body)
+	return r, err
+}
+
+// maxRetryAfter bounds the delay before retrying a request.
+const maxRetryAfter = 30 * time.Second
+
+// retryAfter returns the delay given by the Retry-After header,
+// or else an exponential backoff delay.
+func (c *Client) retryAfter(header http.Header, retry int) time.Duration {
+	var delay = c.backoff << uint(retry)
+
+	var seconds, err = strconv.Atoi(header.Get("Retry-After"))
+	if err == nil && seconds >= 0 {
+		delay = time.Duration(seconds) * time.Second
+	}
+
+	if delay > maxRetryAfter || delay < 0 {
+		delay = maxRetryAfter
+	}
+	return delay
 }
 
 // GET /api/todos
@@ -66,35 +324,85 @@ func (c *Client) List() (Todos, error) {
 	var path, _ = c.router.Get(RouteList).URLPath()
 	var url = c.BaseURL + path.String()
 
-	var todos = make(Todos, 0)
+	return c.list(url)
+}
+
+// POST /api/todos
+func (c *Client) Create(todo *Todo) error {
+	var path, _ = c.router.Get(RouteCreate).URLPath()
+	var url = c.BaseURL + path.String()
 
-	var err = c.do("GET", url, nil)
+	var res, err = c.do("POST", url, todo)
+	if err == nil {
+		err = res.check(http.StatusCreated)
+	}
 	if err != nil {
-		return todos, err
+		return err
 	}
 
-	if c.Status == http.StatusOK {
-		err = json.Unmarshal(c.body, &todos)
+	return json.Unmarshal(res.body, todo)
+}
+
+// POST /api/todos/batch
+// The results are returned along with the error of a failed batch.
+func (c *Client) Batch(ops []Operation) (Results, error) {
+	var path, _ = c.router.Get(RouteBatch).URLPath()
+	var url = c.BaseURL + path.String()
+
+	var results = make(Results, 0)
+
+	var res, err = c.do("POST", url, ops)
+	if err != nil {
+		return results, err
+	}
+
+	if len(res.body) != 0 && res.body[0] == '[' {
+		err = json.Unmarshal(res.body, &results)
+		if err != nil {
+			return results, err
+		}
 	}
 
-	return todos, err
+	return results, res.check(http.StatusOK)
 }
 
-// POST /api/todos
-func (c *Client) Create(todo *Todo) error {
-	var path, _ = c.router.Get(RouteCreate).URLPath()
+// GET /api/todos/export
+func (c *Client) Export(format string) ([]byte, error) {
+	var path, _ = c.router.Get(RouteExport).URLPath()
+	var url = c.BaseURL + path.String() + "?format=" + format
+
+	var res, err = c.send("GET", url, "", nil)
+	if err == nil {
+		err = res.check(http.StatusOK)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return res.body, nil
+}
+
+// POST /api/todos/import
+// The line errors are returned along with ErrValidation.
+func (c *Client) Import(format string, data []byte) (Imported, error) {
+	var path, _ = c.router.Get(RouteImport).URLPath()
 	var url = c.BaseURL + path.String()
 
-	var err = c.do("POST", url, todo)
+	var imported Imported
+
+	var res, err = c.send("POST", url, FormatType(format), data)
 	if err != nil {
-		return err
+		return imported, err
 	}
 
-	if c.Status == http.StatusCreated {
-		err = json.Unmarshal(c.body, todo)
+	if len(res.body) != 0 && res.body[0] == '{' {
+		err = json.Unmarshal(res.body, &imported)
+		if err != nil {
+			return imported, err
+		}
 	}
 
-	return err
+	return imported, res.check(http.StatusOK)
 }
 
 // GET /api/todos/{id}
@@ -105,16 +413,15 @@ func (c *Client) Find(id string) (Todo, error) {
 
 	var todo = Todo{}
 
-	var err = c.do("GET", url, nil)
+	var res, err = c.do("GET", url, nil)
+	if err == nil {
+		err = res.check(http.StatusOK)
+	}
 	if err != nil {
 		return todo, err
 	}
-	// println(string(c.body))
-
-	if c.Status == http.StatusOK {
-		err = json.Unmarshal(c.body, &todo)
-	}
 
+	err = json.Unmarshal(res.body, &todo)
 	return todo, err
 }
 
@@ -124,16 +431,40 @@ func (c *Client) Update(todo *Todo) error {
 	var path, _ = c.router.Get(RouteUpdate).URLPath(pairs...)
 	var url = c.BaseURL + path.String()
 
-	var err = c.do("PUT", url, todo)
+	var res, err = c.do("PUT", url, todo)
+	if err == nil {
+		err = res.check(http.StatusOK)
+	}
 	if err != nil {
 		return err
 	}
 
-	if c.Status == http.StatusOK {
-		err = json.Unmarshal(c.body, todo)
+	return json.Unmarshal(res.body, todo)
+}
+
+// PATCH /api/todos/{id}
+func (c *Client) Patch(id string, patch TodoPatch) (Todo, error) {
+	var pairs = []string{"id", id}
+	var path, _ = c.router.Get(RoutePatch).URLPath(pairs...)
+	var url = c.BaseURL + path.String()
+
+	var todo = Todo{}
+
+	var data, err = json.Marshal(patch)
+	if err != nil {
+		return todo, err
 	}
 
-	return err
+	res, err := c.send("PATCH", url, MergePatchType, data)
+	if err == nil {
+		err = res.check(http.StatusOK)
+	}
+	if err != nil {
+		return todo, err
+	}
+
+	err = json.Unmarshal(res.body, &todo)
+	return todo, err
 }
 
 // DELETE /api/todos/{id}
@@ -142,8 +473,11 @@ func (c *Client) Delete(id string) error {
 	var path, _ = c.router.Get(RouteDelete).URLPath(pairs...)
 	var url = c.BaseURL + path.String()
 
-	var err = c.do("DELETE", url, nil)
-	return err
+	var res, err = c.do("DELETE", url, nil)
+	if err != nil {
+		return err
+	}
+	return res.check(http.StatusNoContent, http.StatusOK)
 }
 
 // GET /api/todos/status/{status}
@@ -152,18 +486,39 @@ func (c *Client) Filter(status string) (Todos, error) {
 	var path, _ = c.router.Get(RouteFilter).URLPath(pairs...)
 	var url = c.BaseURL + path.String()
 
+	return c.list(url)
+}
+
+// list returns the todos listed at url, following the v2 pages.
+func (c *Client) list(url string) (Todos, error) {
 	var todos = make(Todos, 0)
 
-	var err = c.do("GET", url, nil)
-	if err != nil {
-		return todos, err
-	}
+	for {
+		var res, err = c.do("GET", url, nil)
+		if err == nil {
+			err = res.check(http.StatusOK)
+		}
+		if err != nil {
+			return todos, err
+		}
 
-	if c.Status == http.StatusOK {
-		err = json.Unmarshal(c.body, &todos)
-	}
+		if c.Version != APIv2 {
+			err = json.Unmarshal(res.body, &todos)
+			return todos, err
+		}
+
+		var page Page
+		err = json.Unmarshal(res.body, &page)
+		if err != nil {
+			return todos, err
+		}
 
-	return todos, err
+		todos = append(todos, page.Items...)
+		if len(page.Next) == 0 {
+			return todos, nil
+		}
+		url = c.BaseURL + page.Next
+	}
 }
 
 // DELETE /api/todos/status/{status}
@@ -172,68 +527,118 @@ func (c *Client) Clear(status string) (int64, error) {
 	var path, _ = c.router.Get(RouteClear).URLPath(pairs...)
 	var url = c.BaseURL + path.String()
 
-	var err = c.do("DELETE", url, nil)
-	if err != nil {
-		return 0, err
-	}
+	return c.count("DELETE", url)
+}
 
-	if c.Status != http.StatusOK {
-		return 0, fmt.Errorf("client: expected response status %d but was %d",
-			http.StatusOK, c.Status)
-	}
+// PATCH /api/todos/status/{status}
+func (c *Client) Toggle(status string) (int64, error) {
+	var pairs = []string{"status", status}
+	var path, _ = c.router.Get(RouteToggle).URLPath(pairs...)
+	var url = c.BaseURL + path.String()
+
+	return c.count("PATCH", url)
+}
+
+// GET /api/changes?since={cursor}
+func (c *Client) Changes(since string) (ChangeFeed, error) {
+	var query = url.Values{"since": {since}}
+	var feed ChangeFeed
 
-	var result map[string]interface{}
-	err = json.Unmarshal(c.body, &result)
+	var res, err = c.do("GET", c.BaseURL+ChangesPath+"?"+query.Encode(), nil)
+	if err == nil {
+		err = res.check(http.StatusOK)
+	}
 	if err != nil {
-		return 0, err
+		return feed, err
 	}
 
-	value, ok := result["count"]
-	if !ok {
-		return 0, fmt.Errorf("client: expected count value")
+	err = json.Unmarshal(res.body, &feed)
+	return feed, err
+}
+
+// GET /api/live
+// Watch returns the messages of the live channel of the given peer name,
+// from the snapshot of the todos on. The channel is closed once the
+// connection is, or the context of the client done.
+func (c *Client) Watch(name string) (<-chan LiveMessage, error) {
+	var query = url.Values{"name": {name}}
+	var u = "ws" + strings.TrimPrefix(c.BaseURL, "http") + "/api/live?" + query.Encode()
+
+	var header = http.Header{}
+	if len(c.userAgent) != 0 {
+		header.Set("User-Agent", c.userAgent)
+	}
+	if len(c.token) != 0 {
+		header.Set("Authorization", "Bearer "+c.token)
 	}
 
-	number, ok := value.(float64)
-	if !ok {
-		return 0, fmt.Errorf("client: expected count as float64")
+	var dialer = websocket.Dialer{
+		Proxy:            http.ProxyFromEnvironment,
+		HandshakeTimeout: c.http.Timeout,
+	}
+	var conn, res, err = dialer.DialContext(c.ctx, u, header)
+	if err == websocket.ErrBadHandshake {
+		var body, _ = ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		return nil, response{status: res.StatusCode, header: res.Header, body: body}.check()
+	}
+	if err != nil {
+		return nil, err
 	}
 
-	count := int64(number)
-	return count, nil
-}
+	var messages = make(chan LiveMessage, liveSendQueue)
+	var done = make(chan struct{})
+	go func() {
+		select {
+		case <-c.ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	go func() {
+		defer close(messages)
+		defer close(done)
+		defer conn.Close()
+
+		for {
+			var msg LiveMessage
+			var err = conn.ReadJSON(&msg)
+			if err != nil {
+				return
+			}
+
+			select {
+			case messages <- msg:
+			case <-c.ctx.Done():
+				return
+			}
+		}
+	}()
 
-// PATCH /api/todos/status/{status}
-func (c *Client) Toggle(status string) (int64, error) {
-	var pairs = []string{"status", status}
-	var path, _ = c.router.Get(RouteToggle).URLPath(pairs...)
-	var url = c.BaseURL + path.String()
+	return messages, nil
+}
 
-	var err = c.do("PATCH", url, nil)
+// count returns the count of the todos changed at url.
+func (c *Client) count(method, url string) (int64, error) {
+	var res, err = c.do(method, url, nil)
+	if err == nil {
+		err = res.check(http.StatusOK)
+	}
 	if err != nil {
 		return 0, err
 	}
 
-	if c.Status != http.StatusOK {
-		return 0, fmt.Errorf("client: expected response status %d but was %d",
-			http.StatusOK, c.Status)
+	var result struct {
+		Count *int64 `json:"count"`
 	}
-
-	var result map[string]interface{}
-	err = json.Unmarshal(c.body, &result)
+	err = json.Unmarshal(res.body, &result)
 	if err != nil {
 		return 0, err
 	}
-
-	value, ok := result["count"]
-	if !ok {
+	if result.Count == nil {
 		return 0, fmt.Errorf("client: expected count value")
 	}
 
-	number, ok := value.(float64)
-	if !ok {
-		return 0, fmt.Errorf("client: expected count as float64")
-	}
-
-	count := int64(number)
-	return count, nil
+	return *result.Count, nil
 }
@@ -0,0 +1,396 @@
+package todo
+
+import (
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Apache access log formats; application logs use logfmt.
+const (
+	LogCommon   = "common"
+	LogCombined = "combined"
+	LogNone     = "none"
+)
+
+// Config defines the server configuration.
+type Config struct {
+	Driver       string        `yaml:"driver"`
+	URL          string        `yaml:"url"`
+	Addr         string        `yaml:"addr"`
+	GRPCAddr     string        `yaml:"grpc_addr"`
+	StaticDir    string        `yaml:"static_dir"`
+	Dev          bool          `yaml:"dev"`
+	LogFormat    string        `yaml:"log_format"`
+	LogLevel     string        `yaml:"log_level"`
+	ReadTimeout  time.Duration `yaml:"read_timeout"`
+	WriteTimeout time.Duration `yaml:"write_timeout"`
+	IdleTimeout  time.Duration `yaml:"idle_timeout"`
+
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	TLSCert         string        `yaml:"tls_cert"`
+	TLSKey          string        `yaml:"tls_key"`
+	Trace           string        `yaml:"trace"`
+	RateRead        string        `yaml:"rate_read"`
+	RateWrite       string        `yaml:"rate_write"`
+	CORSOrigins     string        `yaml:"cors_origins"`
+	CORSMethods     string        `yaml:"cors_methods"`
+	CORSHeaders     string        `yaml:"cors_headers"`
+	CORSCredentials bool          `yaml:"cors_credentials"`
+	CSRFKey         string        `yaml:"csrf_key" secret:"true"`
+	Tokens          string        `yaml:"tokens" secret:"true"`
+	CacheSize       int           `yaml:"cache_size"`
+	CacheTTL        time.Duration `yaml:"cache_ttl"`
+	Webhooks        bool          `yaml:"webhooks"`
+	WebhookAllow    string        `yaml:"webhook_allow"`
+}
+
+// defaultURLs are the default store urls, by driver.
+var defaultURLs = map[string]string{
+	"rethink": "localhost:28015/test",
+	"sqlite3": "todo.sqlite",
+}
+
+// DefaultConfig returns the default configuration.
+func DefaultConfig() Config {
+	return Config{
+		Driver:       "rethink",
+		URL:          defaultURLs["rethink"],
+		Addr:         ":8000",
+		StaticDir:    "polymer",
+		LogFormat:    LogCommon,
+		LogLevel:     "info",
+		ReadTimeout:  15 * time.Second,
+		WriteTimeout: 15 * time.Second,
+		IdleTimeout:  60 * time.Second,
+
+		ShutdownTimeout: 30 * time.Second,
+		CacheTTL:        time.Minute,
+	}
+}
+
+// ParseConfig parses the given command-line arguments and returns the
+// configuration merged from, by increasing precedence: the defaults,
+// the configuration file, the environment variables and the flags.
+func ParseConfig(flags *flag.FlagSet, args []string) (Config, error) {
+	var parsed = DefaultConfig()
+	parsed.bind(flags)
+
+	var file = flags.String("config", os.Getenv("TODO_CONFIG"),
+		"YAML configuration file (TODO_CONFIG)")
+
+	var err = flags.Parse(args)
+	if err != nil {
+		return parsed, err
+	}
+
+	var c = DefaultConfig()
+	c.URL = ""
+
+	if len(*file) != 0 {
+		err = c.LoadFile(*file)
+		if err != nil {
+			return c, err
+		}
+	}
+
+	err = c.LoadEnv()
+	if err != nil {
+		return c, err
+	}
+
+	// flags set on the command line
+	var set = flag.NewFlagSet(flags.Name(), flag.ContinueOnError)
+	c.bind(set)
+
+	flags.Visit(func(f *flag.Flag) {
+		if set.Lookup(f.Name) != nil {
+			set.Set(f.Name, f.Value.String())
+		}
+	})
+
+	c.defaultURL()
+	return c, c.Validate()
+}
+
+// defaultURL sets the default url of the driver, when no url is set.
+func (c *Config) defaultURL() {
+	if len(c.URL) == 0 {
+		c.URL = defaultURLs[c.Driver]
+	}
+}
+
+// bind defines flags setting the configuration fields.
+func (c *Config) bind(flags *flag.FlagSet) {
+	flags.StringVar(&c.Driver, "driver", c.Driver, "store driver, rethink or sqlite3 (TODO_DRIVER)")
+	flags.StringVar(&c.URL, "url", c.URL, "store url, todo.sqlite by default with sqlite3 (DATABASE_URL)")
+	flags.StringVar(&c.Addr, "addr", c.Addr, "listen address (TODO_ADDR, or PORT)")
+	flags.StringVar(&c.GRPCAddr, "grpc-addr", c.GRPCAddr, "gRPC listen address, disabled if empty (TODO_GRPC_ADDR)")
+	flags.StringVar(&c.StaticDir, "static", c.StaticDir, "static files directory, served in dev mode (TODO_STATIC_DIR)")
+	flags.BoolVar(&c.Dev, "dev", c.Dev, "serve the static files from disk, for live editing, instead of the embedded ones (TODO_DEV)")
+	flags.StringVar(&c.LogFormat, "log-format", c.LogFormat, "log format, common, combined, json, logfmt or none (TODO_LOG_FORMAT)")
+	flags.StringVar(&c.LogLevel, "log-level", c.LogLevel, "log level, debug, info, warn or error (TODO_LOG_LEVEL)")
+	flags.DurationVar(&c.ReadTimeout, "read-timeout", c.ReadTimeout, "request read timeout (TODO_READ_TIMEOUT)")
+	flags.DurationVar(&c.WriteTimeout, "write-timeout", c.WriteTimeout, "response write timeout (TODO_WRITE_TIMEOUT)")
+	flags.DurationVar(&c.IdleTimeout, "idle-timeout", c.IdleTimeout, "keep-alive idle timeout (TODO_IDLE_TIMEOUT)")
+	flags.DurationVar(&c.ShutdownTimeout, "shutdown-timeout", c.ShutdownTimeout, "graceful shutdown deadline (TODO_SHUTDOWN_TIMEOUT)")
+	flags.StringVar(&c.TLSCert, "tls-cert", c.TLSCert, "TLS certificate file, reloaded when changed (TODO_TLS_CERT)")
+	flags.StringVar(&c.TLSKey, "tls-key", c.TLSKey, "TLS private key file, reloaded when changed (TODO_TLS_KEY)")
+	flags.StringVar(&c.Trace, "trace", c.Trace, "trace exporter, OTLP/HTTP collector url or file:path (TODO_TRACE)")
+	flags.StringVar(&c.RateRead, "rate-read", c.RateRead, "api read rate limit per client, as requests/period (TODO_RATE_READ)")
+	flags.StringVar(&c.RateWrite, "rate-write", c.RateWrite, "api write rate limit per client, as requests/period (TODO_RATE_WRITE)")
+	flags.StringVar(&c.CORSOrigins, "cors-origins", c.CORSOrigins, "comma separated origins allowed to call the api, or * (TODO_CORS_ORIGINS)")
+	flags.StringVar(&c.CORSMethods, "cors-methods", c.CORSMethods, "comma separated methods allowed to other origins (TODO_CORS_METHODS)")
+	flags.StringVar(&c.CORSHeaders, "cors-headers", c.CORSHeaders, "comma separated request headers allowed to other origins (TODO_CORS_HEADERS)")
+	flags.BoolVar(&c.CORSCredentials, "cors-credentials", c.CORSCredentials, "allow credentials in cross-origin requests (TODO_CORS_CREDENTIALS)")
+	flags.StringVar(&c.CSRFKey, "csrf-key", c.CSRFKey, "hex encoded 32 bytes key of the html forms CSRF tokens, random if empty (TODO_CSRF_KEY)")
+	flags.StringVar(&c.Tokens, "tokens", c.Tokens, "comma separated user:token bearer tokens of the api users, keying their rate limits (TODO_TOKENS)")
+	flags.IntVar(&c.CacheSize, "cache-size", c.CacheSize, "number of store reads cached, disabled if 0 (TODO_CACHE_SIZE)")
+	flags.DurationVar(&c.CacheTTL, "cache-ttl", c.CacheTTL, "store reads cache expiration, never if 0 (TODO_CACHE_TTL)")
+	flags.BoolVar(&c.Webhooks, "webhooks", c.Webhooks, "enable the webhooks api and deliveries (TODO_WEBHOOKS)")
+	flags.StringVar(&c.WebhookAllow, "webhook-allow", c.WebhookAllow, "comma separated private networks allowed as webhook destinations (TODO_WEBHOOK_ALLOW)")
+}
+
+// LoadFile reads the given YAML configuration file.
+// Unknown keys are reported as errors.
+func (c *Config) LoadFile(name string) error {
+	var data, err = ioutil.ReadFile(name)
+	if err != nil {
+		return err
+	}
+
+	err = yaml.UnmarshalStrict(data, c)
+	if err != nil {
+		return fmt.Errorf("config: %s: %s", name, err)
+	}
+	return nil
+}
+
+// LoadEnv reads the configuration environment variables.
+func (c *Config) LoadEnv() error {
+	var strs = []struct {
+		name  string
+		value *string
+	}{
+		{"TODO_DRIVER", &c.Driver},
+		{"DATABASE_URL", &c.URL},
+		{"TODO_STATIC_DIR", &c.StaticDir},
+		{"TODO_LOG_FORMAT", &c.LogFormat},
+		{"TODO_LOG_LEVEL", &c.LogLevel},
+		{"TODO_TLS_CERT", &c.TLSCert},
+		{"TODO_TLS_KEY", &c.TLSKey},
+		{"TODO_TRACE", &c.Trace},
+		{"TODO_RATE_READ", &c.RateRead},
+		{"TODO_RATE_WRITE", &c.RateWrite},
+		{"TODO_CORS_ORIGINS", &c.CORSOrigins},
+		{"TODO_CORS_METHODS", &c.CORSMethods},
+		{"TODO_CORS_HEADERS", &c.CORSHeaders},
+		{"TODO_CSRF_KEY", &c.CSRFKey},
+		{"TODO_GRPC_ADDR", &c.GRPCAddr},
+		{"TODO_TOKENS", &c.Tokens},
+		{"TODO_WEBHOOK_ALLOW", &c.WebhookAllow},
+	}
+
+	for _, env := range strs {
+		if value := getenv(env.name); len(value) != 0 {
+			*env.value = value
+		}
+	}
+
+	if port := getenv("PORT"); len(port) != 0 {
+		c.Addr = ":" + port
+	}
+	if addr := getenv("TODO_ADDR"); len(addr) != 0 {
+		c.Addr = addr
+	}
+
+	var bools = []struct {
+		name  string
+		value *bool
+	}{
+		{"TODO_DEV", &c.Dev},
+		{"TODO_CORS_CREDENTIALS", &c.CORSCredentials},
+		{"TODO_WEBHOOKS", &c.Webhooks},
+	}
+
+	for _, env := range bools {
+		if value := getenv(env.name); len(value) != 0 {
+			var b, err = strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("config: %s: %s", env.name, err)
+			}
+			*env.value = b
+		}
+	}
+
+	if value := getenv("TODO_CACHE_SIZE"); len(value) != 0 {
+		var n, err = strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("config: TODO_CACHE_SIZE: %s", err)
+		}
+		c.CacheSize = n
+	}
+
+	var durations = []struct {
+		name  string
+		value *time.Duration
+	}{
+		{"TODO_READ_TIMEOUT", &c.ReadTimeout},
+		{"TODO_WRITE_TIMEOUT", &c.WriteTimeout},
+		{"TODO_IDLE_TIMEOUT", &c.IdleTimeout},
+		{"TODO_SHUTDOWN_TIMEOUT", &c.ShutdownTimeout},
+		{"TODO_CACHE_TTL", &c.CacheTTL},
+	}
+
+	for _, env := range durations {
+		var value = getenv(env.name)
+		if len(value) == 0 {
+			continue
+		}
+
+		var d, err = time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("config: %s: %s", env.name, err)
+		}
+		*env.value = d
+	}
+
+	return nil
+}
+
+// Validate checks the configuration values.
+func (c Config) Validate() error {
+	switch c.Driver {
+	case "rethink":
+		if strings.Count(c.URL, "/") != 1 || strings.HasSuffix(c.URL, "/") {
+			return fmt.Errorf("config: rethink url %q is not host:port/database", c.URL)
+		}
+	case "sqlite3":
+		if len(c.URL) == 0 {
+			return fmt.Errorf("config: url is empty")
+		}
+	default:
+		return fmt.Errorf("config: unknown driver %q", c.Driver)
+	}
+
+	var _, _, err = net.SplitHostPort(c.Addr)
+	if err != nil {
+		return fmt.Errorf("config: addr %q: %s", c.Addr, err)
+	}
+	if len(c.GRPCAddr) != 0 {
+		_, _, err = net.SplitHostPort(c.GRPCAddr)
+		if err != nil {
+			return fmt.Errorf("config: grpc addr %q: %s", c.GRPCAddr, err)
+		}
+	}
+
+	if c.Dev {
+		_, err = os.Stat(filepath.Join(c.StaticDir, "index.html"))
+		if err != nil {
+			return fmt.Errorf("config: static dir: %s", err)
+		}
+	}
+
+	switch c.LogFormat {
+	case LogCommon, LogCombined, LogJSON, LogLogfmt, LogNone:
+	default:
+		return fmt.Errorf("config: unknown log format %q", c.LogFormat)
+	}
+
+	_, err = NewLogger(ioutil.Discard, c.LogFormat, c.LogLevel)
+	if err != nil {
+		return fmt.Errorf("config: %s", err)
+	}
+
+	if c.ReadTimeout < 0 || c.WriteTimeout < 0 || c.IdleTimeout < 0 || c.ShutdownTimeout < 0 {
+		return fmt.Errorf("config: timeouts must not be negative")
+	}
+
+	_, err = ParseUsers(c.Tokens)
+	if err != nil {
+		return fmt.Errorf("config: %s", err)
+	}
+
+	if c.CacheSize < 0 || c.CacheTTL < 0 {
+		return fmt.Errorf("config: cache size and ttl must not be negative")
+	}
+
+	_, err = ParseNetworks(c.WebhookAllow)
+	if err != nil {
+		return fmt.Errorf("config: %s", err)
+	}
+
+	if (len(c.TLSCert) == 0) != (len(c.TLSKey) == 0) {
+		return fmt.Errorf("config: tls cert and key must be set together")
+	}
+
+	if len(c.TLSCert) != 0 {
+		var _, err = tls.LoadX509KeyPair(c.TLSCert, c.TLSKey)
+		if err != nil {
+			return fmt.Errorf("config: tls: %s", err)
+		}
+	}
+
+	if len(c.Trace) != 0 && !validTraceTarget(c.Trace) {
+		return fmt.Errorf("config: trace %q is not a collector url or file:path", c.Trace)
+	}
+
+	for _, rate := range []string{c.RateRead, c.RateWrite} {
+		var _, err = ParseRateLimit(rate)
+		if err != nil {
+			return fmt.Errorf("config: %s", err)
+		}
+	}
+
+	if cors := NewCORS(c); cors != nil {
+		var err = cors.Validate()
+		if err != nil {
+			return fmt.Errorf("config: %s", err)
+		}
+	}
+
+	_, err = ParseCSRFKey(c.CSRFKey)
+	if err != nil {
+		return fmt.Errorf("config: %s", err)
+	}
+
+	return nil
+}
+
+// redacted replaces the secret values in a printed configuration.
+const redacted = "redacted"
+
+// WriteYAML writes the configuration in the configuration file format,
+// the values of the fields tagged secret redacted.
+func (c Config) WriteYAML(w io.Writer) error {
+	var v = reflect.ValueOf(&c).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		var field = v.Field(i)
+		if v.Type().Field(i).Tag.Get("secret") == "true" && field.Len() != 0 {
+			field.SetString(redacted)
+		}
+	}
+
+	var data, err = yaml.Marshal(c)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+	return err
+}
+
+func getenv(name string) string {
+	return strings.TrimSpace(os.Getenv(name))
+}
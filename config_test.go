@@ -0,0 +1,191 @@
+package todo
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfigPrecedence(t *testing.T) {
+	var dir, err = ioutil.TempDir("", "todo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var file = filepath.Join(dir, "todo.yaml")
+	err = ioutil.WriteFile(file, []byte(`
+driver: sqlite3
+url: /tmp/todo.sqlite
+addr: ":9000"
+log_format: combined
+read_timeout: 3s
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	os.Setenv("TODO_ADDR", ":9001")
+	os.Setenv("TODO_READ_TIMEOUT", "4s")
+	defer os.Unsetenv("TODO_ADDR")
+	defer os.Unsetenv("TODO_READ_TIMEOUT")
+
+	var flags = flag.NewFlagSet("test", flag.ContinueOnError)
+	config, err := ParseConfig(flags, []string{"-config", file, "-read-timeout", "5s"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// file
+	if config.Driver != "sqlite3" || config.LogFormat != LogCombined {
+		t.Fatal("config file error", config)
+	}
+
+	// environment
+	if config.Addr != ":9001" {
+		t.Fatal("config env error", config)
+	}
+
+	// flags
+	if config.ReadTimeout != 5*time.Second {
+		t.Fatal("config flags error", config)
+	}
+
+	// defaults
+	if config.StaticDir != "polymer" || config.IdleTimeout != DefaultConfig().IdleTimeout {
+		t.Fatal("config defaults error", config)
+	}
+}
+
+func TestConfigWriteYAML(t *testing.T) {
+	var config = DefaultConfig()
+	config.CSRFKey = strings.Repeat("ab", 32)
+	config.Tokens = "alice:secret"
+
+	var b bytes.Buffer
+	var err = config.WriteYAML(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out = b.String()
+	if strings.Contains(out, config.CSRFKey) || strings.Contains(out, "secret") ||
+		!strings.Contains(out, "csrf_key: redacted") || !strings.Contains(out, "driver: rethink") {
+		t.Fatal("config yaml error", out)
+	}
+	if len(config.CSRFKey) != 64 {
+		t.Fatal("config yaml modified error", config.CSRFKey)
+	}
+}
+
+func TestConfigDefaultURL(t *testing.T) {
+	t.Setenv("TODO_DRIVER", "")
+	t.Setenv("DATABASE_URL", "")
+
+	var flags = flag.NewFlagSet("test", flag.ContinueOnError)
+	var config, err = ParseConfig(flags, []string{"-driver", "sqlite3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.URL != "todo.sqlite" {
+		t.Fatal("config sqlite3 url error", config.URL)
+	}
+
+	flags = flag.NewFlagSet("test", flag.ContinueOnError)
+	config, err = ParseConfig(flags, []string{"-driver", "rethink"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.URL != "localhost:28015/test" {
+		t.Fatal("config rethink url error", config.URL)
+	}
+}
+
+func TestDefaultStore(t *testing.T) {
+	t.Setenv("TODO_DRIVER", "sqlite3")
+	t.Setenv("DATABASE_URL", "")
+
+	var driver, url, err = DefaultStore()
+	if err != nil || driver != "sqlite3" || url != "todo.sqlite" {
+		t.Fatal("default store error", driver, url, err)
+	}
+
+	t.Setenv("TODO_READ_TIMEOUT", "soon")
+	_, _, err = DefaultStore()
+	if err == nil {
+		t.Fatal("default store env error")
+	}
+}
+
+func TestOpenStoreDriver(t *testing.T) {
+	defer func() {
+		if err := recover(); err == nil {
+			t.Fatal("open store driver error")
+		}
+	}()
+	OpenStore("postgres", "postgres://localhost/todo")
+}
+
+func TestConfigValidate(t *testing.T) {
+	var config = DefaultConfig()
+	config.Driver = "postgres"
+	if config.Validate() == nil {
+		t.Fatal("config driver error")
+	}
+
+	config = DefaultConfig()
+	config.URL = "localhost:28015"
+	if config.Validate() == nil {
+		t.Fatal("config url error")
+	}
+
+	config = DefaultConfig()
+	config.StaticDir = "missing"
+	if config.Validate() != nil {
+		t.Fatal("config embedded static dir error")
+	}
+	config.Dev = true
+	if config.Validate() == nil {
+		t.Fatal("config static dir error")
+	}
+
+	config = DefaultConfig()
+	config.RateWrite = "10/0s"
+	if config.Validate() == nil {
+		t.Fatal("config rate error")
+	}
+
+	config = DefaultConfig()
+	config.CSRFKey = "0123"
+	if config.Validate() == nil {
+		t.Fatal("config csrf key error")
+	}
+
+	config = DefaultConfig()
+	config.GRPCAddr = "9090"
+	if config.Validate() == nil {
+		t.Fatal("config grpc addr error")
+	}
+
+	config = DefaultConfig()
+	config.CacheSize = -1
+	if config.Validate() == nil {
+		t.Fatal("config cache size error")
+	}
+
+	config = DefaultConfig()
+	config.WebhookAllow = "10.0.0.0/33"
+	if config.Validate() == nil {
+		t.Fatal("config webhook allow error")
+	}
+
+	config = DefaultConfig()
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,202 @@
+package todo
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// corsMethods are the methods of the api routes.
+var corsMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE"}
+
+// CORS defines the cross-origin requests allowed to the api.
+type CORS struct {
+	Origins     []string
+	Methods     []string
+	Headers     []string
+	Credentials bool
+	MaxAge      time.Duration
+}
+
+// NewCORS returns the CORS policy of the given configuration,
+// or nil when no origin is allowed.
+func NewCORS(config Config) *CORS {
+	var origins = splitList(config.CORSOrigins)
+	if len(origins) == 0 {
+		return nil
+	}
+
+	var c = &CORS{
+		Origins:     origins,
+		Methods:     splitList(config.CORSMethods),
+		Headers:     splitList(config.CORSHeaders),
+		Credentials: config.CORSCredentials,
+		MaxAge:      10 * time.Minute,
+	}
+
+	if len(c.Methods) == 0 {
+		c.Methods = corsMethods
+	}
+	if len(c.Headers) == 0 {
+		c.Headers = []string{"Authorization", "Content-Type", IdempotencyHeader, RequestIDHeader, TraceparentHeader}
+	}
+
+	return c
+}
+
+// Validate checks the CORS policy.
+func (c *CORS) Validate() error {
+	for _, origin := range c.Origins {
+		if origin == "*" {
+			if c.Credentials {
+				return fmt.Errorf("cors: credentials are not allowed with any origin")
+			}
+			continue
+		}
+
+		var u, err = url.Parse(origin)
+		if err != nil || len(u.Scheme) == 0 || len(u.Host) == 0 || len(u.Path) != 0 {
+			return fmt.Errorf("cors: origin %q is not scheme://host[:port]", origin)
+		}
+	}
+
+	for _, method := range c.Methods {
+		if !contains(corsMethods, method) {
+			return fmt.Errorf("cors: unknown method %q", method)
+		}
+	}
+
+	return nil
+}
+
+// Handler returns a handler adding the CORS headers to the responses
+// of next, and answering the preflight requests of the routes of router.
+// A preflight succeeds if the route allows the requested method.
+func (c *CORS) Handler(router *mux.Router) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		var fn = func(w http.ResponseWriter, r *http.Request) {
+			var origin = r.Header.Get("Origin")
+			if len(origin) == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			var header = w.Header()
+			header.Add("Vary", "Origin")
+
+			var preflight = r.Method == "OPTIONS" &&
+				len(r.Header.Get("Access-Control-Request-Method")) != 0
+
+			if !c.allowOrigin(origin) {
+				if preflight {
+					http.Error(w, "cors: origin not allowed", http.StatusForbidden)
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if contains(c.Origins, "*") {
+				header.Set("Access-Control-Allow-Origin", "*")
+			} else {
+				header.Set("Access-Control-Allow-Origin", origin)
+			}
+			if c.Credentials {
+				header.Set("Access-Control-Allow-Credentials", "true")
+			}
+
+			if !preflight {
+				header.Set("Access-Control-Expose-Headers", strings.Join([]string{
+					RequestIDHeader, "Retry-After", "RateLimit-Limit",
+					"RateLimit-Remaining", "RateLimit-Reset", "RateLimit-Policy",
+				}, ", "))
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			header.Add("Vary", "Access-Control-Request-Method")
+			header.Add("Vary", "Access-Control-Request-Headers")
+
+			var methods = c.routeMethods(router, r)
+			if !contains(methods, r.Header.Get("Access-Control-Request-Method")) {
+				http.Error(w, "cors: method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+
+			for _, h := range strings.Split(r.Header.Get("Access-Control-Request-Headers"), ",") {
+				h = strings.TrimSpace(h)
+				if len(h) != 0 && !containsFold(c.Headers, h) {
+					http.Error(w, "cors: header not allowed", http.StatusForbidden)
+					return
+				}
+			}
+
+			header.Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+			header.Set("Access-Control-Allow-Headers", strings.Join(c.Headers, ", "))
+			header.Set("Access-Control-Max-Age", strconv.Itoa(int(c.MaxAge.Seconds())))
+			w.WriteHeader(http.StatusNoContent)
+		}
+
+		return http.HandlerFunc(fn)
+	}
+}
+
+func (c *CORS) allowOrigin(origin string) bool {
+	for _, allowed := range c.Origins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// routeMethods returns the allowed methods of the route of the request.
+func (c *CORS) routeMethods(router *mux.Router, r *http.Request) []string {
+	var methods []string
+
+	for _, method := range c.Methods {
+		var req = r.Clone(r.Context())
+		req.Method = method
+
+		var match mux.RouteMatch
+		if router.Match(req, &match) && match.MatchErr == nil {
+			methods = append(methods, method)
+		}
+	}
+	return methods
+}
+
+// splitList splits a comma separated list.
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if len(item) != 0 {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
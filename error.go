@@ -1,6 +1,5 @@
 package todo
 
-import "log"
 import "net/http"
 
 // BadRequest defines a client error
@@ -9,6 +8,12 @@ type BadRequest struct{ error }
 // NotFound defines a not found error
 type NotFound struct{ error }
 
+// Conflict defines a conflict with the current state, such as a lock
+type Conflict struct{ error }
+
+// TooManyRequests defines a rate limited request
+type TooManyRequests struct{ error }
+
 // ErrorFunc augments http.HandlerFunc with error return value
 type ErrorFunc func(http.ResponseWriter, *http.Request) error
 
@@ -19,17 +24,23 @@ func (f ErrorFunc) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var status int
+	http.Error(w, err.Error(), errorStatus(r, err))
+}
 
+// errorStatus returns the response status of the given error.
+// Server errors are logged.
+func errorStatus(r *http.Request, err error) int {
 	switch err.(type) {
 	case BadRequest:
-		status = http.StatusBadRequest
+		return http.StatusBadRequest
 	case NotFound:
-		status = http.StatusNotFound
-	default:
-		status = http.StatusInternalServerError
-		log.Printf("error: %s", err)
+		return http.StatusNotFound
+	case Conflict:
+		return http.StatusConflict
+	case TooManyRequests:
+		return http.StatusTooManyRequests
 	}
 
-	http.Error(w, err.Error(), status)
+	Logger(r).Error("error", "err", err)
+	return http.StatusInternalServerError
 }
@@ -0,0 +1,459 @@
+package todo
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Export and import formats.
+const (
+	FormatJSON     = "json"
+	FormatCSV      = "csv"
+	FormatMarkdown = "markdown"
+	FormatTodoTxt  = "todotxt"
+)
+
+var formatTypes = map[string]string{
+	FormatJSON:     "application/json",
+	FormatCSV:      "text/csv",
+	FormatMarkdown: "text/markdown",
+	FormatTodoTxt:  "text/plain",
+}
+
+var formatExts = map[string]string{
+	".json":     FormatJSON,
+	".csv":      FormatCSV,
+	".md":       FormatMarkdown,
+	".markdown": FormatMarkdown,
+	".txt":      FormatTodoTxt,
+}
+
+var csvHeader = []string{"id", "title", "status", "created"}
+
+// ParseError defines an error found at a given line of an import.
+type ParseError struct {
+	Line    int    `json:"line"`
+	Message string `json:"error"`
+}
+
+func (e ParseError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Message)
+}
+
+// Imported defines the outcome of an import.
+type Imported struct {
+	Count  int64        `json:"count"`
+	Errors []ParseError `json:"errors,omitempty"`
+}
+
+// FormatType returns the content type of the given format.
+func FormatType(format string) string {
+	var ctype, ok = formatTypes[format]
+	if !ok {
+		return ""
+	}
+	return ctype + "; charset=utf-8"
+}
+
+// FormatByType returns the format of the given content type.
+func FormatByType(ctype string) (string, bool) {
+	var mtype, _, err = mime.ParseMediaType(ctype)
+	if err != nil {
+		return "", false
+	}
+
+	for format, t := range formatTypes {
+		if t == mtype {
+			return format, true
+		}
+	}
+	return "", false
+}
+
+// FormatByExt returns the format of the given file name.
+func FormatByExt(name string) (string, bool) {
+	var format, ok = formatExts[strings.ToLower(filepath.Ext(name))]
+	return format, ok
+}
+
+// negotiateFormat returns the format requested with the "format" query
+// parameter or else the Accept header. It defaults to JSON.
+func negotiateFormat(r *http.Request) (string, error) {
+	var format = r.URL.Query().Get("format")
+	if len(format) != 0 {
+		if _, ok := formatTypes[format]; !ok {
+			return "", BadRequest{fmt.Errorf("format: unknown format %q", format)}
+		}
+		return format, nil
+	}
+
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		if format, ok := FormatByType(strings.TrimSpace(accept)); ok {
+			return format, nil
+		}
+	}
+
+	return FormatJSON, nil
+}
+
+// WriteTodos encodes the given todos to w in the given format.
+func WriteTodos(w io.Writer, format string, todos Todos) error {
+	switch format {
+	case FormatJSON:
+		var encoder = json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(todos)
+
+	case FormatCSV:
+		var writer = csv.NewWriter(w)
+		writer.Write(csvHeader)
+		for _, t := range todos {
+			writer.Write([]string{t.ID, t.Title, t.Status, formatTime(t.Created)})
+		}
+		writer.Flush()
+		return writer.Error()
+
+	case FormatMarkdown:
+		for _, t := range todos {
+			var check = " "
+			if t.Completed() {
+				check = "x"
+			}
+			var _, err = fmt.Fprintf(w, "- [%s] %s <!-- id:%s created:%s -->\n",
+				check, oneLine(t.Title), t.ID, formatTime(t.Created))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case FormatTodoTxt:
+		for _, t := range todos {
+			// no completion date is kept, so the creation date fills
+			// both positions of a completed todo
+			var prefix = t.Created.Format("2006-01-02") + " "
+			if t.Completed() {
+				prefix = "x " + prefix + prefix
+			}
+			var _, err = fmt.Fprintf(w, "%s%s id:%s created:%s\n",
+				prefix, oneLine(t.Title), t.ID, formatTime(t.Created))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return fmt.Errorf("format: unknown format %q", format)
+}
+
+// ReadTodos decodes todos from r in the given format.
+// Invalid lines are skipped and reported as parse errors.
+func ReadTodos(r io.Reader, format string) (Todos, []ParseError, error) {
+	switch format {
+	case FormatJSON:
+		var data, err = ioutil.ReadAll(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		todos, errs := readJSONTodos(data)
+		return todos, errs, nil
+
+	case FormatCSV:
+		todos, errs := readCSVTodos(r)
+		return todos, errs, nil
+
+	case FormatMarkdown:
+		return readLines(r, parseMarkdown)
+
+	case FormatTodoTxt:
+		return readLines(r, parseTodoTxt)
+	}
+
+	return nil, nil, fmt.Errorf("format: unknown format %q", format)
+}
+
+func readJSONTodos(data []byte) (Todos, []ParseError) {
+	var todos = make(Todos, 0)
+	var errs []ParseError
+
+	var lineAt = func(offset int64) int {
+		for offset < int64(len(data)) && bytes.IndexByte([]byte(" \t\r\n,"), data[offset]) >= 0 {
+			offset++
+		}
+		return 1 + bytes.Count(data[:offset], []byte("\n"))
+	}
+
+	var decoder = json.NewDecoder(bytes.NewReader(data))
+	var token, err = decoder.Token()
+	if err != nil || token != json.Delim('[') {
+		return todos, []ParseError{{Line: 1, Message: "expected a JSON array"}}
+	}
+
+	for decoder.More() {
+		var line = lineAt(decoder.InputOffset())
+
+		var raw json.RawMessage
+		err = decoder.Decode(&raw)
+		if err != nil {
+			return todos, append(errs, ParseError{lineAt(decoder.InputOffset()), err.Error()})
+		}
+
+		var t Todo
+		err = json.Unmarshal(raw, &t)
+		if err == nil {
+			err = validateImport(&t)
+		}
+		if err != nil {
+			errs = append(errs, ParseError{line, err.Error()})
+			continue
+		}
+		todos = append(todos, t)
+	}
+
+	return todos, errs
+}
+
+func readCSVTodos(r io.Reader) (Todos, []ParseError) {
+	var todos = make(Todos, 0)
+	var errs []ParseError
+
+	var reader = csv.NewReader(r)
+	reader.FieldsPerRecord = len(csvHeader)
+
+	for first := true; ; first = false {
+		var record, err = reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if perr, ok := err.(*csv.ParseError); ok {
+			errs = append(errs, ParseError{perr.StartLine, perr.Err.Error()})
+			continue
+		}
+		if err != nil {
+			return todos, append(errs, ParseError{0, err.Error()})
+		}
+
+		if first && record[0] == csvHeader[0] {
+			continue
+		}
+
+		var line, _ = reader.FieldPos(0)
+
+		var t = Todo{ID: record[0], Title: record[1], Status: record[2]}
+		t.Created, err = parseTime(record[3])
+		if err == nil {
+			err = validateImport(&t)
+		}
+		if err != nil {
+			errs = append(errs, ParseError{line, err.Error()})
+			continue
+		}
+		todos = append(todos, t)
+	}
+
+	return todos, errs
+}
+
+func readLines(r io.Reader, parse func(string) (Todo, bool, error)) (Todos, []ParseError, error) {
+	var todos = make(Todos, 0)
+	var errs []ParseError
+
+	var scanner = bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		var text = strings.TrimSpace(scanner.Text())
+		if len(text) == 0 {
+			continue
+		}
+
+		var t, ok, err = parse(text)
+		if err == nil && ok {
+			err = validateImport(&t)
+		}
+		if err != nil {
+			errs = append(errs, ParseError{line, err.Error()})
+			continue
+		}
+		if ok {
+			todos = append(todos, t)
+		}
+	}
+
+	return todos, errs, scanner.Err()
+}
+
+// parseMarkdown parses a "- [x] title <!-- id:1 created:... -->" line.
+// Lines which are not checklist items are ignored.
+func parseMarkdown(text string) (Todo, bool, error) {
+	var t Todo
+
+	if !strings.HasPrefix(text, "- [") && !strings.HasPrefix(text, "* [") {
+		return t, false, nil
+	}
+	if len(text) < 6 || text[4] != ']' {
+		return t, false, fmt.Errorf("expected a checklist item")
+	}
+
+	switch text[3] {
+	case ' ':
+		t.Status = "active"
+	case 'x', 'X':
+		t.Status = "completed"
+	default:
+		return t, false, fmt.Errorf("unknown check mark %q", text[3])
+	}
+
+	var title = strings.TrimSpace(text[5:])
+
+	if strings.HasSuffix(title, "-->") {
+		var i = strings.LastIndex(title, "<!--")
+		if i < 0 {
+			return t, false, fmt.Errorf("unterminated comment")
+		}
+
+		var err = parseTags(&t, strings.Fields(title[i+4:len(title)-3]))
+		if err != nil {
+			return t, false, err
+		}
+		title = strings.TrimSpace(title[:i])
+	}
+
+	t.Title = title
+	return t, true, nil
+}
+
+// parseTodoTxt parses a todo.txt line, reading the id and created tags.
+// Dates are only read in the completion and creation date positions,
+// and the spacing of the title is kept.
+func parseTodoTxt(text string) (Todo, bool, error) {
+	var t = Todo{Status: "active"}
+
+	var rest = strings.TrimSpace(text)
+	if field, after := cutField(rest); field == "x" {
+		t.Status = "completed"
+		rest = after
+
+		// completion date, followed by an optional creation date
+		if _, after, ok := cutDate(rest); ok {
+			rest = after
+			if date, after, ok := cutDate(rest); ok {
+				t.Created, rest = date, after
+			}
+		}
+	} else if date, after, ok := cutDate(rest); ok {
+		// creation date
+		t.Created, rest = date, after
+	}
+
+	// trailing tags
+	var tags []string
+	for {
+		var i = strings.LastIndexAny(rest, " \t")
+		if len(rest) == 0 || !isTag(rest[i+1:]) {
+			break
+		}
+		tags = append([]string{rest[i+1:]}, tags...)
+		rest = strings.TrimRight(rest[:i+1], " \t")
+	}
+
+	var err = parseTags(&t, tags)
+	if err != nil {
+		return t, false, err
+	}
+
+	t.Title = rest
+	return t, true, nil
+}
+
+// cutField cuts the first space separated field of s.
+func cutField(s string) (string, string) {
+	var i = strings.IndexAny(s, " \t")
+	if i < 0 {
+		return s, ""
+	}
+	return s[:i], strings.TrimLeft(s[i:], " \t")
+}
+
+// cutDate cuts the leading todo.txt date of s.
+func cutDate(s string) (time.Time, string, bool) {
+	var field, rest = cutField(s)
+	var date, err = time.Parse("2006-01-02", field)
+	if err != nil {
+		return date, s, false
+	}
+	return date, rest, true
+}
+
+func isTag(field string) bool {
+	return strings.HasPrefix(field, "id:") || strings.HasPrefix(field, "created:")
+}
+
+func parseTags(t *Todo, fields []string) error {
+	for _, field := range fields {
+		var err error
+
+		switch {
+		case strings.HasPrefix(field, "id:"):
+			t.ID = field[len("id:"):]
+		case strings.HasPrefix(field, "created:"):
+			t.Created, err = parseTime(field[len("created:"):])
+		default:
+			err = fmt.Errorf("unknown tag %q", field)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateImport checks the given imported todo.
+func validateImport(t *Todo) error {
+	t.Title = strings.TrimSpace(t.Title)
+	if len(t.Title) == 0 {
+		return fmt.Errorf("empty title")
+	}
+
+	switch t.Status {
+	case "":
+		t.Status = "active"
+	case "active", "completed":
+	default:
+		return fmt.Errorf("unknown status %q", t.Status)
+	}
+
+	return nil
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+func parseTime(s string) (time.Time, error) {
+	if len(s) == 0 {
+		return time.Time{}, nil
+	}
+	var t, err = time.Parse(time.RFC3339Nano, s)
+	return t.UTC(), err
+}
+
+var lineBreaks = strings.NewReplacer("\r\n", " ", "\r", " ", "\n", " ")
+
+// oneLine replaces line breaks of the given title with spaces.
+func oneLine(title string) string {
+	return lineBreaks.Replace(title)
+}
@@ -0,0 +1,89 @@
+package todo
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormatRoundTrip(t *testing.T) {
+	var created = time.Date(2016, 1, 2, 3, 4, 5, 6000, time.UTC)
+
+	var todos = Todos{
+		{ID: "1", Title: "todo 1", Status: "active", Created: created},
+		{ID: "2", Title: "todo, \"2\"", Status: "completed", Created: created.Add(time.Hour)},
+		{ID: "3", Title: "2016-01-02 starts with a date", Status: "active", Created: created},
+		{ID: "4", Title: "2016-01-02 2016-01-03 done", Status: "completed", Created: created},
+		{ID: "5", Title: "todo  with   spaces", Status: "active", Created: created},
+	}
+
+	for _, format := range []string{FormatJSON, FormatCSV, FormatMarkdown, FormatTodoTxt} {
+		var buf bytes.Buffer
+		var err = WriteTodos(&buf, format, todos)
+		if err != nil {
+			t.Fatal(format, err)
+		}
+
+		read, errs, err := ReadTodos(&buf, format)
+		if err != nil || len(errs) != 0 {
+			t.Fatal(format, err, errs)
+		}
+
+		if len(read) != len(todos) {
+			t.Fatal(format, "todos count error", read)
+		}
+
+		for i := range todos {
+			if !read[i].Equal(todos[i]) || !read[i].Created.Equal(todos[i].Created) {
+				t.Fatalf("%s equals error:\n%s\n%s\n", format, todos[i], read[i])
+			}
+		}
+	}
+}
+
+func TestFormatParseErrors(t *testing.T) {
+	var input = map[string]string{
+		FormatJSON:     "[\n{\"title\": \"todo 1\"},\n{\"title\": \"\"}\n]",
+		FormatCSV:      "id,title,status,created\n,todo 1,active,\n,todo 2,unknown,\n",
+		FormatMarkdown: "# todos\n- [ ] todo 1\n- [?] todo 2\n",
+		FormatTodoTxt:  "todo 1\ntodo 2 created:yesterday\n",
+	}
+
+	for format, text := range input {
+		var todos, errs, err = ReadTodos(strings.NewReader(text), format)
+		if err != nil {
+			t.Fatal(format, err)
+		}
+
+		if len(todos) != 1 || todos[0].Title != "todo 1" || todos[0].Status != "active" {
+			t.Fatal(format, "todos error", todos)
+		}
+
+		if len(errs) != 1 || errs[0].Line != 3 && format != FormatTodoTxt {
+			t.Fatal(format, "parse errors error", errs)
+		}
+	}
+}
+
+func TestParseTodoTxt(t *testing.T) {
+	var date = time.Date(2016, 1, 2, 0, 0, 0, 0, time.UTC)
+
+	var lines = map[string]Todo{
+		"2016-01-02 todo 1":                 {Title: "todo 1", Status: "active", Created: date},
+		"x 2016-01-03 todo 2":               {Title: "todo 2", Status: "completed"},
+		"x 2016-01-03 2016-01-02 todo 3":    {Title: "todo 3", Status: "completed", Created: date},
+		"2016-01-02 2016-01-03 todo 4 id:4": {ID: "4", Title: "2016-01-03 todo 4", Status: "active", Created: date},
+		"id:5":                              {ID: "5", Status: "active"},
+	}
+
+	for line, todo := range lines {
+		var parsed, ok, err = parseTodoTxt(line)
+		if err != nil || !ok {
+			t.Fatal(line, err)
+		}
+		if !parsed.Equal(todo) || !parsed.Created.Equal(todo.Created) {
+			t.Fatalf("%s parse error:\n%s\n%s\n", line, todo, parsed)
+		}
+	}
+}
@@ -0,0 +1,662 @@
+package todo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	graphql "github.com/graph-gophers/graphql-go"
+)
+
+// GraphQLPath is the path of the GraphQL endpoint.
+const GraphQLPath = "/api/graphql"
+
+// GraphQLProtocol is the WebSocket subprotocol of the subscriptions.
+const GraphQLProtocol = "graphql-transport-ws"
+
+// GraphQL route names.
+const (
+	RouteGraphQL       = "GraphQL"
+	RouteGraphQLSocket = "GraphQL.Socket"
+)
+
+// GraphQL WebSocket message types, as of the graphql-transport-ws protocol.
+const (
+	GraphQLConnectionInit = "connection_init"
+	GraphQLConnectionAck  = "connection_ack"
+	GraphQLPing           = "ping"
+	GraphQLPong           = "pong"
+	GraphQLSubscribe      = "subscribe"
+	GraphQLNext           = "next"
+	GraphQLError          = "error"
+	GraphQLComplete       = "complete"
+)
+
+const (
+	maxGraphQLSize  = 1 << 20
+	maxGraphQLDepth = 10
+	graphqlInitWait = 10 * time.Second
+)
+
+// graphqlSchema is the GraphQL schema of the todos.
+var graphqlSchema = `
+schema {
+	query: Query
+	mutation: Mutation
+	subscription: Subscription
+}
+
+scalar Time
+
+enum Status {
+	active
+	completed
+}
+
+type Todo {
+	id: ID!
+	title: String!
+	status: Status!
+	completed: Boolean!
+	created: Time!
+}
+
+type TodoPage {
+	items: [Todo!]!
+	total: Int!
+	offset: Int!
+	limit: Int!
+	hasNext: Boolean!
+}
+
+type Stats {
+	total: Int!
+	active: Int!
+	completed: Int!
+}
+
+enum ChangeType {
+	saved
+	deleted
+	reset
+}
+
+# A change of the todos. A reset lists all the todos after a bulk change.
+type TodoChange {
+	type: ChangeType!
+	id: ID
+	todo: Todo
+	todos: [Todo!]
+}
+
+input CreateTodoInput {
+	title: String!
+	status: Status = active
+}
+
+input UpdateTodoInput {
+	title: String
+	status: Status
+}
+
+type Query {
+	todo(id: ID!): Todo
+	todos(status: Status, offset: Int = 0, limit: Int = 50): TodoPage!
+	stats: Stats!
+}
+
+type Mutation {
+	createTodo(input: CreateTodoInput!): Todo!
+	updateTodo(id: ID!, input: UpdateTodoInput!): Todo!
+	deleteTodo(id: ID!): ID!
+	clearTodos(status: Status!): Int!
+	toggleTodos(status: Status!): Int!
+}
+
+type Subscription {
+	todoChanged(id: ID): TodoChange!
+}
+`
+
+// GraphQL serves the todos over GraphQL: queries and mutations over
+// HTTP POST, and subscriptions over WebSocket with the
+// graphql-transport-ws protocol, fed by the changes of the Hub.
+//
+// The connections are closed when the http.Server serving them shuts down.
+type GraphQL struct {
+	// Limiter, if any, limits the operations over WebSocket as writes,
+	// as the ones over HTTP POST.
+	Limiter *RateLimiter
+
+	schema   *graphql.Schema
+	upgrader websocket.Upgrader
+
+	mu      sync.Mutex
+	closed  bool
+	peers   map[*livePeer]bool
+	servers map[*http.Server]bool
+}
+
+// NewGraphQL returns a new GraphQL of the given store,
+// subscribed to the changes of the given hub.
+func NewGraphQL(store Store, hub *Hub) *GraphQL {
+	var resolver = &graphqlResolver{store, hub}
+	return &GraphQL{
+		schema:   graphql.MustParseSchema(graphqlSchema, resolver, graphql.MaxDepth(maxGraphQLDepth)),
+		upgrader: websocket.Upgrader{Subprotocols: []string{GraphQLProtocol}},
+		peers:    make(map[*livePeer]bool),
+		servers:  make(map[*http.Server]bool),
+	}
+}
+
+// NewGraphQLRouter creates a new mux.Router and defines HTTP methods
+// of the GraphQL endpoint.
+func NewGraphQLRouter() *mux.Router {
+	var router = mux.NewRouter()
+
+	router.Methods("POST").Path(GraphQLPath).Name(RouteGraphQL)
+	router.Methods("GET").Path(GraphQLPath).Name(RouteGraphQLSocket)
+
+	return router
+}
+
+// Register sets the handlers to the routes.
+func (g *GraphQL) Register(router *mux.Router) {
+	router.Get(RouteGraphQL).Handler(ErrorFunc(g.Exec))
+	router.Get(RouteGraphQLSocket).HandlerFunc(g.Subscribe)
+}
+
+// graphqlRequest defines a GraphQL request.
+type graphqlRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// Exec handles a GraphQL query or mutation. The errors of the
+// operation are in the response, with a 200 status.
+func (g *GraphQL) Exec(w http.ResponseWriter, r *http.Request) error {
+	var req graphqlRequest
+	r.Body = http.MaxBytesReader(w, r.Body, maxGraphQLSize)
+	var err = readJSON(r, &req)
+	if err != nil {
+		return BadRequest{err} // 400
+	}
+	if len(req.Query) == 0 {
+		return BadRequest{fmt.Errorf("graphql: empty query")} // 400
+	}
+
+	var res = g.schema.Exec(r.Context(), req.Query, req.OperationName, req.Variables)
+	return writeJSON(w, res, http.StatusOK) // 200
+}
+
+// graphqlMessage defines the messages of the graphql-transport-ws protocol.
+type graphqlMessage struct {
+	ID      string          `json:"id,omitempty"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// graphqlSub is a running operation of a connection.
+type graphqlSub struct {
+	cancel context.CancelFunc
+}
+
+// Subscribe upgrades the request to a WebSocket connection, and runs
+// its operations until closed.
+func (g *GraphQL) Subscribe(w http.ResponseWriter, r *http.Request) {
+	g.registerShutdown(r)
+
+	var conn, err = g.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return // 400, 403
+	}
+
+	var peer = &livePeer{
+		key:  RateKey(r),
+		conn: conn,
+		send: make(chan []byte, liveSendQueue),
+		done: make(chan struct{}),
+	}
+
+	g.mu.Lock()
+	if g.closed {
+		g.mu.Unlock()
+		peer.close(websocket.CloseGoingAway, "server shutting down")
+		return
+	}
+	g.peers[peer] = true
+	g.mu.Unlock()
+
+	go peer.writeLoop()
+
+	// hijacked connections outlive the request
+	var ctx, cancel = context.WithCancel(context.WithoutCancel(r.Context()))
+
+	var mu sync.Mutex
+	var subs = make(map[string]*graphqlSub)
+
+	defer func() {
+		cancel()
+		g.mu.Lock()
+		delete(g.peers, peer)
+		g.mu.Unlock()
+		peer.close(websocket.CloseNormalClosure, "")
+	}()
+
+	var send = func(msg graphqlMessage) {
+		var data, _ = json.Marshal(msg)
+		peer.queue(data)
+	}
+
+	var acked = false
+	conn.SetReadLimit(maxGraphQLSize)
+	conn.SetReadDeadline(time.Now().Add(graphqlInitWait))
+	conn.SetPongHandler(func(string) error {
+		if acked {
+			return conn.SetReadDeadline(time.Now().Add(livePongWait))
+		}
+		return nil
+	})
+
+	for {
+		var _, data, err = conn.ReadMessage()
+		if err != nil {
+			if ne, ok := err.(interface{ Timeout() bool }); ok && ne.Timeout() && !acked {
+				peer.close(4408, "Connection initialisation timeout")
+			}
+			return
+		}
+
+		var msg graphqlMessage
+		err = json.Unmarshal(data, &msg)
+		if err != nil {
+			peer.close(4400, "Invalid message")
+			return
+		}
+
+		switch msg.Type {
+		case GraphQLConnectionInit:
+			if acked {
+				peer.close(4429, "Too many initialisation requests")
+				return
+			}
+			acked = true
+			conn.SetReadDeadline(time.Now().Add(livePongWait))
+			send(graphqlMessage{Type: GraphQLConnectionAck})
+
+		case GraphQLPing:
+			send(graphqlMessage{Type: GraphQLPong, Payload: msg.Payload})
+
+		case GraphQLPong:
+
+		case GraphQLSubscribe:
+			if !acked {
+				peer.close(4401, "Unauthorized")
+				return
+			}
+
+			var req graphqlRequest
+			err = json.Unmarshal(msg.Payload, &req)
+			if err != nil || len(msg.ID) == 0 {
+				peer.close(4400, "Invalid message")
+				return
+			}
+
+			mu.Lock()
+			if subs[msg.ID] != nil {
+				mu.Unlock()
+				peer.close(4409, "Subscriber for "+msg.ID+" already exists")
+				return
+			}
+			var sub = new(graphqlSub)
+			var subCtx context.Context
+			subCtx, sub.cancel = context.WithCancel(ctx)
+			subs[msg.ID] = sub
+			mu.Unlock()
+
+			// subscribed before the next messages,
+			// queries and mutations are executed in order
+			var responses <-chan interface{}
+			if g.Limiter != nil {
+				err = g.Limiter.Allow(peer.key, true)
+			}
+			if err == nil {
+				responses, err = g.schema.Subscribe(subCtx, req.Query, req.OperationName, req.Variables)
+			}
+
+			go func(id string) {
+				g.run(subCtx, id, responses, err, send)
+				sub.cancel()
+
+				mu.Lock()
+				if subs[id] == sub {
+					delete(subs, id)
+				}
+				mu.Unlock()
+			}(msg.ID)
+
+		case GraphQLComplete:
+			mu.Lock()
+			if sub := subs[msg.ID]; sub != nil {
+				sub.cancel()
+				delete(subs, msg.ID)
+			}
+			mu.Unlock()
+
+		default:
+			peer.close(4400, "Invalid message")
+			return
+		}
+	}
+}
+
+// run sends the responses of the given operation, until completed
+// by the server or cancelled by the client.
+func (g *GraphQL) run(ctx context.Context, id string, responses <-chan interface{}, err error, send func(graphqlMessage)) {
+	if err != nil {
+		var errs, _ = json.Marshal([]map[string]string{{"message": err.Error()}})
+		send(graphqlMessage{ID: id, Type: GraphQLError, Payload: errs})
+		return
+	}
+
+	for res := range responses {
+		var res = res.(*graphql.Response)
+
+		// validation errors end the operation
+		if res.Data == nil && len(res.Errors) != 0 {
+			var errs, _ = json.Marshal(res.Errors)
+			send(graphqlMessage{ID: id, Type: GraphQLError, Payload: errs})
+			for range responses {
+			}
+			return
+		}
+
+		var payload, _ = json.Marshal(res)
+		send(graphqlMessage{ID: id, Type: GraphQLNext, Payload: payload})
+	}
+
+	if ctx.Err() == nil {
+		send(graphqlMessage{ID: id, Type: GraphQLComplete})
+	}
+}
+
+// Close closes the WebSocket connections.
+func (g *GraphQL) Close() {
+	g.mu.Lock()
+	g.closed = true
+	var peers = make([]*livePeer, 0, len(g.peers))
+	for peer := range g.peers {
+		peers = append(peers, peer)
+	}
+	g.mu.Unlock()
+
+	for _, peer := range peers {
+		peer.close(websocket.CloseGoingAway, "server shutting down")
+	}
+}
+
+// registerShutdown closes the connections on shutdown
+// of the server of the given request.
+func (g *GraphQL) registerShutdown(r *http.Request) {
+	var server, ok = r.Context().Value(http.ServerContextKey).(*http.Server)
+	if !ok {
+		return
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if !g.servers[server] {
+		g.servers[server] = true
+		server.RegisterOnShutdown(g.Close)
+	}
+}
+
+// graphqlError is a resolver error, with the code
+// of its status in the extensions.
+type graphqlError struct {
+	error
+	code string
+}
+
+func (e graphqlError) Extensions() map[string]interface{} {
+	return map[string]interface{}{"code": e.code}
+}
+
+// resolverError returns the given error with its code.
+// Server errors are logged.
+func resolverError(ctx context.Context, err error) error {
+	switch err.(type) {
+	case nil:
+		return nil
+	case BadRequest:
+		return graphqlError{err, "BAD_REQUEST"}
+	case NotFound:
+		return graphqlError{err, "NOT_FOUND"}
+	case Conflict:
+		return graphqlError{err, "CONFLICT"}
+	}
+
+	contextLogger(ctx).Error("graphql: error", "err", err)
+	return graphqlError{err, "INTERNAL"}
+}
+
+// graphqlResolver is the root resolver of the schema.
+type graphqlResolver struct {
+	store Store
+	hub   *Hub
+}
+
+// storeOf returns the store logging and tracing within the given context.
+func (r *graphqlResolver) storeOf(ctx context.Context) Store {
+	return r.store.WithContext(ctx)
+}
+
+func (r *graphqlResolver) Todo(ctx context.Context, args struct{ ID graphql.ID }) (*todoResolver, error) {
+	var todo, err = r.storeOf(ctx).Find(string(args.ID))
+	if _, ok := err.(NotFound); ok {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, resolverError(ctx, err)
+	}
+	return &todoResolver{todo}, nil
+}
+
+func (r *graphqlResolver) Todos(ctx context.Context, args struct {
+	Status *string
+	Offset int32
+	Limit  int32
+}) (*pageResolver, error) {
+	if args.Offset < 0 {
+		return nil, resolverError(ctx, BadRequest{fmt.Errorf("graphql: offset must be a positive integer")})
+	}
+	if args.Limit < 1 || args.Limit > MaxPageLimit {
+		return nil, resolverError(ctx, BadRequest{fmt.Errorf("graphql: limit must be between 1 and %d", MaxPageLimit)})
+	}
+
+	var todos Todos
+	if args.Status != nil {
+		todos = r.storeOf(ctx).Filter(*args.Status)
+	} else {
+		todos = r.storeOf(ctx).List()
+	}
+
+	var page = &pageResolver{total: len(todos), offset: int(args.Offset), limit: int(args.Limit)}
+	if page.offset < len(todos) {
+		var end = page.offset + page.limit
+		if end > len(todos) {
+			end = len(todos)
+		}
+		page.items = todos[page.offset:end]
+	}
+	return page, nil
+}
+
+func (r *graphqlResolver) Stats(ctx context.Context) *statsResolver {
+	var stats = new(statsResolver)
+	for _, todo := range r.storeOf(ctx).List() {
+		stats.total++
+		if todo.Completed() {
+			stats.completed++
+		} else {
+			stats.active++
+		}
+	}
+	return stats
+}
+
+// CreateTodo validates the input as a patch of a new todo.
+func (r *graphqlResolver) CreateTodo(ctx context.Context, args struct {
+	Input struct {
+		Title  string
+		Status string
+	}
+}) (*todoResolver, error) {
+	var patch = TodoPatch{Title: &args.Input.Title, Status: &args.Input.Status}
+	var err = patch.Validate()
+	if err != nil {
+		return nil, resolverError(ctx, BadRequest{err})
+	}
+
+	var todo = NewTodo(args.Input.Title)
+	patch.Apply(todo)
+
+	err = r.storeOf(ctx).Save(todo)
+	if err != nil {
+		return nil, resolverError(ctx, err)
+	}
+	return &todoResolver{*todo}, nil
+}
+
+// UpdateTodo patches the given fields of the todo.
+func (r *graphqlResolver) UpdateTodo(ctx context.Context, args struct {
+	ID    graphql.ID
+	Input struct {
+		Title  *string
+		Status *string
+	}
+}) (*todoResolver, error) {
+	var patch = TodoPatch{Title: args.Input.Title, Status: args.Input.Status}
+	var err = patch.Validate()
+	if err != nil {
+		return nil, resolverError(ctx, BadRequest{err})
+	}
+
+	todo, err := r.storeOf(ctx).Patch(string(args.ID), patch)
+	if err != nil {
+		return nil, resolverError(ctx, err)
+	}
+	return &todoResolver{todo}, nil
+}
+
+func (r *graphqlResolver) DeleteTodo(ctx context.Context, args struct{ ID graphql.ID }) (graphql.ID, error) {
+	var err = r.storeOf(ctx).Delete(string(args.ID))
+	return args.ID, resolverError(ctx, err)
+}
+
+func (r *graphqlResolver) ClearTodos(ctx context.Context, args struct{ Status string }) (int32, error) {
+	var count, err = r.storeOf(ctx).Clear(args.Status)
+	return int32(count), resolverError(ctx, err)
+}
+
+func (r *graphqlResolver) ToggleTodos(ctx context.Context, args struct{ Status string }) (int32, error) {
+	var count, err = r.storeOf(ctx).Toggle(args.Status)
+	return int32(count), resolverError(ctx, err)
+}
+
+// TodoChanged sends the changes of the todos, or of the todo
+// with the given id and the resets.
+func (r *graphqlResolver) TodoChanged(ctx context.Context, args struct{ ID *graphql.ID }) <-chan *changeResolver {
+	var changes = make(chan *changeResolver)
+	var events = r.hub.Subscribe(ctx)
+
+	go func() {
+		defer close(changes)
+		for msg := range events {
+			if args.ID != nil && msg.Type != LiveReset && msg.ID != string(*args.ID) {
+				continue
+			}
+
+			select {
+			case changes <- &changeResolver{msg}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return changes
+}
+
+type todoResolver struct {
+	todo Todo
+}
+
+func (r *todoResolver) ID() graphql.ID        { return graphql.ID(r.todo.ID) }
+func (r *todoResolver) Title() string         { return r.todo.Title }
+func (r *todoResolver) Status() string        { return r.todo.Status }
+func (r *todoResolver) Completed() bool       { return r.todo.Completed() }
+func (r *todoResolver) Created() graphql.Time { return graphql.Time{Time: r.todo.Created} }
+
+func todoResolvers(todos Todos) []*todoResolver {
+	var resolvers = make([]*todoResolver, len(todos))
+	for i, todo := range todos {
+		resolvers[i] = &todoResolver{todo}
+	}
+	return resolvers
+}
+
+type pageResolver struct {
+	items                Todos
+	total, offset, limit int
+}
+
+func (r *pageResolver) Items() []*todoResolver { return todoResolvers(r.items) }
+func (r *pageResolver) Total() int32           { return int32(r.total) }
+func (r *pageResolver) Offset() int32          { return int32(r.offset) }
+func (r *pageResolver) Limit() int32           { return int32(r.limit) }
+func (r *pageResolver) HasNext() bool          { return r.offset+len(r.items) < r.total }
+
+type statsResolver struct {
+	total, active, completed int32
+}
+
+func (r *statsResolver) Total() int32     { return r.total }
+func (r *statsResolver) Active() int32    { return r.active }
+func (r *statsResolver) Completed() int32 { return r.completed }
+
+type changeResolver struct {
+	msg LiveMessage
+}
+
+func (r *changeResolver) Type() string { return r.msg.Type }
+
+func (r *changeResolver) ID() *graphql.ID {
+	if len(r.msg.ID) == 0 {
+		return nil
+	}
+	var id = graphql.ID(r.msg.ID)
+	return &id
+}
+
+func (r *changeResolver) Todo() *todoResolver {
+	if r.msg.Todo == nil {
+		return nil
+	}
+	return &todoResolver{*r.msg.Todo}
+}
+
+func (r *changeResolver) Todos() *[]*todoResolver {
+	if r.msg.Type != LiveReset {
+		return nil
+	}
+	var todos = todoResolvers(r.msg.Todos)
+	return &todos
+}
@@ -0,0 +1,355 @@
+package todo
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"path"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"todo/todopb"
+)
+
+// TodoService implements the TodoService gRPC api over a Store,
+// with the changes watched from the Hub.
+type TodoService struct {
+	todopb.UnimplementedTodoServiceServer
+
+	store Store
+	hub   *Hub
+	done  chan struct{}
+	once  sync.Once
+}
+
+// NewTodoService returns a new TodoService of the given store,
+// watching the changes of the given hub.
+func NewTodoService(store Store, hub *Hub) *TodoService {
+	return &TodoService{store: store, hub: hub, done: make(chan struct{})}
+}
+
+// Close ends the Watch streams.
+func (s *TodoService) Close() {
+	s.once.Do(func() { close(s.done) })
+}
+
+// storeOf returns the store logging and tracing within the given context.
+func (s *TodoService) storeOf(ctx context.Context) Store {
+	return s.store.WithContext(ctx)
+}
+
+func (s *TodoService) List(ctx context.Context, req *todopb.ListRequest) (*todopb.ListResponse, error) {
+	return &todopb.ListResponse{Todos: todoMessages(s.storeOf(ctx).List())}, nil
+}
+
+func (s *TodoService) Find(ctx context.Context, req *todopb.FindRequest) (*todopb.Todo, error) {
+	var todo, err = s.storeOf(ctx).Find(req.Id)
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	return todoMessage(todo), nil
+}
+
+// Create validates the todo as a patch of a new todo.
+func (s *TodoService) Create(ctx context.Context, req *todopb.CreateRequest) (*todopb.Todo, error) {
+	if req.Todo == nil {
+		return nil, grpcError(ctx, BadRequest{fmt.Errorf("grpc: missing todo")})
+	}
+
+	var todo = NewTodo(req.Todo.Title)
+	if len(req.Todo.Status) != 0 {
+		todo.Status = req.Todo.Status
+	}
+
+	var err = validTodo(*todo)
+	if err != nil {
+		return nil, grpcError(ctx, BadRequest{err})
+	}
+
+	err = s.storeOf(ctx).Save(todo)
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	return todoMessage(*todo), nil
+}
+
+// Update replaces an existing todo, keeping its created date.
+func (s *TodoService) Update(ctx context.Context, req *todopb.UpdateRequest) (*todopb.Todo, error) {
+	if req.Todo == nil || len(req.Todo.Id) == 0 {
+		return nil, grpcError(ctx, BadRequest{fmt.Errorf("grpc: missing todo id")})
+	}
+
+	var store = s.storeOf(ctx)
+	var todo, err = store.Find(req.Todo.Id)
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+
+	todo.Title, todo.Status = req.Todo.Title, req.Todo.Status
+	err = validTodo(todo)
+	if err != nil {
+		return nil, grpcError(ctx, BadRequest{err})
+	}
+
+	err = store.Save(&todo)
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	return todoMessage(todo), nil
+}
+
+func (s *TodoService) Delete(ctx context.Context, req *todopb.DeleteRequest) (*emptypb.Empty, error) {
+	var err = s.storeOf(ctx).Delete(req.Id)
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *TodoService) Filter(ctx context.Context, req *todopb.StatusRequest) (*todopb.ListResponse, error) {
+	return &todopb.ListResponse{Todos: todoMessages(s.storeOf(ctx).Filter(req.Status))}, nil
+}
+
+func (s *TodoService) Clear(ctx context.Context, req *todopb.StatusRequest) (*todopb.CountResponse, error) {
+	var count, err = s.storeOf(ctx).Clear(req.Status)
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	return &todopb.CountResponse{Count: count}, nil
+}
+
+func (s *TodoService) Toggle(ctx context.Context, req *todopb.StatusRequest) (*todopb.CountResponse, error) {
+	var count, err = s.storeOf(ctx).Toggle(req.Status)
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	return &todopb.CountResponse{Count: count}, nil
+}
+
+// Watch streams the changes broadcast by the hub, until the stream is
+// cancelled, the service closed, or the stream too slow to follow them.
+// The headers are sent once watching.
+func (s *TodoService) Watch(req *todopb.WatchRequest, stream todopb.TodoService_WatchServer) error {
+	var ctx, cancel = context.WithCancel(stream.Context())
+	defer cancel()
+
+	var events = s.hub.Subscribe(ctx)
+	var err = stream.SendHeader(metadata.MD{})
+	if err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case msg, ok := <-events:
+			if !ok && ctx.Err() != nil {
+				return status.FromContextError(ctx.Err()).Err()
+			}
+			if !ok {
+				return status.Error(codes.ResourceExhausted, "grpc: watch too slow")
+			}
+
+			if len(req.Id) != 0 && msg.Type != LiveReset && msg.ID != req.Id {
+				continue
+			}
+
+			err = stream.Send(changeMessage(msg))
+			if err != nil {
+				return err
+			}
+
+		case <-s.done:
+			return status.Error(codes.Unavailable, "grpc: server shutting down")
+		}
+	}
+}
+
+// validTodo checks the title and the status of a todo.
+func validTodo(todo Todo) error {
+	return TodoPatch{Title: &todo.Title, Status: &todo.Status}.Validate()
+}
+
+// grpcError returns the status of the given error.
+// Server errors are logged.
+func grpcError(ctx context.Context, err error) error {
+	switch err.(type) {
+	case BadRequest:
+		return status.Error(codes.InvalidArgument, err.Error())
+	case NotFound:
+		return status.Error(codes.NotFound, err.Error())
+	case Conflict:
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case TooManyRequests:
+		return status.Error(codes.ResourceExhausted, err.Error())
+	}
+
+	contextLogger(ctx).Error("grpc: error", "err", err)
+	return status.Error(codes.Internal, err.Error())
+}
+
+func todoMessage(todo Todo) *todopb.Todo {
+	return &todopb.Todo{
+		Id:      todo.ID,
+		Title:   todo.Title,
+		Status:  todo.Status,
+		Created: timestamppb.New(todo.Created),
+	}
+}
+
+func todoMessages(todos Todos) []*todopb.Todo {
+	var messages = make([]*todopb.Todo, len(todos))
+	for i, todo := range todos {
+		messages[i] = todoMessage(todo)
+	}
+	return messages
+}
+
+var changeTypes = map[string]todopb.Change_Type{
+	LiveSaved:   todopb.Change_SAVED,
+	LiveDeleted: todopb.Change_DELETED,
+	LiveReset:   todopb.Change_RESET,
+}
+
+func changeMessage(msg LiveMessage) *todopb.Change {
+	var change = &todopb.Change{Type: changeTypes[msg.Type], Id: msg.ID}
+	if msg.Todo != nil {
+		change.Todo = todoMessage(*msg.Todo)
+	}
+	if msg.Type == LiveReset {
+		change.Todos = todoMessages(msg.Todos)
+	}
+	return change
+}
+
+// TodoFromMessage returns the todo of the given message.
+func TodoFromMessage(m *todopb.Todo) Todo {
+	var todo = Todo{ID: m.GetId(), Title: m.GetTitle(), Status: m.GetStatus()}
+	if m.GetCreated() != nil {
+		todo.Created = m.GetCreated().AsTime()
+	}
+	return todo
+}
+
+// GRPCServer serves a TodoService over gRPC, next to the http handler.
+type GRPCServer struct {
+	*grpc.Server
+	service *TodoService
+}
+
+// NewGRPCServer returns a new GRPCServer of the given service,
+// logging the calls, with the given options.
+func NewGRPCServer(service *TodoService, options ...grpc.ServerOption) *GRPCServer {
+	var server = grpc.NewServer(append([]grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(grpcUnaryLogger),
+		grpc.ChainStreamInterceptor(grpcStreamLogger),
+	}, options...)...)
+	todopb.RegisterTodoServiceServer(server, service)
+	return &GRPCServer{server, service}
+}
+
+// Serve serves the calls on ln until stopped.
+func (s *GRPCServer) Serve(ln net.Listener) error {
+	var err = s.Server.Serve(ln)
+	if err == grpc.ErrServerStopped {
+		return nil
+	}
+	return err
+}
+
+// Shutdown ends the Watch streams, and waits for the active calls to
+// complete, until the context is done. The calls are cancelled then.
+func (s *GRPCServer) Shutdown(ctx context.Context) error {
+	s.service.Close()
+
+	var stopped = make(chan struct{})
+	go func() {
+		s.Server.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		s.Server.Stop()
+		<-stopped
+		return ctx.Err()
+	}
+}
+
+func grpcUnaryLogger(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	var start = time.Now()
+	var res, err = handler(ctx, req)
+	slog.Info("grpc", "method", info.FullMethod, "code", status.Code(err).String(), "duration", time.Since(start))
+	return res, err
+}
+
+func grpcStreamLogger(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	var start = time.Now()
+	var err = handler(srv, stream)
+	slog.Info("grpc", "method", info.FullMethod, "code", status.Code(err).String(), "duration", time.Since(start))
+	return err
+}
+
+// grpcReads are the methods limited as reads, the others as writes.
+var grpcReads = map[string]bool{"List": true, "Find": true, "Filter": true, "Watch": true}
+
+// GRPCLimits returns the server options authenticating the calls by
+// their bearer token, and limiting their rate, as the http api does.
+func GRPCLimits(users Users, limiter *RateLimiter) []grpc.ServerOption {
+	var guard = func(ctx context.Context, method string) error {
+		var authorization string
+		if md, ok := metadata.FromIncomingContext(ctx); ok && len(md.Get("authorization")) != 0 {
+			authorization = md.Get("authorization")[0]
+		}
+
+		var user, ok = users.Authenticate(authorization)
+		if !ok {
+			return status.Error(codes.Unauthenticated, "grpc: unknown token")
+		}
+		if limiter == nil {
+			return nil
+		}
+
+		var key = "user:" + user
+		if len(user) == 0 {
+			key = "ip:"
+			if p, ok := peer.FromContext(ctx); ok {
+				var host, _, _ = net.SplitHostPort(p.Addr.String())
+				key += host
+			}
+		}
+
+		var err = limiter.Allow(key, !grpcReads[path.Base(method)])
+		if err != nil {
+			return grpcError(ctx, err)
+		}
+		return nil
+	}
+
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			var err = guard(ctx, info.FullMethod)
+			if err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		}),
+		grpc.ChainStreamInterceptor(func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			var err = guard(stream.Context(), info.FullMethod)
+			if err != nil {
+				return err
+			}
+			return handler(srv, stream)
+		}),
+	}
+}
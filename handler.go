@@ -1,33 +1,31 @@
 package todo
 
 import (
+	"bytes"
 	"fmt"
-	"html/template"
-	"log"
+	"io"
 	"net/http"
 	"os"
-	"path"
 
 	"github.com/gorilla/handlers"
 )
 
-var templates *template.Template
-
-func init() {
-	templates = template.Must(template.New("base").Funcs(
-		template.FuncMap{"jsstr": templateJSStr}).ParseFiles("polymer/index.html"))
-}
-
 // AboutPage handles about page.
 func AboutPage(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, "Here is the about page.")
 }
 
 // HomePage handles index.html page.
-func HomePage(store Store) http.Handler {
+func HomePage(store Store, assets *Assets) http.Handler {
 	var fn = func(w http.ResponseWriter, r *http.Request) {
-		var todos = store.List()
-		var err = templates.ExecuteTemplate(w, "index.html", todos)
+		var templates, err = assets.Templates()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		var todos = store.WithContext(r.Context()).List()
+		err = templates.ExecuteTemplate(w, "index.html", todos)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 		}
@@ -36,22 +34,53 @@ func HomePage(store Store) http.Handler {
 	return http.HandlerFunc(fn)
 }
 
-// StaticPages handles static pages.
-func StaticPages(w http.ResponseWriter, r *http.Request) {
-	var upath = r.URL.Path
+// LoggingHandler
+func LoggingHandler(next http.Handler) http.Handler {
+	return accessLogHandler(os.Stderr, handlers.LoggingHandler)(next)
+}
 
-	if upath == "/" {
-		http.Redirect(w, r, "index.html", http.StatusMovedPermanently)
-		return
+// CombinedLoggingHandler
+func CombinedLoggingHandler(next http.Handler) http.Handler {
+	return accessLogHandler(os.Stderr, handlers.CombinedLoggingHandler)(next)
+}
+
+// accessLogHandler returns a handler logging the requests to out with
+// the given Apache log handler, the quoted request ID ending each line.
+func accessLogHandler(out io.Writer, logging func(io.Writer, http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		var fn = func(w http.ResponseWriter, r *http.Request) {
+			logging(requestIDWriter{out, RequestID(r)}, next).ServeHTTP(w, r)
+		}
+
+		return http.HandlerFunc(fn)
 	}
+}
 
-	upath = "polymer/" + upath
-	http.ServeFile(w, r, path.Clean(upath))
+// requestIDWriter appends the request ID to the log line written.
+type requestIDWriter struct {
+	io.Writer
+	id string
 }
 
-// LoggingHandler
-func LoggingHandler(next http.Handler) http.Handler {
-	return handlers.LoggingHandler(os.Stderr, next)
+func (w requestIDWriter) Write(b []byte) (int, error) {
+	var line = bytes.TrimSuffix(b, []byte("\n"))
+	var _, err = w.Writer.Write(append(line[:len(line):len(line)], fmt.Sprintf(" %q\n", w.id)...))
+	return len(b), err
+}
+
+// NewLoggingHandler returns the logging handler of the given log format.
+func NewLoggingHandler(format string) func(http.Handler) http.Handler {
+	switch format {
+	case LogCombined:
+		return CombinedLoggingHandler
+	case LogJSON, LogLogfmt:
+		return StructuredLoggingHandler
+	case LogNone:
+		return func(next http.Handler) http.Handler {
+			return next
+		}
+	}
+	return LoggingHandler
 }
 
 // RecoverHandler
@@ -59,7 +88,7 @@ func RecoverHandler(next http.Handler) http.Handler {
 	var fn = func(w http.ResponseWriter, r *http.Request) {
 		defer func() {
 			if err := recover(); err != nil {
-				log.Printf("panic: %s", err)
+				Logger(r).Error("panic", "err", err)
 				http.Error(w, http.StatusText(500), 500)
 			}
 		}()
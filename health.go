@@ -0,0 +1,101 @@
+package todo
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"time"
+)
+
+// Version is the build version, set with:
+// go build -ldflags "-X todo.Version=1.2.3"
+var Version = "dev"
+
+// ReadyTimeout is the maximum duration of a readiness Store ping.
+const ReadyTimeout = 2 * time.Second
+
+// Info defines the diagnostics of the running application.
+type Info struct {
+	Version       string    `json:"version"`
+	Revision      string    `json:"revision,omitempty"`
+	GoVersion     string    `json:"goVersion"`
+	Driver        string    `json:"driver"`
+	SchemaVersion int       `json:"schemaVersion,omitempty"`
+	Started       time.Time `json:"started"`
+	Uptime        string    `json:"uptime"`
+}
+
+// HealthPage handles liveness probes: the process is alive.
+func HealthPage(w http.ResponseWriter, r *http.Request) {
+	var health = map[string]string{"status": "ok"}
+	writeJSON(w, health, http.StatusOK) // 200
+}
+
+// ReadyPage handles readiness probes: the store answers a ping
+// within the given timeout, the ping canceled after.
+func ReadyPage(store Store, timeout time.Duration) http.Handler {
+	var fn = func(w http.ResponseWriter, r *http.Request) {
+		var ctx, cancel = context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+
+		var done = make(chan error, 1)
+		go func() {
+			done <- store.Ping(ctx)
+		}()
+
+		var err error
+		select {
+		case err = <-done:
+		case <-ctx.Done():
+			err = fmt.Errorf("store: ping timed out after %s", timeout)
+		}
+
+		if err != nil {
+			var ready = map[string]string{"status": "unavailable", "error": err.Error()}
+			writeJSON(w, ready, http.StatusServiceUnavailable) // 503
+			return
+		}
+
+		var ready = map[string]string{"status": "ok"}
+		writeJSON(w, ready, http.StatusOK) // 200
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+// InfoPage handles diagnostics of the application started at the given time,
+// with the schema version of the store, if reported.
+func InfoPage(driver string, store Store, started time.Time) http.Handler {
+	var revision string
+	if build, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range build.Settings {
+			if setting.Key == "vcs.revision" {
+				revision = setting.Value
+			}
+		}
+	}
+
+	var fn = func(w http.ResponseWriter, r *http.Request) error {
+		var info = Info{
+			Version:   Version,
+			Revision:  revision,
+			GoVersion: runtime.Version(),
+			Driver:    driver,
+			Started:   started,
+			Uptime:    time.Since(started).Round(time.Second).String(),
+		}
+
+		if versioner, ok := store.(SchemaVersioner); ok {
+			var version, err = versioner.SchemaVersion()
+			if err != nil {
+				return err // 500
+			}
+			info.SchemaVersion = version
+		}
+		return writeJSON(w, info, http.StatusOK) // 200
+	}
+
+	return ErrorFunc(fn)
+}
@@ -0,0 +1,167 @@
+package todo
+
+import (
+	"bytes"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// IdempotencyHeader is the header of the key, generated by the client,
+// of a POST request to process once, however many times it is sent.
+const IdempotencyHeader = "Idempotency-Key"
+
+// DefaultIdempotencyTTL is the time the responses of the keys are kept.
+const DefaultIdempotencyTTL = 24 * time.Hour
+
+// Idempotency replays the response of the first POST request of an
+// Idempotency-Key to the next requests of the key, to the same path
+// by the same authenticated user, if any, such as the creation of a todo retried after its response was lost.
+// The responses are kept in memory for the TTL, the server errors are
+// not kept: the request is processed again.
+type Idempotency struct {
+	TTL time.Duration
+
+	mu    sync.Mutex
+	keys  map[string]*idempotent
+	swept time.Time
+	now   func() time.Time
+}
+
+// idempotent is the response of a key, done once recorded.
+type idempotent struct {
+	done    chan struct{}
+	ok      bool
+	status  int
+	header  http.Header
+	body    []byte
+	expires time.Time
+}
+
+// NewIdempotency returns an Idempotency keeping the responses for the given TTL.
+func NewIdempotency(ttl time.Duration) *Idempotency {
+	return &Idempotency{
+		TTL:  ttl,
+		keys: make(map[string]*idempotent),
+		now:  time.Now,
+	}
+}
+
+// Handler returns a handler processing the POST requests of next once per key.
+// The requests of a key being processed wait for its response.
+func (i *Idempotency) Handler(next http.Handler) http.Handler {
+	var fn = func(w http.ResponseWriter, r *http.Request) {
+		var key = r.Header.Get(IdempotencyHeader)
+		if r.Method != "POST" || len(key) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		key = User(r) + " " + r.URL.Path + " " + key
+
+		for {
+			var entry, first = i.take(key)
+			if first {
+				i.record(key, entry, w, r, next)
+				return
+			}
+
+			select {
+			case <-entry.done:
+			case <-r.Context().Done():
+				return
+			}
+			if entry.ok {
+				replay(w, entry)
+				return
+			}
+		}
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+// take returns the entry of key, and whether it is new, to be recorded.
+func (i *Idempotency) take(key string) (*idempotent, bool) {
+	var now = i.now()
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.sweep(now)
+
+	if entry, found := i.keys[key]; found {
+		return entry, false
+	}
+
+	var entry = &idempotent{done: make(chan struct{})}
+	i.keys[key] = entry
+	return entry, true
+}
+
+// record serves the request of a new key, and keeps its response
+// unless a server error.
+func (i *Idempotency) record(key string, entry *idempotent, w http.ResponseWriter, r *http.Request, next http.Handler) {
+	var rec = &recordWriter{ResponseWriter: w}
+	defer func() {
+		i.mu.Lock()
+		entry.ok = rec.status != 0 && rec.status < http.StatusInternalServerError
+		if entry.ok {
+			entry.status, entry.header, entry.body = rec.status, w.Header().Clone(), rec.body.Bytes()
+			entry.expires = i.now().Add(i.TTL)
+		} else {
+			delete(i.keys, key)
+		}
+		i.mu.Unlock()
+		close(entry.done)
+	}()
+
+	next.ServeHTTP(rec, r)
+}
+
+// sweep removes the expired keys, once a minute. It requires i.mu held.
+func (i *Idempotency) sweep(now time.Time) {
+	if now.Sub(i.swept) < time.Minute {
+		return
+	}
+	i.swept = now
+
+	for key, entry := range i.keys {
+		if entry.ok && now.After(entry.expires) {
+			delete(i.keys, key)
+		}
+	}
+}
+
+// replay writes the recorded response of a key, but the headers
+// already set by the handlers of the request, such as its id.
+func replay(w http.ResponseWriter, entry *idempotent) {
+	for name, values := range entry.header {
+		if _, found := w.Header()[name]; !found {
+			w.Header()[name] = values
+		}
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(entry.status)
+	w.Write(entry.body)
+}
+
+// recordWriter records the status and the body of a response,
+// no status if neither written.
+type recordWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *recordWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recordWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
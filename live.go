@@ -0,0 +1,658 @@
+package todo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Live message types. The server sends a snapshot on connection, then
+// the changes of the todos, the presence of the peers, and acknowledges
+// the mutations and locks of the client.
+const (
+	LiveSnapshot = "snapshot"
+	LiveSaved    = "saved"
+	LiveDeleted  = "deleted"
+	LiveReset    = "reset"
+	LivePresence = "presence"
+	LiveAck      = "ack"
+	LiveError    = "error"
+
+	// client messages
+	LiveMutate = "mutate"
+	LiveLock   = "lock"
+	LiveUnlock = "unlock"
+)
+
+// OpPatch is the live mutation patching a todo.
+const OpPatch = "patch"
+
+// DefaultLockTimeout is the default duration of the edit locks,
+// renewed by locking the todo again.
+const DefaultLockTimeout = 30 * time.Second
+
+const (
+	liveWriteWait  = 10 * time.Second
+	livePongWait   = 60 * time.Second
+	livePingPeriod = livePongWait * 9 / 10
+	liveSendQueue  = 64
+	maxLiveMessage = 1 << 20
+)
+
+// LiveMessage defines the messages of the live channel.
+// Ref correlates the acks and errors with the client messages.
+type LiveMessage struct {
+	Type   string     `json:"type"`
+	Ref    string     `json:"ref,omitempty"`
+	ID     string     `json:"id,omitempty"`
+	Op     string     `json:"op,omitempty"`
+	Todo   *Todo      `json:"todo,omitempty"`
+	Patch  *TodoPatch `json:"patch,omitempty"`
+	Todos  Todos      `json:"todos,omitempty"`
+	Peer   string     `json:"peer,omitempty"`
+	Peers  []Peer     `json:"peers,omitempty"`
+	Locks  []Lock     `json:"locks,omitempty"`
+	Status int        `json:"status,omitempty"`
+	Error  string     `json:"error,omitempty"`
+}
+
+// Peer defines a client connected to the live channel.
+type Peer struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// Lock defines the edit lock of a todo by a peer.
+type Lock struct {
+	ID      string    `json:"id"`
+	Peer    string    `json:"peer"`
+	Name    string    `json:"name"`
+	Expires time.Time `json:"expires"`
+}
+
+// Hub serves the live channel of the todos over WebSocket. It
+// broadcasts the mutations of its Store, applies the mutations of the
+// peers, and tracks their presence and edit locks.
+//
+// The connections are closed when the http.Server serving them shuts down.
+type Hub struct {
+	LockTimeout time.Duration
+
+	// Limiter, if any, limits the mutations of the peers as writes.
+	Limiter *RateLimiter
+
+	store    Store
+	upgrader websocket.Upgrader
+
+	mu      sync.Mutex
+	seq     int
+	closed  bool
+	peers   map[*livePeer]bool
+	locks   map[string]*liveLock
+	subs    map[chan LiveMessage]bool
+	servers map[*http.Server]bool
+}
+
+type livePeer struct {
+	Peer
+	key  string
+	conn *websocket.Conn
+	send chan []byte
+	done chan struct{}
+	once sync.Once
+}
+
+type liveLock struct {
+	peer    *livePeer
+	expires time.Time
+	timer   *time.Timer
+}
+
+// NewHub returns a new Hub. Its Store must be set with Store.
+func NewHub() *Hub {
+	return &Hub{
+		LockTimeout: DefaultLockTimeout,
+		peers:       make(map[*livePeer]bool),
+		locks:       make(map[string]*liveLock),
+		subs:        make(map[chan LiveMessage]bool),
+		servers:     make(map[*http.Server]bool),
+	}
+}
+
+// Store returns a Store broadcasting the mutations of the given store,
+// used by the hub as well.
+func (h *Hub) Store(store Store) Store {
+	h.store = hubStore{store, h}
+	return h.store
+}
+
+// Subscribe returns the changes of the todos broadcast until the
+// given context is done, or the subscriber is too slow to receive them.
+// The returned channel is closed then.
+func (h *Hub) Subscribe(ctx context.Context) <-chan LiveMessage {
+	var sub = make(chan LiveMessage, liveSendQueue)
+
+	h.mu.Lock()
+	h.subs[sub] = true
+	h.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		h.mu.Lock()
+		h.unsubscribe(sub)
+		h.mu.Unlock()
+	}()
+
+	return sub
+}
+
+// unsubscribe closes the given subscription once. h.mu must be held.
+func (h *Hub) unsubscribe(sub chan LiveMessage) {
+	if h.subs[sub] {
+		delete(h.subs, sub)
+		close(sub)
+	}
+}
+
+// ServeHTTP upgrades the request to a WebSocket connection, named
+// by the "name" query parameter, and serves it until closed.
+func (h *Hub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.registerShutdown(r)
+
+	var conn, err = h.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return // 400, 403
+	}
+
+	var name = r.URL.Query().Get("name")
+	if len(name) == 0 {
+		name = User(r)
+	}
+	if len(name) == 0 {
+		name = "anonymous"
+	}
+
+	var peer = h.join(conn, name)
+	if peer == nil {
+		return
+	}
+	defer h.leave(peer)
+	peer.key = RateKey(r)
+
+	go peer.writeLoop()
+
+	// hijacked connections outlive the request
+	var store = h.store.WithContext(context.WithoutCancel(r.Context()))
+
+	h.reply(peer, LiveMessage{
+		Type:  LiveSnapshot,
+		Peer:  peer.ID,
+		Todos: store.List(),
+	})
+	h.broadcastPresence()
+
+	conn.SetReadLimit(maxLiveMessage)
+	conn.SetReadDeadline(time.Now().Add(livePongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(livePongWait))
+	})
+
+	for {
+		var _, data, err = conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		var msg LiveMessage
+		err = json.Unmarshal(data, &msg)
+		if err != nil {
+			err = BadRequest{fmt.Errorf("live: %s", err)}
+		} else {
+			msg, err = h.handle(peer, store, msg)
+		}
+
+		if err != nil {
+			msg = LiveMessage{Type: LiveError, Ref: msg.Ref, Status: errorStatus(r, err), Error: err.Error()}
+		}
+		h.reply(peer, msg)
+	}
+}
+
+// handle returns the reply to the given client message.
+func (h *Hub) handle(peer *livePeer, store Store, msg LiveMessage) (LiveMessage, error) {
+	var ack = LiveMessage{Type: LiveAck, Ref: msg.Ref, ID: msg.ID}
+
+	switch msg.Type {
+	case LiveMutate:
+		if h.Limiter != nil {
+			var err = h.Limiter.Allow(peer.key, true)
+			if err != nil {
+				return ack, err // 429
+			}
+		}
+
+		var todo, err = h.mutate(peer, store, msg)
+		if err != nil {
+			return ack, err // 400, 404, 409, 500
+		}
+		ack.ID, ack.Todo = todo.ID, &todo
+		return ack, nil
+
+	case LiveLock:
+		var _, err = store.Find(msg.ID)
+		if err != nil {
+			return ack, err // 404, 500
+		}
+		return ack, h.lock(peer, msg.ID) // 409
+
+	case LiveUnlock:
+		h.unlock(peer, msg.ID)
+		return ack, nil
+	}
+
+	return ack, BadRequest{fmt.Errorf("live: unknown message type %q", msg.Type)}
+}
+
+// mutate applies the mutation of the given message,
+// unless the todo is locked by another peer.
+func (h *Hub) mutate(peer *livePeer, store Store, msg LiveMessage) (Todo, error) {
+	var todo Todo
+	if msg.Todo != nil {
+		todo = *msg.Todo
+	}
+	if len(msg.ID) != 0 {
+		todo.ID = msg.ID
+	}
+
+	switch msg.Op {
+	case OpUpdate, OpDelete, OpPatch:
+		if len(todo.ID) == 0 {
+			return todo, BadRequest{fmt.Errorf("live: id is missing")} // 400
+		}
+	}
+
+	if msg.Op != OpCreate {
+		var err = h.checkLock(peer, todo.ID)
+		if err != nil {
+			return todo, err // 409
+		}
+	}
+
+	switch msg.Op {
+	case OpCreate:
+		todo.ID = ""
+		var err = store.Save(&todo)
+		return todo, err // 500
+
+	case OpUpdate:
+		var err = store.Save(&todo)
+		return todo, err // 404, 500
+
+	case OpDelete:
+		var err = store.Delete(todo.ID)
+		return todo, err // 404, 500
+
+	case OpPatch:
+		if msg.Patch == nil {
+			return todo, BadRequest{fmt.Errorf("live: patch is missing")} // 400
+		}
+		var err = msg.Patch.Validate()
+		if err != nil {
+			return todo, BadRequest{err} // 400
+		}
+		return store.Patch(todo.ID, *msg.Patch) // 404, 500
+	}
+
+	return todo, BadRequest{fmt.Errorf("live: unknown op %q", msg.Op)} // 400
+}
+
+// lock locks the given todo for the peer, or renews its lock.
+func (h *Hub) lock(peer *livePeer, id string) error {
+	h.mu.Lock()
+
+	var l = h.locks[id]
+	if l != nil && l.peer != peer {
+		h.mu.Unlock()
+		return Conflict{fmt.Errorf("live: todo %s is edited by %s", id, l.peer.Name)}
+	}
+
+	if l == nil {
+		l = &liveLock{peer: peer}
+		l.timer = time.AfterFunc(h.LockTimeout, func() { h.expire(id, l) })
+		h.locks[id] = l
+	} else {
+		l.timer.Reset(h.LockTimeout)
+	}
+	l.expires = time.Now().Add(h.LockTimeout).UTC()
+
+	h.mu.Unlock()
+
+	h.broadcastPresence()
+	return nil
+}
+
+// unlock releases the lock of the given todo by the peer, if any.
+func (h *Hub) unlock(peer *livePeer, id string) {
+	h.mu.Lock()
+	var l = h.locks[id]
+	if l == nil || l.peer != peer {
+		h.mu.Unlock()
+		return
+	}
+	l.timer.Stop()
+	delete(h.locks, id)
+	h.mu.Unlock()
+
+	h.broadcastPresence()
+}
+
+// release releases the lock of the given todo by any peer.
+func (h *Hub) release(id string) {
+	h.mu.Lock()
+	var l = h.locks[id]
+	if l == nil {
+		h.mu.Unlock()
+		return
+	}
+	l.timer.Stop()
+	delete(h.locks, id)
+	h.mu.Unlock()
+
+	h.broadcastPresence()
+}
+
+// expire releases the given lock when timed out.
+func (h *Hub) expire(id string, l *liveLock) {
+	h.mu.Lock()
+	if h.locks[id] != l {
+		h.mu.Unlock()
+		return
+	}
+	delete(h.locks, id)
+	h.mu.Unlock()
+
+	h.broadcastPresence()
+}
+
+// checkLock returns a conflict if the given todo is locked by another peer.
+func (h *Hub) checkLock(peer *livePeer, id string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if l := h.locks[id]; l != nil && l.peer != peer {
+		return Conflict{fmt.Errorf("live: todo %s is edited by %s", id, l.peer.Name)}
+	}
+	return nil
+}
+
+// join adds a peer of the given connection,
+// or closes it if the hub is closed.
+func (h *Hub) join(conn *websocket.Conn, name string) *livePeer {
+	var peer = &livePeer{
+		conn: conn,
+		send: make(chan []byte, liveSendQueue),
+		done: make(chan struct{}),
+	}
+
+	h.mu.Lock()
+	if h.closed {
+		h.mu.Unlock()
+		peer.close(websocket.CloseGoingAway, "server shutting down")
+		return nil
+	}
+	h.seq++
+	peer.Peer = Peer{ID: strconv.Itoa(h.seq), Name: name}
+	h.peers[peer] = true
+	h.mu.Unlock()
+
+	return peer
+}
+
+// leave removes the peer and releases its locks.
+func (h *Hub) leave(peer *livePeer) {
+	peer.close(websocket.CloseNormalClosure, "")
+
+	h.mu.Lock()
+	delete(h.peers, peer)
+	for id, l := range h.locks {
+		if l.peer == peer {
+			l.timer.Stop()
+			delete(h.locks, id)
+		}
+	}
+	h.mu.Unlock()
+
+	h.broadcastPresence()
+}
+
+// Close closes the connections of the peers.
+func (h *Hub) Close() {
+	h.mu.Lock()
+	h.closed = true
+	var peers = make([]*livePeer, 0, len(h.peers))
+	for peer := range h.peers {
+		peers = append(peers, peer)
+	}
+	h.mu.Unlock()
+
+	for _, peer := range peers {
+		peer.close(websocket.CloseGoingAway, "server shutting down")
+	}
+}
+
+// registerShutdown closes the connections on shutdown
+// of the server of the given request.
+func (h *Hub) registerShutdown(r *http.Request) {
+	var server, ok = r.Context().Value(http.ServerContextKey).(*http.Server)
+	if !ok {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !h.servers[server] {
+		h.servers[server] = true
+		server.RegisterOnShutdown(h.Close)
+	}
+}
+
+// broadcastPresence sends the peers and the locks to all peers.
+func (h *Hub) broadcastPresence() {
+	var msg = LiveMessage{Type: LivePresence}
+
+	h.mu.Lock()
+	for peer := range h.peers {
+		msg.Peers = append(msg.Peers, peer.Peer)
+	}
+	for id, l := range h.locks {
+		msg.Locks = append(msg.Locks, Lock{ID: id, Peer: l.peer.ID, Name: l.peer.Name, Expires: l.expires})
+	}
+	h.mu.Unlock()
+
+	// peers by joining order
+	sort.Slice(msg.Peers, func(i, j int) bool {
+		var a, _ = strconv.Atoi(msg.Peers[i].ID)
+		var b, _ = strconv.Atoi(msg.Peers[j].ID)
+		return a < b
+	})
+	sort.Slice(msg.Locks, func(i, j int) bool {
+		return msg.Locks[i].ID < msg.Locks[j].ID
+	})
+
+	h.broadcast(msg)
+}
+
+// broadcast sends the given message to all peers,
+// and the changes to the subscribers.
+func (h *Hub) broadcast(msg LiveMessage) {
+	var data, _ = json.Marshal(msg)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for peer := range h.peers {
+		peer.queue(data)
+	}
+
+	if msg.Type == LivePresence {
+		return
+	}
+	for sub := range h.subs {
+		select {
+		case sub <- msg:
+		default:
+			h.unsubscribe(sub)
+		}
+	}
+}
+
+// reply sends the given message to the peer.
+func (h *Hub) reply(peer *livePeer, msg LiveMessage) {
+	var data, _ = json.Marshal(msg)
+	peer.queue(data)
+}
+
+// queue queues the data to send, closing slow peers.
+func (p *livePeer) queue(data []byte) {
+	select {
+	case p.send <- data:
+	default:
+		go p.close(websocket.ClosePolicyViolation, "too slow")
+	}
+}
+
+// writeLoop sends the queued messages and the pings.
+func (p *livePeer) writeLoop() {
+	var ticker = time.NewTicker(livePingPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case data := <-p.send:
+			p.conn.SetWriteDeadline(time.Now().Add(liveWriteWait))
+			var err = p.conn.WriteMessage(websocket.TextMessage, data)
+			if err != nil {
+				p.close(websocket.CloseAbnormalClosure, "")
+				return
+			}
+		case <-ticker.C:
+			var err = p.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(liveWriteWait))
+			if err != nil {
+				p.close(websocket.CloseAbnormalClosure, "")
+				return
+			}
+		case <-p.done:
+			return
+		}
+	}
+}
+
+// close closes the connection once, with the given close code.
+func (p *livePeer) close(code int, text string) {
+	p.once.Do(func() {
+		close(p.done)
+		if code != websocket.CloseAbnormalClosure {
+			var msg = websocket.FormatCloseMessage(code, text)
+			p.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(liveWriteWait))
+		}
+		p.conn.Close()
+	})
+}
+
+// hubStore broadcasts the mutations of a Store.
+type hubStore struct {
+	Store
+	hub *Hub
+}
+
+func (s hubStore) Save(t *Todo) error {
+	var err = s.Store.Save(t)
+	if err == nil {
+		var saved = *t
+		s.hub.broadcast(LiveMessage{Type: LiveSaved, ID: saved.ID, Todo: &saved})
+	}
+	return err
+}
+
+func (s hubStore) Delete(id string) error {
+	var err = s.Store.Delete(id)
+	if err == nil {
+		s.deleted(id)
+	}
+	return err
+}
+
+func (s hubStore) Patch(id string, patch TodoPatch) (Todo, error) {
+	var todo, err = s.Store.Patch(id, patch)
+	if err == nil {
+		s.hub.broadcast(LiveMessage{Type: LiveSaved, ID: todo.ID, Todo: &todo})
+	}
+	return todo, err
+}
+
+func (s hubStore) Clear(status string) (int64, error) {
+	var count, err = s.Store.Clear(status)
+	if err == nil && count != 0 {
+		s.reset()
+	}
+	return count, err
+}
+
+func (s hubStore) Toggle(status string) (int64, error) {
+	var count, err = s.Store.Toggle(status)
+	if err == nil && count != 0 {
+		s.reset()
+	}
+	return count, err
+}
+
+// Batch broadcasts all the todos when failed, as the operations
+// of a failed batch may be applied partly.
+func (s hubStore) Batch(ops []Operation) (Results, error) {
+	var results, err = s.Store.Batch(ops)
+	if err != nil {
+		s.reset()
+		return results, err
+	}
+
+	for i, result := range results {
+		if result.Op == OpDelete {
+			s.deleted(ops[i].Todo.ID)
+		} else if result.Todo != nil {
+			var todo = *result.Todo
+			s.hub.broadcast(LiveMessage{Type: LiveSaved, ID: todo.ID, Todo: &todo})
+		}
+	}
+	return results, err
+}
+
+func (s hubStore) Import(todos Todos) (int64, error) {
+	var count, err = s.Store.Import(todos)
+	if err == nil && count != 0 {
+		s.reset()
+	}
+	return count, err
+}
+
+func (s hubStore) WithContext(ctx context.Context) Store {
+	return hubStore{s.Store.WithContext(ctx), s.hub}
+}
+
+// deleted broadcasts the deletion of a todo, and releases its lock.
+func (s hubStore) deleted(id string) {
+	s.hub.broadcast(LiveMessage{Type: LiveDeleted, ID: id})
+	s.hub.release(id)
+}
+
+// reset broadcasts all the todos after a bulk change.
+func (s hubStore) reset() {
+	s.hub.broadcast(LiveMessage{Type: LiveReset, Todos: s.Store.List()})
+}
@@ -0,0 +1,119 @@
+package todo
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// Structured log formats.
+const (
+	LogJSON   = "json"
+	LogLogfmt = "logfmt"
+)
+
+// RequestIDHeader is the header carrying the request ID.
+const RequestIDHeader = "X-Request-ID"
+
+type contextKey int
+
+const (
+	loggerKey contextKey = iota
+	requestIDKey
+)
+
+// NewLogger returns a leveled logger writing to w, as JSON
+// with the json format, or as logfmt otherwise.
+func NewLogger(w io.Writer, format, level string) (*slog.Logger, error) {
+	var lvl slog.Level
+	var err = lvl.UnmarshalText([]byte(level))
+	if err != nil {
+		return nil, fmt.Errorf("log: unknown level %q", level)
+	}
+
+	var opts = &slog.HandlerOptions{Level: lvl}
+
+	if format == LogJSON {
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	}
+	return slog.New(slog.NewTextHandler(w, opts)), nil
+}
+
+// Logger returns the logger of the given request,
+// or the default logger outside of a request.
+func Logger(r *http.Request) *slog.Logger {
+	return contextLogger(r.Context())
+}
+
+func contextLogger(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// RequestID returns the ID of the given request.
+func RequestID(r *http.Request) string {
+	var id, _ = r.Context().Value(requestIDKey).(string)
+	return id
+}
+
+// RequestIDHandler returns a handler which honours the request ID
+// given by the client, or generates one, and sets it in the response.
+// The request logger, derived from the given logger, adds the ID
+// to every line logged while serving the request.
+func RequestIDHandler(logger *slog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		var fn = func(w http.ResponseWriter, r *http.Request) {
+			var id = r.Header.Get(RequestIDHeader)
+			if !validRequestID(id) {
+				id, _ = newUUID()
+			}
+
+			w.Header().Set(RequestIDHeader, id)
+
+			var ctx = context.WithValue(r.Context(), requestIDKey, id)
+			ctx = context.WithValue(ctx, loggerKey, logger.With("request_id", id))
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+		}
+
+		return http.HandlerFunc(fn)
+	}
+}
+
+// validRequestID accepts short IDs of visible ASCII characters.
+func validRequestID(id string) bool {
+	if len(id) == 0 || len(id) > 128 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// StructuredLoggingHandler logs requests with the request logger.
+func StructuredLoggingHandler(next http.Handler) http.Handler {
+	var fn = func(w http.ResponseWriter, r *http.Request) {
+		var sw = &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		var start = time.Now()
+
+		next.ServeHTTP(sw, r)
+
+		Logger(r).Info("request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", sw.status,
+			"duration", time.Since(start),
+			"remote", r.RemoteAddr,
+		)
+	}
+
+	return http.HandlerFunc(fn)
+}
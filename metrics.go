@@ -0,0 +1,371 @@
+package todo
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// buckets are the latency histogram upper bounds, in seconds.
+var buckets = []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Metrics collects the application metrics,
+// and serves them in the Prometheus text format.
+type Metrics struct {
+	mu         sync.Mutex
+	requests   *metricVec
+	durations  *metricVec
+	storeOps   *metricVec
+	storeErrs  *metricVec
+	collectors []func(w io.Writer)
+}
+
+// NewMetrics returns new empty metrics.
+func NewMetrics() *Metrics {
+	return &Metrics{
+		requests: newMetricVec("todo_http_requests_total", "counter",
+			"Number of HTTP requests.", "route", "method", "code"),
+		durations: newMetricVec("todo_http_request_duration_seconds", "histogram",
+			"HTTP request latencies in seconds.", "route", "method", "code"),
+		storeOps: newMetricVec("todo_store_operation_duration_seconds", "histogram",
+			"Store operation latencies in seconds.", "op"),
+		storeErrs: newMetricVec("todo_store_errors_total", "counter",
+			"Number of failed store operations.", "op"),
+	}
+}
+
+// Instrument returns a handler recording the requests of next,
+// labelled by the name of the route of router matching the request,
+// or else by the given name.
+func (m *Metrics) Instrument(router *mux.Router, name string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		var fn = func(w http.ResponseWriter, r *http.Request) {
+			var route = name
+
+			var match mux.RouteMatch
+			if router != nil && router.Match(r, &match) && match.Route != nil {
+				route = match.Route.GetName()
+			}
+
+			var sw = &statusWriter{ResponseWriter: w, status: http.StatusOK}
+			var start = time.Now()
+
+			next.ServeHTTP(sw, r)
+
+			var code = strconv.Itoa(sw.status)
+			var elapsed = time.Since(start).Seconds()
+
+			m.mu.Lock()
+			m.requests.add(1, route, r.Method, code)
+			m.durations.observe(elapsed, route, r.Method, code)
+			m.mu.Unlock()
+		}
+
+		return http.HandlerFunc(fn)
+	}
+}
+
+// StorePool reports the connection pool of the given store, if an sql store.
+// It is given the store before any decorator, as they hide its pool.
+func (m *Metrics) StorePool(store Store) {
+	if stats, ok := store.(interface{ Stats() sql.DBStats }); ok {
+		m.collect(func(w io.Writer) {
+			writeDBStats(w, stats.Stats())
+		})
+	}
+}
+
+// Store returns a Store recording the operations of the given store.
+// The cache of a cached store is reported as well.
+func (m *Metrics) Store(store Store) Store {
+	if stats, ok := store.(interface{ CacheStats() CacheStats }); ok {
+		m.collect(func(w io.Writer) {
+			writeCacheStats(w, stats.CacheStats())
+		})
+	}
+
+	return metricsStore{store, m}
+}
+
+// collect registers a function writing metrics computed when served.
+func (m *Metrics) collect(fn func(w io.Writer)) {
+	m.mu.Lock()
+	m.collectors = append(m.collectors, fn)
+	m.mu.Unlock()
+}
+
+// observeStore records a store operation started at the given time.
+func (m *Metrics) observeStore(op string, start time.Time, err error) {
+	var elapsed = time.Since(start).Seconds()
+
+	m.mu.Lock()
+	m.storeOps.observe(elapsed, op)
+	if err != nil {
+		m.storeErrs.add(1, op)
+	}
+	m.mu.Unlock()
+}
+
+// ServeHTTP serves the metrics in the Prometheus text format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WriteText(w)
+}
+
+// WriteText writes the metrics in the Prometheus text format.
+func (m *Metrics) WriteText(w io.Writer) error {
+	var bw = bufio.NewWriter(w)
+
+	m.mu.Lock()
+	for _, vec := range []*metricVec{m.requests, m.durations, m.storeOps, m.storeErrs} {
+		vec.write(bw)
+	}
+	var collectors = m.collectors
+	m.mu.Unlock()
+
+	for _, fn := range collectors {
+		fn(bw)
+	}
+
+	return bw.Flush()
+}
+
+// statusWriter records the status of a response.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Flush implements http.Flusher for streamed responses.
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker for upgraded connections.
+func (w *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	var h, ok = w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("metrics: response does not implement http.Hijacker")
+	}
+	w.status = http.StatusSwitchingProtocols
+	return h.Hijack()
+}
+
+// Unwrap returns the original response writer, for http.ResponseController.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// metric defines a counter or an histogram of given label values.
+type metric struct {
+	labels  []string
+	value   float64
+	buckets []uint64
+	count   uint64
+}
+
+// metricVec defines a family of metrics of the same name.
+type metricVec struct {
+	name    string
+	kind    string
+	help    string
+	labels  []string
+	metrics map[string]*metric
+}
+
+func newMetricVec(name, kind, help string, labels ...string) *metricVec {
+	return &metricVec{
+		name:    name,
+		kind:    kind,
+		help:    help,
+		labels:  labels,
+		metrics: make(map[string]*metric),
+	}
+}
+
+func (v *metricVec) get(labels []string) *metric {
+	var key = strings.Join(labels, "\xff")
+
+	var m, ok = v.metrics[key]
+	if !ok {
+		m = &metric{labels: labels}
+		if v.kind == "histogram" {
+			m.buckets = make([]uint64, len(buckets))
+		}
+		v.metrics[key] = m
+	}
+	return m
+}
+
+func (v *metricVec) add(delta float64, labels ...string) {
+	v.get(labels).value += delta
+}
+
+func (v *metricVec) observe(value float64, labels ...string) {
+	var m = v.get(labels)
+	for i, le := range buckets {
+		if value <= le {
+			m.buckets[i]++
+		}
+	}
+	m.value += value
+	m.count++
+}
+
+func (v *metricVec) write(w io.Writer) {
+	if len(v.metrics) == 0 {
+		return
+	}
+
+	fmt.Fprintf(w, "# HELP %s %s\n", v.name, v.help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", v.name, v.kind)
+
+	var keys = make([]string, 0, len(v.metrics))
+	for key := range v.metrics {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		var m = v.metrics[key]
+		var labels = formatLabels(v.labels, m.labels)
+
+		if v.kind != "histogram" {
+			fmt.Fprintf(w, "%s{%s} %s\n", v.name, labels, formatFloat(m.value))
+			continue
+		}
+
+		for i, le := range buckets {
+			fmt.Fprintf(w, "%s_bucket{%s,le=\"%s\"} %d\n",
+				v.name, labels, formatFloat(le), m.buckets[i])
+		}
+		fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", v.name, labels, m.count)
+		fmt.Fprintf(w, "%s_sum{%s} %s\n", v.name, labels, formatFloat(m.value))
+		fmt.Fprintf(w, "%s_count{%s} %d\n", v.name, labels, m.count)
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabels(names, values []string) string {
+	var pairs = make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = fmt.Sprintf("%s=\"%s\"", name, labelEscaper.Replace(values[i]))
+	}
+	return strings.Join(pairs, ",")
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+func writeDBStats(w io.Writer, stats sql.DBStats) {
+	var gauges = []struct {
+		name  string
+		help  string
+		kind  string
+		value float64
+	}{
+		{"todo_db_open_connections", "Number of open connections.", "gauge", float64(stats.OpenConnections)},
+		{"todo_db_in_use_connections", "Number of connections in use.", "gauge", float64(stats.InUse)},
+		{"todo_db_idle_connections", "Number of idle connections.", "gauge", float64(stats.Idle)},
+		{"todo_db_wait_count_total", "Number of connections waited for.", "counter", float64(stats.WaitCount)},
+		{"todo_db_wait_duration_seconds_total", "Time blocked waiting for connections.", "counter", stats.WaitDuration.Seconds()},
+	}
+
+	for _, g := range gauges {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %s\n",
+			g.name, g.help, g.name, g.kind, g.name, formatFloat(g.value))
+	}
+}
+
+// metricsStore records the operations of a Store.
+type metricsStore struct {
+	Store
+	metrics *Metrics
+}
+
+func (s metricsStore) List() Todos {
+	defer s.metrics.observeStore("List", time.Now(), nil)
+	return s.Store.List()
+}
+
+func (s metricsStore) Find(id string) (todo Todo, err error) {
+	defer func(start time.Time) { s.metrics.observeStore("Find", start, storeError(err)) }(time.Now())
+	return s.Store.Find(id)
+}
+
+func (s metricsStore) Save(t *Todo) (err error) {
+	defer func(start time.Time) { s.metrics.observeStore("Save", start, storeError(err)) }(time.Now())
+	return s.Store.Save(t)
+}
+
+func (s metricsStore) Delete(id string) (err error) {
+	defer func(start time.Time) { s.metrics.observeStore("Delete", start, storeError(err)) }(time.Now())
+	return s.Store.Delete(id)
+}
+
+func (s metricsStore) Patch(id string, patch TodoPatch) (todo Todo, err error) {
+	defer func(start time.Time) { s.metrics.observeStore("Patch", start, storeError(err)) }(time.Now())
+	return s.Store.Patch(id, patch)
+}
+
+func (s metricsStore) Filter(status string) Todos {
+	defer s.metrics.observeStore("Filter", time.Now(), nil)
+	return s.Store.Filter(status)
+}
+
+func (s metricsStore) Clear(status string) (count int64, err error) {
+	defer func(start time.Time) { s.metrics.observeStore("Clear", start, err) }(time.Now())
+	return s.Store.Clear(status)
+}
+
+func (s metricsStore) Toggle(status string) (count int64, err error) {
+	defer func(start time.Time) { s.metrics.observeStore("Toggle", start, err) }(time.Now())
+	return s.Store.Toggle(status)
+}
+
+func (s metricsStore) Batch(ops []Operation) (results Results, err error) {
+	defer func(start time.Time) { s.metrics.observeStore("Batch", start, err) }(time.Now())
+	return s.Store.Batch(ops)
+}
+
+func (s metricsStore) Import(todos Todos) (count int64, err error) {
+	defer func(start time.Time) { s.metrics.observeStore("Import", start, err) }(time.Now())
+	return s.Store.Import(todos)
+}
+
+func (s metricsStore) WithContext(ctx context.Context) Store {
+	return metricsStore{s.Store.WithContext(ctx), s.metrics}
+}
+
+func (s metricsStore) Ping(ctx context.Context) (err error) {
+	defer func(start time.Time) { s.metrics.observeStore("Ping", start, err) }(time.Now())
+	return s.Store.Ping(ctx)
+}
+
+// storeError ignores NotFound errors, which are not store failures.
+func storeError(err error) error {
+	if _, ok := err.(NotFound); ok {
+		return nil
+	}
+	return err
+}
@@ -1,6 +1,7 @@
 package todo
 
 import (
+	"crypto/rand"
 	"fmt"
 	"time"
 )
@@ -53,3 +54,17 @@ func (bc ByCreated) Swap(i, j int) {
 func (bc ByCreated) Less(i, j int) bool {
 	return bc[i].Created.After(bc[j].Created)
 }
+
+// newUUID returns a random (version 4) UUID.
+func newUUID() (string, error) {
+	var b = make([]byte, 16)
+	var _, err = rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
@@ -0,0 +1,354 @@
+package todo
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// apiOperation documents the operation of a named route.
+// Bodies are schema names, "formats" for the export formats,
+// or "patches" for the patch types.
+type apiOperation struct {
+	Summary   string
+	Request   string
+	Query     []string
+	Responses map[int]string
+}
+
+var apiOperations = map[string]apiOperation{
+	RouteList: {
+		Summary:   "List all todos, the most recent first",
+		Responses: map[int]string{200: "Todos"},
+	},
+	RouteCreate: {
+		Summary:   "Create a todo",
+		Request:   "Todo",
+		Responses: map[int]string{201: "Todo", 400: ""},
+	},
+	RouteBatch: {
+		Summary:   "Create, update and delete todos at once, all or nothing",
+		Request:   "Operations",
+		Responses: map[int]string{200: "Results", 400: "Results", 404: "Results"},
+	},
+	RouteExport: {
+		Summary:   "Export all todos",
+		Query:     []string{"format"},
+		Responses: map[int]string{200: "formats", 400: ""},
+	},
+	RouteImport: {
+		Summary:   "Import todos, keeping their ids; nothing is imported on errors",
+		Request:   "formats",
+		Query:     []string{"format"},
+		Responses: map[int]string{200: "Imported", 400: "Imported"},
+	},
+	RouteFind: {
+		Summary:   "Find a todo",
+		Responses: map[int]string{200: "Todo", 404: ""},
+	},
+	RouteUpdate: {
+		Summary:   "Update a todo",
+		Request:   "Todo",
+		Responses: map[int]string{200: "Todo", 400: "", 404: ""},
+	},
+	RouteDelete: {
+		Summary:   "Delete a todo",
+		Responses: map[int]string{204: "", 404: ""},
+	},
+	RoutePatch: {
+		Summary:   "Update the given fields of a todo",
+		Request:   "patches",
+		Responses: map[int]string{200: "Todo", 400: "", 404: "", 409: ""},
+	},
+	RouteFilter: {
+		Summary:   "List the todos of a status",
+		Responses: map[int]string{200: "Todos"},
+	},
+	RouteClear: {
+		Summary:   "Delete the todos of a status",
+		Responses: map[int]string{200: "Count"},
+	},
+	RouteToggle: {
+		Summary:   "Set the status of all todos",
+		Responses: map[int]string{200: "Count"},
+	},
+}
+
+// apiSchemas are the types of the components schemas.
+var apiSchemas = map[string]reflect.Type{
+	"Todo":           reflect.TypeOf(Todo{}),
+	"Operation":      reflect.TypeOf(Operation{}),
+	"Result":         reflect.TypeOf(Result{}),
+	"Imported":       reflect.TypeOf(Imported{}),
+	"ParseError":     reflect.TypeOf(ParseError{}),
+	"Page":           reflect.TypeOf(Page{}),
+	"Problem":        reflect.TypeOf(Problem{}),
+	"TodoPatch":      reflect.TypeOf(TodoPatch{}),
+	"PatchOperation": reflect.TypeOf(PatchOperation{}),
+}
+
+// apiQuery are the schemas of the query parameters.
+var apiQuery = map[string]map[string]interface{}{
+	"format": {"type": "string", "enum": formatNames()},
+	"offset": {"type": "integer", "minimum": 0},
+	"limit":  {"type": "integer", "minimum": 1, "maximum": MaxPageLimit},
+}
+
+// v2 returns the operation of the v2 api,
+// which lists todos by pages.
+func (op apiOperation) v2() apiOperation {
+	var responses = make(map[int]string)
+	for status, schema := range op.Responses {
+		responses[status] = schema
+	}
+
+	if responses[http.StatusOK] == "Todos" {
+		responses[http.StatusOK] = "Page"
+		responses[http.StatusBadRequest] = ""
+		op.Query = append(op.Query, "offset", "limit")
+	}
+
+	op.Responses = responses
+	return op
+}
+
+// apiEnums are the values of the enumerated schema properties.
+var apiEnums = map[string][]string{
+	"Todo.status":       {"active", "completed"},
+	"TodoPatch.status":  {"active", "completed"},
+	"PatchOperation.op": {"add", "replace", "test"},
+	"Operation.op":      {OpCreate, OpUpdate, OpDelete},
+	"Result.op":         {OpCreate, OpUpdate, OpDelete},
+}
+
+var pathVar = regexp.MustCompile(`\{([^:}]+)(?::([^}]+))?\}`)
+
+// OpenAPI returns the OpenAPI 3 document of the routes of router,
+// serving the given api version. Every named route must be documented,
+// and every documented operation must have a route.
+func OpenAPI(router *mux.Router, version string) (map[string]interface{}, error) {
+	type kv = map[string]interface{}
+
+	var paths = make(kv)
+	var documented = make(map[string]bool)
+
+	var err = router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		var name = route.GetName()
+		var op, ok = apiOperations[name]
+		if !ok {
+			return fmt.Errorf("openapi: route %q is not documented", name)
+		}
+		documented[name] = true
+
+		if version == APIv2 {
+			op = op.v2()
+		}
+
+		tpl, err := route.GetPathTemplate()
+		if err != nil {
+			return err
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return err
+		}
+
+		var params []kv
+		for _, m := range pathVar.FindAllStringSubmatch(tpl, -1) {
+			var schema = kv{"type": "string"}
+			if len(m[2]) != 0 {
+				schema["pattern"] = "^" + m[2] + "$"
+			}
+			params = append(params, kv{"name": m[1], "in": "path", "required": true, "schema": schema})
+		}
+		for _, q := range op.Query {
+			params = append(params, kv{"name": q, "in": "query", "schema": apiQuery[q]})
+		}
+
+		var path = pathVar.ReplaceAllString(tpl, "{$1}")
+		var item, _ = paths[path].(kv)
+		if item == nil {
+			item = make(kv)
+			paths[path] = item
+		}
+
+		var responses = make(kv)
+		for status, schema := range op.Responses {
+			var response = kv{"description": http.StatusText(status)}
+			if len(schema) != 0 {
+				response["content"] = apiContent(schema)
+			} else if status >= 400 && version == APIv2 {
+				response["content"] = kv{ProblemType: kv{"schema": kv{"$ref": "#/components/schemas/Problem"}}}
+			}
+			responses[fmt.Sprint(status)] = response
+		}
+
+		var operation = kv{
+			"operationId": name,
+			"summary":     op.Summary,
+			"responses":   responses,
+		}
+		if len(params) != 0 {
+			operation["parameters"] = params
+		}
+		if len(op.Request) != 0 {
+			operation["requestBody"] = kv{"required": true, "content": apiContent(op.Request)}
+		}
+
+		for _, method := range methods {
+			item[strings.ToLower(method)] = operation
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for name := range apiOperations {
+		if !documented[name] {
+			return nil, fmt.Errorf("openapi: operation %q has no route", name)
+		}
+	}
+
+	var schemas = kv{
+		"Todos":      kv{"type": "array", "items": kv{"$ref": "#/components/schemas/Todo"}},
+		"Operations": kv{"type": "array", "items": kv{"$ref": "#/components/schemas/Operation"}},
+		"Results":    kv{"type": "array", "items": kv{"$ref": "#/components/schemas/Result"}},
+		"Count": kv{
+			"type":       "object",
+			"properties": kv{"count": kv{"type": "integer", "format": "int64"}},
+		},
+	}
+	for name, t := range apiSchemas {
+		schemas[name] = jsonSchema(name, t)
+	}
+
+	return kv{
+		"openapi": "3.0.3",
+		"info": kv{
+			"title":   "todo api " + version,
+			"version": Version,
+		},
+		"paths":      paths,
+		"components": kv{"schemas": schemas},
+	}, nil
+}
+
+// OpenAPIPage serves the OpenAPI document of the routes of router.
+func OpenAPIPage(router *mux.Router, version string) (http.Handler, error) {
+	var spec, err = OpenAPI(router, version)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := json.MarshalIndent(spec, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	var fn = func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Write(data)
+	}
+	return http.HandlerFunc(fn), nil
+}
+
+// apiContent returns the content of a request or response body.
+func apiContent(schema string) map[string]interface{} {
+	if schema == "patches" {
+		return map[string]interface{}{
+			MergePatchType: map[string]interface{}{
+				"schema": map[string]interface{}{"$ref": "#/components/schemas/TodoPatch"},
+			},
+			JSONPatchType: map[string]interface{}{
+				"schema": map[string]interface{}{
+					"type":  "array",
+					"items": map[string]interface{}{"$ref": "#/components/schemas/PatchOperation"},
+				},
+			},
+		}
+	}
+
+	if schema != "formats" {
+		return map[string]interface{}{
+			"application/json": map[string]interface{}{
+				"schema": map[string]interface{}{"$ref": "#/components/schemas/" + schema},
+			},
+		}
+	}
+
+	var content = make(map[string]interface{})
+	for format, ctype := range formatTypes {
+		var schema = map[string]interface{}{"type": "string"}
+		if format == FormatJSON {
+			schema = map[string]interface{}{"$ref": "#/components/schemas/Todos"}
+		}
+		content[ctype] = map[string]interface{}{"schema": schema}
+	}
+	return content
+}
+
+func formatNames() []string {
+	var names = make([]string, 0, len(formatTypes))
+	for format := range formatTypes {
+		names = append(names, format)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// jsonSchema returns the schema of the given type, named name,
+// referencing the components schemas of the nested types.
+func jsonSchema(name string, t reflect.Type) map[string]interface{} {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	for ref, st := range apiSchemas {
+		if st == t && ref != name {
+			return map[string]interface{}{"$ref": "#/components/schemas/" + ref}
+		}
+	}
+
+	switch {
+	case t == reflect.TypeOf(time.Time{}):
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case t.Kind() == reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case t.Kind() == reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case t.Kind() == reflect.Slice:
+		return map[string]interface{}{"type": "array", "items": jsonSchema("", t.Elem())}
+	case t.Kind() != reflect.Struct:
+		return map[string]interface{}{}
+	}
+
+	var properties = make(map[string]interface{})
+	for i := 0; i < t.NumField(); i++ {
+		var field = t.Field(i)
+		var tag = strings.Split(field.Tag.Get("json"), ",")[0]
+		if tag == "-" || len(field.PkgPath) != 0 {
+			continue
+		}
+		if len(tag) == 0 {
+			tag = field.Name
+		}
+
+		var schema = jsonSchema("", field.Type)
+		if enum, ok := apiEnums[name+"."+tag]; ok {
+			schema["enum"] = enum
+		}
+		properties[tag] = schema
+	}
+
+	return map[string]interface{}{"type": "object", "properties": properties}
+}
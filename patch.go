@@ -0,0 +1,163 @@
+package todo
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Patch content types.
+const (
+	MergePatchType = "application/merge-patch+json"
+	JSONPatchType  = "application/json-patch+json"
+)
+
+// maxPatchSize is the maximum size of a patch request body.
+const maxPatchSize = 1 << 20
+
+// TodoPatch defines a partial update of a todo.
+// Nil fields are left unchanged.
+type TodoPatch struct {
+	Title  *string `json:"title,omitempty"`
+	Status *string `json:"status,omitempty"`
+}
+
+// PatchOperation defines a JSON Patch operation, as of RFC 6902.
+type PatchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// Empty returns whether the patch changes nothing.
+func (p TodoPatch) Empty() bool {
+	return p.Title == nil && p.Status == nil
+}
+
+// Apply applies the patch to the given todo.
+func (p TodoPatch) Apply(t *Todo) {
+	if p.Title != nil {
+		t.Title = *p.Title
+	}
+	if p.Status != nil {
+		t.Status = *p.Status
+	}
+}
+
+// Validate checks the patched fields.
+func (p TodoPatch) Validate() error {
+	if p.Title != nil && len(strings.TrimSpace(*p.Title)) == 0 {
+		return fmt.Errorf("patch: empty title")
+	}
+	if p.Status != nil && *p.Status != "active" && *p.Status != "completed" {
+		return fmt.Errorf("patch: unknown status %q", *p.Status)
+	}
+	return nil
+}
+
+// readMergePatch decodes a JSON merge patch, as of RFC 7396,
+// of the todo with the given id. The id and created date are immutable,
+// and the title and status may not be removed.
+func readMergePatch(data []byte, id string) (TodoPatch, error) {
+	var patch TodoPatch
+
+	var fields map[string]json.RawMessage
+	var err = json.Unmarshal(data, &fields)
+	if err != nil {
+		return patch, fmt.Errorf("patch: %s", err)
+	}
+
+	for name, raw := range fields {
+		if bytes.Equal(raw, []byte("null")) {
+			return patch, fmt.Errorf("patch: %s may not be removed", name)
+		}
+
+		switch name {
+		case "id":
+			var value string
+			if json.Unmarshal(raw, &value) != nil || value != id {
+				return patch, fmt.Errorf("patch: mismatch ids")
+			}
+		case "title":
+			err = json.Unmarshal(raw, &patch.Title)
+		case "status":
+			err = json.Unmarshal(raw, &patch.Status)
+		default:
+			return patch, fmt.Errorf("patch: %s may not be changed", name)
+		}
+
+		if err != nil {
+			return patch, fmt.Errorf("patch: %s: %s", name, err)
+		}
+	}
+
+	return patch, patch.Validate()
+}
+
+// readJSONPatch decodes a JSON patch of the given todo. The "replace"
+// and "add" operations set the title or the status; the "test"
+// operation checks the value of a member of the todo, a Conflict if not.
+func readJSONPatch(data []byte, current func() (Todo, error)) (TodoPatch, error) {
+	var patch TodoPatch
+
+	var ops []PatchOperation
+	var err = json.Unmarshal(data, &ops)
+	if err != nil {
+		return patch, fmt.Errorf("patch: %s", err)
+	}
+
+	var todo *Todo
+
+	for i, op := range ops {
+		var value, ok = op.Value.(string)
+
+		switch op.Op {
+		case "replace", "add":
+			if !ok {
+				return patch, fmt.Errorf("patch: operation %d: value must be a string", i)
+			}
+			switch op.Path {
+			case "/title":
+				patch.Title = &value
+			case "/status":
+				patch.Status = &value
+			default:
+				return patch, fmt.Errorf("patch: operation %d: %s may not be changed", i, op.Path)
+			}
+
+		case "test":
+			if todo == nil {
+				var t, err = current()
+				if err != nil {
+					return patch, err
+				}
+				todo = &t
+			}
+
+			var actual = *todo
+			patch.Apply(&actual)
+
+			var member = map[string]string{
+				"/id":     actual.ID,
+				"/title":  actual.Title,
+				"/status": actual.Status,
+			}
+			var got, known = member[op.Path]
+			if !known {
+				return patch, fmt.Errorf("patch: operation %d: unknown path %s", i, op.Path)
+			}
+			if !ok {
+				return patch, fmt.Errorf("patch: operation %d: value must be a string", i)
+			}
+			if got != value {
+				return patch, Conflict{fmt.Errorf("patch: operation %d: test of %s failed", i, op.Path)}
+			}
+
+		default:
+			return patch, fmt.Errorf("patch: operation %d: unsupported op %q", i, op.Op)
+		}
+	}
+
+	return patch, patch.Validate()
+}
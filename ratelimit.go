@@ -0,0 +1,240 @@
+package todo
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const userKey contextKey = 200
+
+// WithUser returns a copy of the request authenticated as the given user.
+// Rate limits of authenticated requests are counted per user.
+func WithUser(r *http.Request, user string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), userKey, user))
+}
+
+// User returns the authenticated user of the request, if any.
+func User(r *http.Request) string {
+	var user, _ = r.Context().Value(userKey).(string)
+	return user
+}
+
+// RateLimit defines a number of requests allowed per period.
+// The requests may be sent in a single burst.
+type RateLimit struct {
+	Requests int
+	Period   time.Duration
+}
+
+// ParseRateLimit parses a "requests/period" rate limit, such as "100/1m".
+// An empty string defines no limit.
+func ParseRateLimit(s string) (RateLimit, error) {
+	var limit RateLimit
+	if len(s) == 0 {
+		return limit, nil
+	}
+
+	var parts = strings.SplitN(s, "/", 2)
+	if len(parts) != 2 {
+		return limit, fmt.Errorf("rate: %q is not requests/period", s)
+	}
+
+	var err error
+	limit.Requests, err = strconv.Atoi(parts[0])
+	if err != nil || limit.Requests <= 0 {
+		return limit, fmt.Errorf("rate: %q is not a positive number of requests", parts[0])
+	}
+
+	limit.Period, err = time.ParseDuration(parts[1])
+	if err != nil || limit.Period <= 0 {
+		return limit, fmt.Errorf("rate: %q is not a positive period", parts[1])
+	}
+
+	return limit, nil
+}
+
+func (l RateLimit) String() string {
+	if l.Requests == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d/%s", l.Requests, l.Period)
+}
+
+// RateLimiter limits the requests of each client with token buckets,
+// keyed by authenticated user or else by client IP.
+// Reads (GET, HEAD and OPTIONS) and writes have separate limits.
+type RateLimiter struct {
+	Read  RateLimit
+	Write RateLimit
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	swept   time.Time
+	now     func() time.Time
+}
+
+// NewRateLimiter returns a rate limiter of the given read and write limits.
+// A zero limit does not limit requests.
+func NewRateLimiter(read, write RateLimit) *RateLimiter {
+	return &RateLimiter{
+		Read:    read,
+		Write:   write,
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+}
+
+// bucket holds the tokens left at a given time.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Handler returns a handler limiting the requests of next.
+// Limited requests get a 429 response with a Retry-After header;
+// every limited route reports its quota in RateLimit-* headers.
+func (l *RateLimiter) Handler(next http.Handler) http.Handler {
+	var fn = func(w http.ResponseWriter, r *http.Request) {
+		var kind, limit = "read", l.Read
+		if !isRead(r.Method) {
+			kind, limit = "write", l.Write
+		}
+
+		if limit.Requests == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var key = RateKey(r)
+		var ok, remaining, reset, retry = l.take(kind+" "+key, limit)
+
+		var header = w.Header()
+		header.Set("RateLimit-Limit", strconv.Itoa(limit.Requests))
+		header.Set("RateLimit-Remaining", strconv.Itoa(remaining))
+		header.Set("RateLimit-Reset", strconv.Itoa(seconds(reset)))
+		header.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", limit.Requests, seconds(limit.Period)))
+
+		if !ok {
+			header.Set("Retry-After", strconv.Itoa(seconds(retry)))
+			Logger(r).Warn("rate: limited", "key", key, "kind", kind)
+			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+// Allow takes a token of a write, or a read, of the client of the given
+// key, for the mutations sent over the live channels. It returns whether
+// the request is allowed, or else a TooManyRequests error.
+func (l *RateLimiter) Allow(key string, write bool) error {
+	var kind, limit = "read", l.Read
+	if write {
+		kind, limit = "write", l.Write
+	}
+	if limit.Requests == 0 {
+		return nil
+	}
+
+	var ok, _, _, retry = l.take(kind+" "+key, limit)
+	if !ok {
+		return TooManyRequests{fmt.Errorf("rate: limited, retry in %ds", seconds(retry))}
+	}
+	return nil
+}
+
+// RateKey returns the key of the rate limits of the client of the
+// request: its authenticated user, or else its IP.
+func RateKey(r *http.Request) string {
+	if user := User(r); len(user) != 0 {
+		return "user:" + user
+	}
+	return "ip:" + clientIP(r)
+}
+
+// take takes a token from the bucket of the given key. It returns whether
+// the request is allowed, the tokens left, the delay until the bucket
+// is full, and the delay until the next token.
+func (l *RateLimiter) take(key string, limit RateLimit) (bool, int, time.Duration, time.Duration) {
+	var now = l.now()
+	var rate = float64(limit.Requests) / limit.Period.Seconds() // tokens per second
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+
+	var b, found = l.buckets[key]
+	if !found {
+		b = &bucket{tokens: float64(limit.Requests), last: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens = math.Min(float64(limit.Requests), b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+
+	var ok = b.tokens >= 1
+	if ok {
+		b.tokens--
+	}
+
+	var reset = duration((float64(limit.Requests) - b.tokens) / rate)
+	var retry time.Duration
+	if !ok {
+		retry = duration((1 - b.tokens) / rate)
+	}
+
+	return ok, int(b.tokens), reset, retry
+}
+
+// sweep removes the buckets left untouched for a while,
+// which are full again, once a minute.
+func (l *RateLimiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < time.Minute {
+		return
+	}
+	l.swept = now
+
+	var idle = l.Read.Period
+	if l.Write.Period > idle {
+		idle = l.Write.Period
+	}
+
+	for key, b := range l.buckets {
+		if now.Sub(b.last) > idle {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+func isRead(method string) bool {
+	return method == "GET" || method == "HEAD" || method == "OPTIONS"
+}
+
+// clientIP returns the IP of the remote address of the request.
+func clientIP(r *http.Request) string {
+	var host, _, err = net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func duration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// seconds rounds up the given delay to whole seconds.
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
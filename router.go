@@ -5,11 +5,15 @@ import "github.com/gorilla/mux"
 const (
 	RouteList   = "Todo.List"
 	RouteCreate = "Todo.Create"
+	RouteBatch  = "Todo.Batch"
+	RouteExport = "Todo.Export"
+	RouteImport = "Todo.Import"
 
 	// _/{id}
 	RouteFind   = "Todo.Find"
 	RouteUpdate = "Todo.Update"
 	RouteDelete = "Todo.Delete"
+	RoutePatch  = "Todo.Patch"
 
 	// _/{status}
 	RouteFilter = "Todo.Filter"
@@ -31,10 +35,14 @@ func NewRouterPrefix(prefix string) *mux.Router {
 
 	router.Methods("GET").Path(prefix).Name(RouteList)
 	router.Methods("POST").Path(prefix).Name(RouteCreate)
+	router.Methods("POST").Path(prefix + "/batch").Name(RouteBatch)
+	router.Methods("GET").Path(prefix + "/export").Name(RouteExport)
+	router.Methods("POST").Path(prefix + "/import").Name(RouteImport)
 
 	router.Methods("GET").Path(prefix + "/{id:[A-Za-z0-9-]+}").Name(RouteFind)
 	router.Methods("PUT").Path(prefix + "/{id:[A-Za-z0-9-]+}").Name(RouteUpdate)
 	router.Methods("DELETE").Path(prefix + "/{id:[A-Za-z0-9-]+}").Name(RouteDelete)
+	router.Methods("PATCH").Path(prefix + "/{id:[A-Za-z0-9-]+}").Name(RoutePatch)
 
 	router.Methods("GET").Path(prefix + "/status/{status:[a-z]+}").Name(RouteFilter)
 	router.Methods("DELETE").Path(prefix + "/status/{status:[a-z]+}").Name(RouteClear)
@@ -0,0 +1,244 @@
+package todo
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Server runs an http.Server until an interrupt or terminate signal,
+// then shuts it down gracefully.
+//
+// On shutdown, the server stops accepting connections and calls the
+// functions registered with RegisterOnShutdown, which live-update streams
+// use to close their hijacked connections. It then waits for the active
+// requests to complete, until ShutdownTimeout, and finally calls the
+// functions registered with AfterShutdown, in order, such as Store.Close.
+// A handler implementing io.Closer, such as the webhooks worker, is
+// closed first.
+//
+// The gRPC server of the handler, if any, is served on GRPCAddr and
+// shut down along with the http.Server. It is served over TLS as well,
+// with the same certificate, when configured.
+type Server struct {
+	*http.Server
+	ShutdownTimeout time.Duration
+	GRPC            *GRPCServer
+	GRPCAddr        string
+
+	certs  *certReloader
+	after  []func()
+	grpcLn net.Listener
+}
+
+// NewServer returns a new Server of the given handler with the given configuration.
+func NewServer(config Config, handler http.Handler) *Server {
+	var server = &Server{
+		Server: &http.Server{
+			Addr:              config.Addr,
+			Handler:           handler,
+			ReadTimeout:       config.ReadTimeout,
+			ReadHeaderTimeout: config.ReadTimeout,
+			WriteTimeout:      config.WriteTimeout,
+			IdleTimeout:       config.IdleTimeout,
+			MaxHeaderBytes:    1 << 20,
+		},
+		ShutdownTimeout: config.ShutdownTimeout,
+	}
+
+	if len(config.TLSCert) != 0 {
+		server.certs = &certReloader{certFile: config.TLSCert, keyFile: config.TLSKey}
+		server.TLSConfig = &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: server.certs.GetCertificate,
+		}
+	}
+
+	if h, ok := handler.(interface{ GRPCServer() *GRPCServer }); ok && h.GRPCServer() != nil {
+		server.GRPC = h.GRPCServer()
+		server.GRPCAddr = config.GRPCAddr
+	}
+
+	if closer, ok := handler.(io.Closer); ok {
+		server.AfterShutdown(func() { closer.Close() })
+	}
+
+	return server
+}
+
+// AfterShutdown registers a function to call once the server is shut down.
+func (s *Server) AfterShutdown(fn func()) {
+	s.after = append(s.after, fn)
+}
+
+// Run listens on the server address and serves requests until
+// an interrupt or terminate signal is received.
+func (s *Server) Run() error {
+	var signals = make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	var ln, err = net.Listen("tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+
+	if s.GRPC != nil {
+		s.grpcLn, err = net.Listen("tcp", s.GRPCAddr)
+		if err != nil {
+			ln.Close()
+			return err
+		}
+	}
+
+	return s.serve(ln, signals)
+}
+
+// serve serves requests on ln until a signal is received on stop.
+func (s *Server) serve(ln net.Listener, stop <-chan os.Signal) error {
+	defer s.afterShutdown()
+
+	if s.certs != nil {
+		var _, err = s.certs.GetCertificate(nil)
+		if err != nil {
+			ln.Close()
+			return err
+		}
+	}
+
+	var errc = make(chan error, 1)
+	go func() {
+		if s.certs != nil {
+			errc <- s.Server.ServeTLS(ln, "", "")
+		} else {
+			errc <- s.Server.Serve(ln)
+		}
+	}()
+
+	var grpcErrc = make(chan error, 1)
+	if s.grpcLn != nil {
+		slog.Info("server: serving grpc", "addr", s.grpcLn.Addr().String(), "tls", s.certs != nil)
+
+		var grpcLn = s.grpcLn
+		if s.certs != nil {
+			// the certificate of the http.Server, over HTTP/2 only
+			var config = s.TLSConfig.Clone()
+			config.NextProtos = []string{"h2"}
+			grpcLn = tls.NewListener(grpcLn, config)
+		}
+		go func() {
+			grpcErrc <- s.GRPC.Serve(grpcLn)
+		}()
+	}
+
+	select {
+	case err := <-errc:
+		if s.grpcLn != nil {
+			s.GRPC.Stop()
+		}
+		return err
+	case err := <-grpcErrc:
+		s.Server.Close()
+		<-errc
+		return err
+	case sig := <-stop:
+		slog.Info("server: shutting down", "signal", sig.String())
+	}
+
+	var ctx, cancel = context.WithTimeout(context.Background(), s.ShutdownTimeout)
+	defer cancel()
+
+	var err = s.Server.Shutdown(ctx)
+	if err != nil {
+		slog.Warn("server: shutdown, closing connections", "err", err)
+		s.Server.Close()
+	}
+
+	if s.grpcLn != nil {
+		var grpcErr = s.GRPC.Shutdown(ctx)
+		if grpcErr != nil {
+			slog.Warn("server: grpc shutdown, cancelling calls", "err", grpcErr)
+		}
+		<-grpcErrc
+	}
+
+	<-errc // http.ErrServerClosed
+	return err
+}
+
+func (s *Server) afterShutdown() {
+	for _, fn := range s.after {
+		fn()
+	}
+}
+
+// certReloader loads a certificate from disk,
+// and reloads it when its files are modified.
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+// GetCertificate implements tls.Config.GetCertificate.
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var modTime, err = c.lastModified()
+	if err != nil && c.cert != nil {
+		// keep serving the loaded certificate while files are being replaced
+		return c.cert, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if c.cert != nil && modTime.Equal(c.modTime) {
+		return c.cert, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		if c.cert != nil {
+			slog.Error("server: tls reload", "err", err)
+			return c.cert, nil
+		}
+		return nil, err
+	}
+
+	if c.cert != nil {
+		slog.Info("server: tls certificate reloaded", "file", c.certFile)
+	}
+
+	c.cert = &cert
+	c.modTime = modTime
+	return c.cert, nil
+}
+
+func (c *certReloader) lastModified() (time.Time, error) {
+	var modTime time.Time
+
+	for _, name := range []string{c.certFile, c.keyFile} {
+		var info, err = os.Stat(name)
+		if err != nil {
+			return modTime, err
+		}
+		if info.ModTime().After(modTime) {
+			modTime = info.ModTime()
+		}
+	}
+
+	return modTime, nil
+}
@@ -1,28 +1,245 @@
 package todo
 
 import (
+	"io"
+	"log/slog"
 	"net/http"
+	"time"
 
 	"github.com/justinas/alice"
 )
 
 // NewAppHandler creates a new http.ServeMux,
-// and registers the application handlers.
+// and registers the application handlers
+// with the default configuration.
 func NewAppHandler(store Store) http.Handler {
+	var handler, err = NewConfigHandler(store, DefaultConfig())
+	if err != nil {
+		panic(err)
+	}
+	return handler
+}
+
+// NewConfigHandler creates a new http.ServeMux,
+// and registers the application handlers
+// with the given configuration.
+func NewConfigHandler(store Store, config Config) (http.Handler, error) {
+	var assets, err = NewAssets(config)
+	if err != nil {
+		return nil, err
+	}
+
+	csrfKey, err := ParseCSRFKey(config.CSRFKey)
+	if err != nil {
+		return nil, err
+	}
+
+	allow, err := ParseNetworks(config.WebhookAllow)
+	if err != nil {
+		return nil, err
+	}
+
+	read, err := ParseRateLimit(config.RateRead)
+	if err != nil {
+		return nil, err
+	}
+	write, err := ParseRateLimit(config.RateWrite)
+	if err != nil {
+		return nil, err
+	}
+
+	var metrics = NewMetrics()
+	metrics.StorePool(store)
+
+	// diagnostics of the store, before decorated
+	var info = InfoPage(config.Driver, store, time.Now().UTC())
+
+	// reads cache, invalidated by the changes of other instances
+	// when the store notifies them
+	var cache *Cache
+	if config.CacheSize != 0 {
+		cache = NewCache(config.CacheSize, config.CacheTTL)
+		if watcher, ok := store.(TodoWatcher); ok {
+			cache.Watch(watcher)
+		}
+	}
+
+	// outgoing webhooks, when enabled and the store can queue their deliveries
+	var hooks *Webhooks
+	if ws, ok := store.(WebhookStore); ok && config.Webhooks {
+		hooks = NewWebhooks(ws)
+		hooks.Allow = allow
+		hooks.Start()
+		store = hooks.Store(store)
+	}
+
+	if cache != nil {
+		store = cache.Store(store)
+	}
+
+	store = metrics.Store(store)
+
+	// change log, for the clients syncing offline
+	var changes = NewChangeLog(DefaultChangeLogSize)
+	store = changes.Store(store)
+
+	// live channel, broadcasting the mutations of all interfaces
+	var hub = NewHub()
+	store = hub.Store(store)
+
 	var router = http.NewServeMux()
-	var chain = alice.New(LoggingHandler, RecoverHandler)
+	var requestID = RequestIDHandler(slog.Default())
+	var chain = alice.New(requestID, NewLoggingHandler(config.LogFormat), RecoverHandler)
+	var probes = alice.New(requestID, RecoverHandler)
+
+	// users of the bearer tokens, keying their rate limits
+	users, err := ParseUsers(config.Tokens)
+	if err != nil {
+		return nil, err
+	}
+	if len(users) != 0 {
+		chain = chain.Append(users.Handler)
+	}
+
+	// rate limits of every interface, but the preflight requests of the api
+	var limiter *RateLimiter
+	if read.Requests != 0 || write.Requests != 0 {
+		limiter = NewRateLimiter(read, write)
+		hub.Limiter = limiter
+	}
+
+	// limited appends the limiter to the given chain, if any
+	var limited = func(handlers alice.Chain) alice.Chain {
+		if limiter != nil {
+			return handlers.Append(limiter.Handler)
+		}
+		return handlers
+	}
 
 	// todos api
-	var todoRouter = NewRouter()
-	var todoContext = NewContext(store)
-	todoContext.Register(todoRouter)
+	var cors = NewCORS(config)
+
+	// creations retried by the clients, with the same idempotency key
+	var idempotency = NewIdempotency(DefaultIdempotencyTTL)
+
+	// api returns the handler of the given api version at prefix
+	var api = func(prefix, version string) http.Handler {
+		var todoRouter = NewRouterPrefix(prefix)
+		NewContextVersion(store, version).Register(todoRouter)
 
-	router.Handle("/api/", chain.Then(todoRouter))
+		var handlers = chain.Append(metrics.Instrument(todoRouter, "api"), TraceHandler(todoRouter, "api"))
+
+		// preflight requests are not rate limited
+		if cors != nil {
+			handlers = handlers.Append(cors.Handler(todoRouter))
+		}
+		handlers = limited(handlers).Append(idempotency.Handler)
+
+		return handlers.Then(todoRouter)
+	}
+
+	router.Handle("/api/", VersionHandler(map[string]http.Handler{
+		APIv1: api("/api/todos", APIv1),
+		APIv2: api("/api/todos", APIv2),
+	}))
+	router.Handle("/api/v1/", api("/api/v1/todos", APIv1))
+	router.Handle("/api/v2/", api("/api/v2/todos", APIv2))
+
+	router.Handle("/api/live", limited(chain.Append(TraceHandler(nil, "live"))).Then(hub))
+
+	// graphql, with the subscriptions fed by the live channel,
+	// the operations sent with POST are limited as writes
+	var graphqlRouter = NewGraphQLRouter()
+	var gql = NewGraphQL(store, hub)
+	gql.Limiter = limiter
+	gql.Register(graphqlRouter)
+	router.Handle(GraphQLPath, limited(chain.Append(metrics.Instrument(graphqlRouter, "graphql"),
+		TraceHandler(graphqlRouter, "graphql"))).Then(graphqlRouter))
+
+	var changeRouter = NewChangeRouter()
+	changes.Register(changeRouter)
+	router.Handle(ChangesPath, chain.Append(metrics.Instrument(changeRouter, "changes"),
+		TraceHandler(changeRouter, "changes")).Then(changeRouter))
+
+	// grpc, served next to the handler by the Server
+	var app = appHandler{Handler: router}
+	if len(config.GRPCAddr) != 0 {
+		app.grpc = NewGRPCServer(NewTodoService(store, hub), GRPCLimits(users, limiter)...)
+	}
+
+	var specs = []struct{ path, prefix, version string }{
+		{"/api/openapi.json", "/api/todos", APIv1},
+		{"/api/v1/openapi.json", "/api/v1/todos", APIv1},
+		{"/api/v2/openapi.json", "/api/v2/todos", APIv2},
+	}
+	for _, spec := range specs {
+		openapi, err := OpenAPIPage(NewRouterPrefix(spec.prefix), spec.version)
+		if err != nil {
+			return nil, err
+		}
+		router.Handle(spec.path, chain.Then(openapi))
+	}
+	router.Handle("/metrics", probes.Then(metrics))
+
+	// health and diagnostics
+	router.Handle("/healthz", probes.ThenFunc(HealthPage))
+	router.Handle("/readyz", probes.Then(ReadyPage(store, ReadyTimeout)))
+	router.Handle("/debug/info", chain.Then(info))
+
+	// html interface
+	csrf, err := CSRFHandler(csrfKey, len(config.TLSCert) != 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var uiRouter = NewUIRouter()
+	NewUI(store, assets).Register(uiRouter)
+	var ui = limited(chain.Append(metrics.Instrument(uiRouter, "ui"), TraceHandler(uiRouter, "ui"))).
+		Append(csrf).Then(uiRouter)
+	router.Handle(UIPrefix, ui)
+	router.Handle(UIPrefix+"/", ui)
+
+	if hooks != nil {
+		var hookRouter = NewWebhookRouter()
+		hooks.Register(hookRouter)
+		var webhooks = limited(chain.Append(metrics.Instrument(hookRouter, "webhooks"),
+			TraceHandler(hookRouter, "webhooks"))).Then(hookRouter)
+		router.Handle("/api/webhooks", webhooks)
+		router.Handle("/api/webhooks/", webhooks)
+	}
 
 	// static pages
-	router.Handle("/index.html", chain.Then(HomePage(store)))
+	router.Handle("/index.html", chain.Append(metrics.Instrument(nil, "index"),
+		TraceHandler(nil, "index")).Then(HomePage(store, assets)))
 	router.Handle("/about", chain.ThenFunc(AboutPage))
-	router.Handle("/", chain.ThenFunc(StaticPages))
+	router.Handle("/", chain.Then(assets))
+
+	if hooks != nil {
+		app.closers = append(app.closers, hooks)
+	}
+	if cache != nil {
+		app.closers = append(app.closers, cache)
+	}
+	return app, nil
+}
+
+// appHandler is an application handler with background workers,
+// closed once the server is shut down, and an optional gRPC server.
+type appHandler struct {
+	http.Handler
+	closers []io.Closer
+	grpc    *GRPCServer
+}
+
+// Close closes the background workers.
+func (h appHandler) Close() error {
+	for _, c := range h.closers {
+		c.Close()
+	}
+	return nil
+}
 
-	return router
+// GRPCServer returns the gRPC server to serve next to the handler, if any.
+func (h appHandler) GRPCServer() *GRPCServer {
+	return h.grpc
 }
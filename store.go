@@ -1,8 +1,8 @@
 package todo
 
 import (
-	"os"
-	"strings"
+	"context"
+	"fmt"
 )
 
 // Store manages todos storage.
@@ -11,47 +11,74 @@ type Store interface {
 	Find(id string) (Todo, error)
 	Save(t *Todo) error
 	Delete(id string) error
+	Patch(id string, patch TodoPatch) (Todo, error)
 	// status
 	Filter(status string) Todos
 	Clear(status string) (int64, error)
 	Toggle(status string) (int64, error)
+	// batch
+	Batch(ops []Operation) (Results, error)
+	Import(todos Todos) (int64, error)
 	// store
+	WithContext(ctx context.Context) Store
+	Ping(ctx context.Context) error
 	Close()
 	CreateTable()
 }
 
-// NewStore returns a new Store.
-// NewStore reads DATABASE_URL environment variable.
-func NewStore() Store {
-	// var driver = "sqlite3"
-	var driver = "rethink"
+// IDChecker is a Store restricting the ids of the imported todos,
+// as the sql store only imports integer ids.
+type IDChecker interface {
+	CheckID(id string) error
+}
 
-	var url = os.Getenv("DATABASE_URL")
-	url = strings.TrimSpace(url)
+// SchemaVersioner is a Store reporting the version of the layout
+// of its tables, as migrated.
+type SchemaVersioner interface {
+	SchemaVersion() (int, error)
+}
 
-	if len(url) == 0 {
-		// url = ":memory:"
-		url = "localhost:28015/test"
+// NewStore returns a new Store.
+// NewStore reads TODO_DRIVER and DATABASE_URL environment variables,
+// and panics if the environment is invalid.
+func NewStore() Store {
+	var driver, url, err = DefaultStore()
+	if err != nil {
+		panic(err)
 	}
-
 	return OpenStore(driver, url)
 }
 
-// OpenStore returns a new Store.
+// DefaultStore returns the driver and url of the default Store.
+// DefaultStore reads TODO_DRIVER and DATABASE_URL environment variables,
+// and returns the error of any invalid configuration environment variable.
+func DefaultStore() (string, string, error) {
+	var config = DefaultConfig()
+	config.URL = ""
+	var err = config.LoadEnv()
+	config.defaultURL()
+
+	return config.Driver, config.URL, err
+}
+
+// OpenStore returns a new Store of the rethink or sqlite3 driver,
+// of the default url of the driver if the url is empty.
 func OpenStore(driver, url string) Store {
 	if len(driver) == 0 {
 		panic("store: driver is empty")
 	}
+	if len(url) == 0 {
+		url = defaultURLs[driver]
+	}
 	if len(url) == 0 {
 		panic("store: url is empty")
 	}
 
-	var store Store
-	if driver == "rethink" {
-		store = NewRethinkStore(url)
-	} else {
-		store = NewSqlStore(driver, url)
+	switch driver {
+	case "rethink":
+		return NewRethinkStore(url)
+	case "sqlite3":
+		return NewSqlStore(driver, url)
 	}
-
-	return store
+	panic(fmt.Sprintf("store: unknown driver %q", driver))
 }
@@ -1,8 +1,11 @@
 package todo
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"log/slog"
 	"strings"
 	"time"
 
@@ -12,6 +15,7 @@ import (
 type rethinkStore struct {
 	session *r.Session
 	url     string
+	ctx     context.Context
 }
 
 // NewRethinkStore connects to the database specified by url
@@ -36,9 +40,49 @@ func NewRethinkStore(url string) Store {
 	return rethinkStore{
 		session: session,
 		url:     url,
+		ctx:     context.Background(),
 	}
 }
 
+// WithContext returns a copy of the rethink store logging with the logger
+// of the given context, and tracing its queries within its span.
+func (s rethinkStore) WithContext(ctx context.Context) Store {
+	s.ctx = ctx
+	return s
+}
+
+func (s rethinkStore) logger() *slog.Logger {
+	return contextLogger(s.ctx)
+}
+
+// run runs the given query within a span of the given operation.
+func (s rethinkStore) run(op string, term r.Term) (*r.Cursor, error) {
+	var span = s.span(op, term)
+	var cur, err = term.Run(s.session)
+	span.Finish(err)
+	return cur, err
+}
+
+// runWrite runs the given write query within a span of the given operation.
+func (s rethinkStore) runWrite(op string, term r.Term) (r.WriteResponse, error) {
+	var span = s.span(op, term)
+	var res, err = term.RunWrite(s.session)
+	if err == nil && res.Errors != 0 {
+		span.Finish(errors.New(res.FirstError))
+	} else {
+		span.Finish(err)
+	}
+	return res, err
+}
+
+func (s rethinkStore) span(op string, term r.Term) *Span {
+	var _, span = StartSpan(s.ctx, "store."+op, SpanClient)
+	span.SetAttribute("db.system", "rethinkdb")
+	span.SetAttribute("db.operation", op)
+	span.SetAttribute("db.statement", term.String())
+	return span
+}
+
 // Close closes connection to the rethinkdb server.
 func (s rethinkStore) Close() {
 	// log.Printf("rethink: Closing connection to %s\n", s.url)
@@ -46,7 +90,23 @@ func (s rethinkStore) Close() {
 	s.session.Close()
 }
 
-// CreateTable drop and create the todo table.
+// Ping checks the connection to the rethinkdb server and the Todo table.
+// The driver does not cancel a query once run: the context is only
+// checked before, the timeouts of the session apply.
+func (s rethinkStore) Ping(ctx context.Context) error {
+	var err = ctx.Err()
+	if err != nil {
+		return err
+	}
+
+	cur, err := r.Table("Todo").Limit(1).Run(s.session)
+	if err != nil {
+		return err
+	}
+	return cur.Close()
+}
+
+// CreateTable drop and create the todo and webhook tables.
 func (s rethinkStore) CreateTable() {
 	var err = r.Db("test").Table("Todo").IndexDrop("Status").Exec(s.session)
 	if err != nil {
@@ -67,13 +127,15 @@ func (s rethinkStore) CreateTable() {
 	if err != nil {
 		log.Fatal(err)
 	}
+
+	s.createWebhookTables()
 }
 
 // Find returns the todo with the given id.
 func (s rethinkStore) Find(id string) (Todo, error) {
 	var t Todo
 
-	var cur, err = r.Table("Todo").Get(id).Run(s.session)
+	var cur, err = s.run("Find", r.Table("Todo").Get(id))
 	if err != nil {
 		return t, err
 	}
@@ -90,15 +152,15 @@ func (s rethinkStore) Find(id string) (Todo, error) {
 func (s rethinkStore) List() Todos {
 	var todos = make(Todos, 0)
 
-	var cur, err = r.Table("Todo").OrderBy(r.Desc("Created")).Run(s.session)
+	var cur, err = s.run("List", r.Table("Todo").OrderBy(r.Desc("Created")))
 	if err != nil {
-		log.Printf("rethink: list - %s\n", err)
+		s.logger().Error("rethink: list", "err", err)
 		return todos
 	}
 
 	err = cur.All(&todos)
 	if err != nil {
-		log.Printf("rethink: list - %s\n", err)
+		s.logger().Error("rethink: list", "err", err)
 	}
 	return todos
 }
@@ -107,18 +169,18 @@ func (s rethinkStore) List() Todos {
 func (s rethinkStore) Filter(status string) Todos {
 	var todos = make(Todos, 0)
 
-	var cur, err = r.Table("Todo").
+	var cur, err = s.run("Filter", r.Table("Todo").
 		GetAllByIndex("Status", status).
-		OrderBy(r.Desc("Created")).Run(s.session)
+		OrderBy(r.Desc("Created")))
 
 	if err != nil {
-		log.Printf("rethink: filter - %s\n", err)
+		s.logger().Error("rethink: filter", "err", err, "status", status)
 		return todos
 	}
 
 	err = cur.All(&todos)
 	if err != nil {
-		log.Printf("rethink: filter - %s\n", err)
+		s.logger().Error("rethink: filter", "err", err, "status", status)
 	}
 	return todos
 }
@@ -141,18 +203,18 @@ func (s rethinkStore) Save(t *Todo) error {
 func (s rethinkStore) Insert(t *Todo) error {
 	t.Created = time.Now().UTC()
 
-	var res, err = r.Table("Todo").Insert(t).RunWrite(s.session)
+	var res, err = s.runWrite("Insert", r.Table("Todo").Insert(t))
 	if err != nil {
-		log.Printf("rethink: insert - %s\n%s\n", err, t)
+		s.logger().Error("rethink: insert", "err", err, "todo", t)
 		return err
 	}
 
 	if res.Errors != 0 {
-		return fmt.Errorf(res.FirstError)
+		return errors.New(res.FirstError)
 	}
 
 	if len(res.GeneratedKeys) == 0 {
-		err = fmt.Errorf("GeneratedKeys == 0; %+s", res)
+		return fmt.Errorf("rethink: insert - no generated key; %+v", res)
 	}
 
 	t.ID = res.GeneratedKeys[0]
@@ -166,16 +228,16 @@ func (s rethinkStore) Update(t *Todo) error {
 		"Status": t.Status,
 	}
 
-	var res, err = r.Table("Todo").Get(t.ID).Update(cols).RunWrite(s.session)
+	var res, err = s.runWrite("Update", r.Table("Todo").Get(t.ID).Update(cols))
 	// log.Printf("%+v", res)
 
 	if err != nil {
-		log.Printf("rethink: update - %s\n%s\n", err)
+		s.logger().Error("rethink: update", "err", err, "todo", t)
 		return err
 	}
 
 	if res.Errors != 0 {
-		err = fmt.Errorf(res.FirstError)
+		err = errors.New(res.FirstError)
 	} else if res.Replaced == 0 && res.Unchanged == 0 {
 		err = NotFound{r.ErrEmptyResult}
 	}
@@ -185,15 +247,15 @@ func (s rethinkStore) Update(t *Todo) error {
 
 // Delete deletes the todo with the given id.
 func (s rethinkStore) Delete(id string) error {
-	var res, err = r.Table("Todo").Get(id).Delete().RunWrite(s.session)
+	var res, err = s.runWrite("Delete", r.Table("Todo").Get(id).Delete())
 
 	if err != nil {
-		log.Printf("rethink: delete - %s\n", err)
+		s.logger().Error("rethink: delete", "err", err, "id", id)
 		return err
 	}
 
 	if res.Errors != 0 {
-		return fmt.Errorf(res.FirstError)
+		return errors.New(res.FirstError)
 	}
 
 	if res.Deleted == 0 {
@@ -203,19 +265,194 @@ func (s rethinkStore) Delete(id string) error {
 	return err
 }
 
+// Patch updates the given fields of the todo with the given id,
+// and returns the updated todo.
+func (s rethinkStore) Patch(id string, patch TodoPatch) (Todo, error) {
+	var cols = make(map[string]interface{})
+	if patch.Title != nil {
+		cols["Title"] = *patch.Title
+	}
+	if patch.Status != nil {
+		cols["Status"] = *patch.Status
+	}
+
+	if len(cols) != 0 {
+		var res, err = s.runWrite("Patch", r.Table("Todo").Get(id).Update(cols))
+		if err != nil {
+			s.logger().Error("rethink: patch", "err", err, "id", id)
+			return Todo{}, err
+		}
+
+		if res.Errors != 0 {
+			return Todo{}, errors.New(res.FirstError)
+		} else if res.Replaced == 0 && res.Unchanged == 0 {
+			return Todo{}, NotFound{r.ErrEmptyResult}
+		}
+	}
+
+	return s.Find(id)
+}
+
+// Batch runs the given operations in a single write query.
+// RethinkDB has no transactions: the operations are checked first,
+// and nothing is written if any of them would fail.
+func (s rethinkStore) Batch(ops []Operation) (Results, error) {
+	var err = validateBatch(ops)
+	if err != nil {
+		return nil, err
+	}
+
+	stored, err := s.exists(ops)
+	if err != nil {
+		return nil, err
+	}
+
+	var found = make(map[string]bool, len(stored))
+	for id := range stored {
+		found[id] = true
+	}
+
+	var results = make(Results, len(ops))
+	var docs = make([]map[string]interface{}, len(ops))
+
+	for i, op := range ops {
+		var t = op.Todo
+
+		switch op.Op {
+		case OpCreate:
+			if len(t.Status) == 0 {
+				t.Status = "active"
+			}
+			t.Created = time.Now().UTC()
+			t.ID, err = newUUID()
+			found[t.ID], stored[t.ID] = true, t
+		case OpUpdate:
+			if len(t.Status) == 0 {
+				t.Status = "active"
+			}
+			if !found[t.ID] {
+				err = NotFound{r.ErrEmptyResult}
+			}
+			t.Created = stored[t.ID].Created
+		case OpDelete:
+			if !found[t.ID] {
+				err = NotFound{r.ErrEmptyResult}
+			}
+			found[t.ID] = false
+		}
+
+		results[i] = newResult(op, &t, err)
+		if err != nil {
+			rollbackResults(results)
+			return results, err
+		}
+
+		docs[i] = map[string]interface{}{"op": op.Op, "todo": t}
+	}
+
+	res, err := s.runWrite("Batch", r.Expr(docs).ForEach(func(doc r.Term) interface{} {
+		var t = doc.Field("todo")
+		var cols = map[string]interface{}{
+			"Title":  t.Field("Title"),
+			"Status": t.Field("Status"),
+		}
+
+		return r.Branch(
+			doc.Field("op").Eq(OpCreate), r.Table("Todo").Insert(t),
+			doc.Field("op").Eq(OpUpdate), r.Table("Todo").Get(t.Field("id")).Update(cols),
+			r.Table("Todo").Get(t.Field("id")).Delete())
+	}))
+
+	if err != nil {
+		s.logger().Error("rethink: batch", "err", err)
+		return nil, err
+	}
+
+	if res.Errors != 0 {
+		return nil, errors.New(res.FirstError)
+	}
+
+	return results, nil
+}
+
+// Import saves the given todos in a single write query,
+// keeping their ids and creation dates. Existing todos are replaced.
+func (s rethinkStore) Import(todos Todos) (int64, error) {
+	for i := range todos {
+		if todos[i].Created.IsZero() {
+			todos[i].Created = time.Now().UTC()
+		}
+	}
+
+	var res, err = s.runWrite("Import", r.Table("Todo").
+		Insert(todos, r.InsertOpts{Conflict: "replace"}))
+
+	if err != nil {
+		s.logger().Error("rethink: import", "err", err)
+		return 0, err
+	}
+
+	if res.Errors != 0 {
+		return 0, errors.New(res.FirstError)
+	}
+
+	var keys = res.GeneratedKeys
+	for i := range todos {
+		if len(todos[i].ID) == 0 && len(keys) != 0 {
+			todos[i].ID, keys = keys[0], keys[1:]
+		}
+	}
+
+	return int64(res.Inserted + res.Replaced + res.Unchanged), nil
+}
+
+// exists returns the existing todos updated or deleted by the given operations,
+// by id.
+func (s rethinkStore) exists(ops []Operation) (map[string]Todo, error) {
+	var found = make(map[string]Todo)
+
+	var ids []interface{}
+	for _, op := range ops {
+		if op.Op != OpCreate {
+			ids = append(ids, op.Todo.ID)
+		}
+	}
+
+	if len(ids) == 0 {
+		return found, nil
+	}
+
+	var cur, err = s.run("Exists", r.Table("Todo").GetAll(ids...))
+	if err != nil {
+		s.logger().Error("rethink: exists", "err", err)
+		return nil, err
+	}
+
+	var todos Todos
+	err = cur.All(&todos)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, todo := range todos {
+		found[todo.ID] = todo
+	}
+	return found, nil
+}
+
 // Clear deletes the todos with the specified status.
 func (s rethinkStore) Clear(status string) (int64, error) {
-	var res, err = r.Table("Todo").
+	var res, err = s.runWrite("Clear", r.Table("Todo").
 		GetAllByIndex("Status", status).
-		Delete().RunWrite(s.session)
+		Delete())
 
 	if err != nil {
-		log.Printf("rethink: clear - %s\n", err)
+		s.logger().Error("rethink: clear", "err", err, "status", status)
 		return 0, err
 	}
 
 	if res.Errors != 0 {
-		return 0, fmt.Errorf(res.FirstError)
+		return 0, errors.New(res.FirstError)
 	}
 
 	return int64(res.Deleted), nil
@@ -227,19 +464,53 @@ func (s rethinkStore) Toggle(status string) (int64, error) {
 		"Status": status,
 	}
 
-	var res, err = r.Table("Todo").
+	var res, err = s.runWrite("Toggle", r.Table("Todo").
 		Filter(r.Row.Field("Status").Ne(status)).
-		Update(cols).RunWrite(s.session)
+		Update(cols))
 	// log.Printf("%+v", res)
 
 	if err != nil {
-		log.Printf("rethink: toggle - %s\n%s\n", err)
+		s.logger().Error("rethink: toggle", "err", err, "status", status)
 		return 0, err
 	}
 
 	if res.Errors != 0 {
-		err = fmt.Errorf(res.FirstError)
+		err = errors.New(res.FirstError)
 	}
 
 	return int64(res.Replaced), err
 }
+
+// WatchTodos calls fn with the id of each todo changed by any instance,
+// as notified by the changefeed of the Todo table.
+func (s rethinkStore) WatchTodos(ctx context.Context, fn func(id string)) error {
+	var cur, err = r.Table("Todo").Changes().Run(s.session)
+	if err != nil {
+		return err
+	}
+
+	// the cursor blocks until the next change, closing it stops the watch
+	var stop = context.AfterFunc(ctx, func() { cur.Close() })
+	defer stop()
+
+	var change struct {
+		OldVal *Todo `gorethink:"old_val"`
+		NewVal *Todo `gorethink:"new_val"`
+	}
+	for cur.Next(&change) {
+		if change.NewVal != nil {
+			fn(change.NewVal.ID)
+		} else if change.OldVal != nil {
+			fn(change.OldVal.ID)
+		}
+		change.OldVal, change.NewVal = nil, nil
+	}
+
+	if err = cur.Err(); err != nil {
+		return err
+	}
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+	return fmt.Errorf("rethink: changefeed closed")
+}
@@ -0,0 +1,222 @@
+package todo
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	r "github.com/dancannon/gorethink"
+)
+
+// createWebhookTables drop and create the webhook and delivery tables.
+func (s rethinkStore) createWebhookTables() {
+	for _, table := range []string{"Webhook", "Delivery"} {
+		var err = r.Db("test").TableDrop(table).Exec(s.session)
+		if err != nil {
+			log.Println(err)
+		}
+
+		err = r.Db("test").TableCreate(table).Exec(s.session)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	var err = r.Db("test").Table("Delivery").IndexCreate("Status").Exec(s.session)
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = r.Db("test").Table("Delivery").IndexCreate("Webhook").Exec(s.session)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// ListWebhooks returns a list of all webhooks.
+func (s rethinkStore) ListWebhooks() ([]Webhook, error) {
+	var hooks = make([]Webhook, 0)
+
+	var cur, err = s.run("ListWebhooks", r.Table("Webhook").OrderBy("Created"))
+	if err != nil {
+		s.logger().Error("rethink: list webhooks", "err", err)
+		return nil, err
+	}
+
+	err = cur.All(&hooks)
+	if err != nil {
+		s.logger().Error("rethink: list webhooks", "err", err)
+	}
+	return hooks, err
+}
+
+// FindWebhook returns the webhook with the given id.
+func (s rethinkStore) FindWebhook(id string) (Webhook, error) {
+	var w Webhook
+
+	var cur, err = s.run("FindWebhook", r.Table("Webhook").Get(id))
+	if err != nil {
+		return w, err
+	}
+
+	err = cur.One(&w)
+	if err == r.ErrEmptyResult {
+		err = NotFound{r.ErrEmptyResult}
+	}
+	return w, err
+}
+
+// SaveWebhook inserts the given webhook, or updates it if it has an id.
+func (s rethinkStore) SaveWebhook(w *Webhook) error {
+	if len(w.ID) == 0 {
+		var id, err = s.insert("InsertWebhook", "Webhook", w)
+		w.ID = id
+		return err
+	}
+
+	var cols = map[string]interface{}{
+		"URL":      w.URL,
+		"Secret":   w.Secret,
+		"Events":   w.Events,
+		"Disabled": w.Disabled,
+	}
+
+	var res, err = s.runWrite("UpdateWebhook", r.Table("Webhook").Get(w.ID).Update(cols))
+	if err != nil {
+		s.logger().Error("rethink: update webhook", "err", err, "id", w.ID)
+		return err
+	}
+
+	if res.Errors != 0 {
+		err = errors.New(res.FirstError)
+	} else if res.Replaced == 0 && res.Unchanged == 0 {
+		err = NotFound{r.ErrEmptyResult}
+	}
+	return err
+}
+
+// DeleteWebhook deletes the webhook with the given id, and its deliveries.
+func (s rethinkStore) DeleteWebhook(id string) error {
+	var res, err = s.runWrite("DeleteWebhook", r.Table("Webhook").Get(id).Delete())
+	if err != nil {
+		s.logger().Error("rethink: delete webhook", "err", err, "id", id)
+		return err
+	}
+
+	if res.Errors != 0 {
+		return errors.New(res.FirstError)
+	}
+	if res.Deleted == 0 {
+		return NotFound{r.ErrEmptyResult}
+	}
+
+	_, err = s.runWrite("DeleteDeliveries", r.Table("Delivery").GetAllByIndex("Webhook", id).Delete())
+	if err != nil {
+		s.logger().Error("rethink: delete deliveries", "err", err, "id", id)
+	}
+	return err
+}
+
+// SaveDelivery inserts the given delivery, or updates it if it has an id.
+func (s rethinkStore) SaveDelivery(d *Delivery) error {
+	if len(d.ID) == 0 {
+		var id, err = s.insert("InsertDelivery", "Delivery", d)
+		d.ID = id
+		return err
+	}
+
+	var cols = map[string]interface{}{
+		"Status":   d.Status,
+		"Attempts": d.Attempts,
+		"Next":     d.Next,
+		"Code":     d.Code,
+		"Error":    d.Error,
+		"Updated":  d.Updated,
+	}
+
+	var res, err = s.runWrite("UpdateDelivery", r.Table("Delivery").Get(d.ID).Update(cols))
+	if err != nil {
+		s.logger().Error("rethink: update delivery", "err", err, "id", d.ID)
+		return err
+	}
+
+	if res.Errors != 0 {
+		err = errors.New(res.FirstError)
+	}
+	return err
+}
+
+// PendingDeliveries returns the pending deliveries, the next first.
+func (s rethinkStore) PendingDeliveries(limit int) ([]Delivery, error) {
+	var deliveries = make([]Delivery, 0)
+
+	var cur, err = s.run("PendingDeliveries", r.Table("Delivery").
+		GetAllByIndex("Status", DeliveryPending).
+		OrderBy("Next").
+		Limit(limit))
+	if err != nil {
+		s.logger().Error("rethink: pending deliveries", "err", err)
+		return deliveries, err
+	}
+
+	err = cur.All(&deliveries)
+	if err != nil {
+		s.logger().Error("rethink: pending deliveries", "err", err)
+	}
+	return deliveries, err
+}
+
+// ListDeliveries returns the deliveries of the given webhook,
+// the most recent first.
+func (s rethinkStore) ListDeliveries(webhook string, limit int) ([]Delivery, error) {
+	var deliveries = make([]Delivery, 0)
+
+	var cur, err = s.run("ListDeliveries", r.Table("Delivery").
+		GetAllByIndex("Webhook", webhook).
+		OrderBy(r.Desc("Created")).
+		Limit(limit))
+	if err != nil {
+		s.logger().Error("rethink: list deliveries", "err", err, "webhook", webhook)
+		return deliveries, err
+	}
+
+	err = cur.All(&deliveries)
+	if err != nil {
+		s.logger().Error("rethink: list deliveries", "err", err, "webhook", webhook)
+	}
+	return deliveries, err
+}
+
+// PruneDeliveries deletes the delivered and failed deliveries last updated
+// before the given time, and returns their count.
+func (s rethinkStore) PruneDeliveries(before time.Time) (int64, error) {
+	var res, err = s.runWrite("PruneDeliveries", r.Table("Delivery").
+		Filter(r.Row.Field("Status").Ne(DeliveryPending).And(r.Row.Field("Updated").Lt(before))).
+		Delete())
+	if err != nil {
+		s.logger().Error("rethink: prune deliveries", "err", err)
+		return 0, err
+	}
+
+	if res.Errors != 0 {
+		return 0, errors.New(res.FirstError)
+	}
+	return int64(res.Deleted), nil
+}
+
+// insert inserts the given document in the table, and returns its id.
+func (s rethinkStore) insert(op, table string, doc interface{}) (string, error) {
+	var res, err = s.runWrite(op, r.Table(table).Insert(doc))
+	if err != nil {
+		s.logger().Error("rethink: insert", "err", err, "table", table)
+		return "", err
+	}
+
+	if res.Errors != 0 {
+		return "", errors.New(res.FirstError)
+	}
+	if len(res.GeneratedKeys) == 0 {
+		return "", fmt.Errorf("rethink: insert - no generated key; %+v", res)
+	}
+	return res.GeneratedKeys[0], nil
+}
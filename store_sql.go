@@ -1,9 +1,13 @@
 package todo
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
 	"log"
+	"log/slog"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/jmoiron/sqlx"
@@ -13,10 +17,12 @@ import (
 type sqlStore struct {
 	db  *sqlx.DB
 	url string
+	ctx context.Context
 }
 
 // NewSqlStore connects to the database specified by driver and url
-// and returns a new Store.
+// and returns a new Store. Its queries, such as the upserts and the
+// user_version schema version, are of sqlite3, the only driver supported.
 func NewSqlStore(driver, url string) Store {
 	// ---------------------------------
 	// | driver  | url                 |
@@ -30,6 +36,7 @@ func NewSqlStore(driver, url string) Store {
 
 	var tx = db.MustBegin()
 	tx.MustExec(CreateTable)
+	tx.MustExec(setSchemaVersion)
 
 	var err = tx.Commit()
 	if err != nil {
@@ -39,21 +46,60 @@ func NewSqlStore(driver, url string) Store {
 	return sqlStore{
 		db:  db,
 		url: url,
+		ctx: context.Background(),
 	}
 }
 
+// WithContext returns a copy of the sql store logging with the logger
+// of the given context, and tracing its queries within its span.
+func (s sqlStore) WithContext(ctx context.Context) Store {
+	s.ctx = ctx
+	return s
+}
+
+func (s sqlStore) logger() *slog.Logger {
+	return contextLogger(s.ctx)
+}
+
+// span starts a span of the given operation running the given query.
+func (s sqlStore) span(op, query string) *Span {
+	var _, span = StartSpan(s.ctx, "store."+op, SpanClient)
+	span.SetAttribute("db.system", "sqlite")
+	span.SetAttribute("db.operation", op)
+	span.SetAttribute("db.statement", query)
+	return span
+}
+
 // Close closes connection to the sql store.
 func (s sqlStore) Close() {
 	// log.Printf("database: Closing connection to %s\n", s.url)
 	s.db.Close()
 }
 
-// CreateTable drop and create the todo table.
+// Ping checks the connection to the sql store and the todo table,
+// until the given context is done.
+func (s sqlStore) Ping(ctx context.Context) error {
+	var err = s.db.PingContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	var ids []int64
+	return s.db.SelectContext(ctx, &ids, `SELECT id FROM todo LIMIT 1`)
+}
+
+// Stats returns the connection pool statistics of the sql store.
+func (s sqlStore) Stats() sql.DBStats {
+	return s.db.Stats()
+}
+
+// CreateTable drop and create the todo and webhook tables.
 func (s sqlStore) CreateTable() {
 	var tx = s.db.MustBegin()
 
 	tx.MustExec(DropTable)
 	tx.MustExec(CreateTable)
+	tx.MustExec(setSchemaVersion)
 
 	var err = tx.Commit()
 	if err != nil {
@@ -61,6 +107,13 @@ func (s sqlStore) CreateTable() {
 	}
 }
 
+// SchemaVersion returns the version of the tables, as set when created.
+func (s sqlStore) SchemaVersion() (int, error) {
+	var version int
+	var err = s.db.GetContext(s.ctx, &version, "PRAGMA user_version")
+	return version, err
+}
+
 // Find returns the todo with the given id.
 func (s sqlStore) Find(id string) (Todo, error) {
 	var t Todo
@@ -70,12 +123,14 @@ func (s sqlStore) Find(id string) (Todo, error) {
         WHERE id = $1`
 	// println(query)
 
+	var span = s.span("Find", query)
 	var err = s.db.Get(&t, query, id)
+	span.Finish(storeError(err))
 
 	if err == sql.ErrNoRows {
 		err = NotFound{sql.ErrNoRows}
 	} else if err != nil {
-		log.Printf("store: find - %s\n", err)
+		s.logger().Error("store: find", "err", err, "id", id)
 	}
 
 	return t, err
@@ -90,10 +145,12 @@ func (s sqlStore) List() Todos {
         ORDER BY created DESC`
 	// println(query)
 
+	var span = s.span("List", query)
 	var err = s.db.Select(&todos, query)
+	span.Finish(err)
 
 	if err != nil {
-		log.Printf("store: list - %s\n", err)
+		s.logger().Error("store: list", "err", err)
 	}
 
 	return todos
@@ -109,10 +166,12 @@ func (s sqlStore) Filter(status string) Todos {
         ORDER BY created DESC`
 	// println(query, status)
 
+	var span = s.span("Filter", query)
 	var err = s.db.Select(&todos, query, status)
+	span.Finish(err)
 
 	if err != nil {
-		log.Printf("store: filter - %s\n", err)
+		s.logger().Error("store: filter", "err", err, "status", status)
 	}
 
 	return todos
@@ -134,44 +193,241 @@ func (s sqlStore) Save(t *Todo) error {
 
 // Insert saves the given todo.
 func (s sqlStore) Insert(t *Todo) error {
-	var query = `INSERT INTO todo (title, status, created)
-                VALUES ($1, $2, $3)`
+	var tx = s.db.MustBegin()
+	defer tx.Rollback()
+
+	var err = s.insertTodo(tx, t)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
 
+// Update saves the given todo.
+func (s sqlStore) Update(t *Todo) error {
 	var tx = s.db.MustBegin()
 	defer tx.Rollback()
 
-	var r, err = tx.Exec(query, t.Title, t.Status, t.Created)
+	var err = s.updateTodo(tx, t)
 	if err != nil {
-		log.Printf("store: insert - %s\n%s\n%s\n", err, query, t)
 		return err
 	}
 
-	err = tx.Commit()
+	return tx.Commit()
+}
+
+// Delete deletes the todo with the given id.
+func (s sqlStore) Delete(id string) error {
+	var tx = s.db.MustBegin()
+	defer tx.Rollback()
+
+	var err = s.deleteTodo(tx, id)
 	if err != nil {
 		return err
 	}
 
-	autoIncr, err := r.LastInsertId()
-	t.ID = strconv.FormatInt(autoIncr, 10)
-	return err
+	return tx.Commit()
 }
 
-// Update saves the given todo.
-func (s sqlStore) Update(t *Todo) error {
-	var query = `UPDATE todo SET title = $1, status = $2
-                WHERE id = $3`
+// Patch updates the given fields of the todo with the given id,
+// and returns the updated todo.
+func (s sqlStore) Patch(id string, patch TodoPatch) (Todo, error) {
+	var t Todo
 
 	var tx = s.db.MustBegin()
 	defer tx.Rollback()
 
-	var r, err = tx.Exec(query, t.Title, t.Status, t.ID)
+	var sets []string
+	var args []interface{}
+	if patch.Title != nil {
+		args = append(args, *patch.Title)
+		sets = append(sets, fmt.Sprintf("title = $%d", len(args)))
+	}
+	if patch.Status != nil {
+		args = append(args, *patch.Status)
+		sets = append(sets, fmt.Sprintf("status = $%d", len(args)))
+	}
+
+	if len(sets) != 0 {
+		args = append(args, id)
+		var query = fmt.Sprintf(`UPDATE todo SET %s
+                WHERE id = $%d`, strings.Join(sets, ", "), len(args))
+
+		var span = s.span("Patch", query)
+		var r, err = tx.Exec(query, args...)
+		span.Finish(err)
+		if err != nil {
+			s.logger().Error("store: patch", "err", err, "query", query, "id", id)
+			return t, err
+		}
+
+		count, err := r.RowsAffected()
+		if err == nil && count == 0 {
+			err = NotFound{sql.ErrNoRows}
+		}
+		if err != nil {
+			return t, err
+		}
+	}
+
+	var query = `SELECT id, title, status, created
+        FROM todo
+        WHERE id = $1`
+
+	var span = s.span("Find", query)
+	var err = tx.Get(&t, query, id)
+	span.Finish(storeError(err))
+
+	if err == sql.ErrNoRows {
+		return t, NotFound{sql.ErrNoRows}
+	} else if err != nil {
+		s.logger().Error("store: patch", "err", err, "query", query, "id", id)
+		return t, err
+	}
+
+	return t, tx.Commit()
+}
+
+// Batch runs the given operations in a single transaction.
+// The transaction is rolled back if any operation fails.
+func (s sqlStore) Batch(ops []Operation) (Results, error) {
+	var err = validateBatch(ops)
 	if err != nil {
-		log.Printf("store: update - %s\n%s\n%s\n", err, query, t)
-		return err
+		return nil, err
+	}
+
+	var ctx, span = StartSpan(s.ctx, "store.Batch", SpanInternal)
+	span.SetAttribute("db.operation", "Batch")
+	span.SetAttribute("batch.size", strconv.Itoa(len(ops)))
+	defer func() { span.Finish(err) }()
+	s.ctx = ctx
+
+	var tx = s.db.MustBegin()
+	defer tx.Rollback()
+
+	var results = make(Results, len(ops))
+
+	for i, op := range ops {
+		var t = op.Todo
+
+		switch op.Op {
+		case OpCreate:
+			if len(t.Status) == 0 {
+				t.Status = "active"
+			}
+			t.Created = time.Now().UTC()
+			err = s.insertTodo(tx, &t)
+		case OpUpdate:
+			if len(t.Status) == 0 {
+				t.Status = "active"
+			}
+			err = s.updateTodo(tx, &t)
+			if err == nil {
+				err = s.getTodo(tx, &t)
+			}
+		case OpDelete:
+			err = s.deleteTodo(tx, t.ID)
+		}
+
+		results[i] = newResult(op, &t, err)
+		if err != nil {
+			rollbackResults(results)
+			return results, err
+		}
 	}
 
 	err = tx.Commit()
 	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// Import saves the given todos in a single transaction,
+// keeping their ids and creation dates. Existing todos are replaced.
+func (s sqlStore) Import(todos Todos) (int64, error) {
+	var query = `INSERT OR REPLACE INTO todo (id, title, status, created)
+                VALUES ($1, $2, $3, $4)`
+
+	var tx = s.db.MustBegin()
+	defer tx.Rollback()
+
+	for i := range todos {
+		var t = &todos[i]
+
+		if t.Created.IsZero() {
+			t.Created = time.Now().UTC()
+		}
+
+		if len(t.ID) == 0 {
+			var err = s.insertTodo(tx, t)
+			if err != nil {
+				return 0, err
+			}
+			continue
+		}
+
+		var err = s.CheckID(t.ID)
+		if err != nil {
+			return 0, err
+		}
+
+		var span = s.span("Import", query)
+		_, err = tx.Exec(query, t.ID, t.Title, t.Status, t.Created)
+		span.Finish(err)
+		if err != nil {
+			s.logger().Error("store: import", "err", err, "query", query, "todo", t)
+			return 0, err
+		}
+	}
+
+	var err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	return int64(len(todos)), nil
+}
+
+// CheckID checks that an imported id is an integer, as the ids of the sql store.
+func (s sqlStore) CheckID(id string) error {
+	var _, err = strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return BadRequest{fmt.Errorf("store: import - id %q is not an integer", id)}
+	}
+	return nil
+}
+
+// insertTodo inserts the given todo within the given transaction.
+func (s sqlStore) insertTodo(tx *sqlx.Tx, t *Todo) error {
+	var query = `INSERT INTO todo (title, status, created)
+                VALUES ($1, $2, $3)`
+
+	var span = s.span("Insert", query)
+	var r, err = tx.Exec(query, t.Title, t.Status, t.Created)
+	span.Finish(err)
+	if err != nil {
+		s.logger().Error("store: insert", "err", err, "query", query, "todo", t)
+		return err
+	}
+
+	autoIncr, err := r.LastInsertId()
+	t.ID = strconv.FormatInt(autoIncr, 10)
+	return err
+}
+
+// updateTodo updates the given todo within the given transaction.
+func (s sqlStore) updateTodo(tx *sqlx.Tx, t *Todo) error {
+	var query = `UPDATE todo SET title = $1, status = $2
+                WHERE id = $3`
+
+	var span = s.span("Update", query)
+	var r, err = tx.Exec(query, t.Title, t.Status, t.ID)
+	span.Finish(err)
+	if err != nil {
+		s.logger().Error("store: update", "err", err, "query", query, "todo", t)
 		return err
 	}
 
@@ -182,22 +438,31 @@ func (s sqlStore) Update(t *Todo) error {
 	return err
 }
 
-// Delete deletes the todo with the given id.
-func (s sqlStore) Delete(id string) error {
-	var query = `DELETE FROM todo WHERE id = $1`
-	// println(query)
-
-	var tx = s.db.MustBegin()
-	defer tx.Rollback()
+// getTodo reads the stored todo of the given id within the given transaction.
+func (s sqlStore) getTodo(tx *sqlx.Tx, t *Todo) error {
+	var query = `SELECT id, title, status, created
+        FROM todo
+        WHERE id = $1`
 
-	var r, err = tx.Exec(query, id)
+	var span = s.span("Find", query)
+	var err = tx.Get(t, query, t.ID)
+	span.Finish(storeError(err))
 	if err != nil {
-		log.Printf("store: delete - %s\n%s\n", err, query)
-		return err
+		s.logger().Error("store: find", "err", err, "query", query, "id", t.ID)
 	}
+	return err
+}
 
-	err = tx.Commit()
+// deleteTodo deletes the todo with the given id within the given transaction.
+func (s sqlStore) deleteTodo(tx *sqlx.Tx, id string) error {
+	var query = `DELETE FROM todo WHERE id = $1`
+	// println(query)
+
+	var span = s.span("Delete", query)
+	var r, err = tx.Exec(query, id)
+	span.Finish(err)
 	if err != nil {
+		s.logger().Error("store: delete", "err", err, "query", query, "id", id)
 		return err
 	}
 
@@ -205,7 +470,6 @@ func (s sqlStore) Delete(id string) error {
 	if err == nil && count == 0 {
 		err = NotFound{sql.ErrNoRows}
 	}
-
 	return err
 }
 
@@ -217,9 +481,11 @@ func (s sqlStore) Clear(status string) (int64, error) {
 	var tx = s.db.MustBegin()
 	defer tx.Rollback()
 
+	var span = s.span("Clear", query)
 	var r, err = tx.Exec(query, status)
+	span.Finish(err)
 	if err != nil {
-		log.Printf("store: clear - %s\n%s\n", err, query)
+		s.logger().Error("store: clear", "err", err, "query", query, "status", status)
 		return 0, err
 	}
 
@@ -240,9 +506,11 @@ func (s sqlStore) Toggle(status string) (int64, error) {
 	var tx = s.db.MustBegin()
 	defer tx.Rollback()
 
+	var span = s.span("Toggle", query)
 	var r, err = tx.Exec(query, status)
+	span.Finish(err)
 	if err != nil {
-		log.Printf("store: toggle - %s\n%s\n", err, query)
+		s.logger().Error("store: toggle", "err", err, "query", query, "status", status)
 		return 0, err
 	}
 
@@ -258,8 +526,18 @@ func (s sqlStore) Toggle(status string) (int64, error) {
 const DropTable = `
 DROP INDEX IF EXISTS todoStatus;
 DROP TABLE IF EXISTS todo;
+DROP INDEX IF EXISTS deliveryPending;
+DROP INDEX IF EXISTS deliveryWebhook;
+DROP TABLE IF EXISTS delivery;
+DROP TABLE IF EXISTS webhook;
 `
 
+// SQLSchemaVersion is the version of the tables created by CreateTable,
+// to increase with each change of their layout.
+const SQLSchemaVersion = 1
+
+var setSchemaVersion = fmt.Sprintf("PRAGMA user_version = %d", SQLSchemaVersion)
+
 const CreateTable = `
 CREATE TABLE IF NOT EXISTS todo (
     id      INTEGER PRIMARY KEY AUTOINCREMENT,
@@ -269,4 +547,30 @@ CREATE TABLE IF NOT EXISTS todo (
 );
 
 CREATE INDEX IF NOT EXISTS todoStatus ON todo (status);
+
+CREATE TABLE IF NOT EXISTS webhook (
+    id       INTEGER PRIMARY KEY AUTOINCREMENT,
+    url      TEXT NOT NULL,
+    secret   TEXT NOT NULL,
+    events   TEXT NOT NULL,
+    disabled BOOLEAN NOT NULL,
+    created  DATETIME NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS delivery (
+    id       INTEGER PRIMARY KEY AUTOINCREMENT,
+    webhook  INTEGER NOT NULL,
+    event    TEXT NOT NULL,
+    payload  TEXT NOT NULL,
+    status   TEXT NOT NULL,
+    attempts INTEGER NOT NULL,
+    next     DATETIME NOT NULL,
+    code     INTEGER NOT NULL,
+    error    TEXT NOT NULL,
+    created  DATETIME NOT NULL,
+    updated  DATETIME NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS deliveryPending ON delivery (status, next);
+CREATE INDEX IF NOT EXISTS deliveryWebhook ON delivery (webhook, created);
 `
@@ -0,0 +1,238 @@
+package todo
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sqlWebhook is a webhook row, with comma separated events.
+type sqlWebhook struct {
+	ID       string
+	URL      string
+	Secret   string
+	Events   string
+	Disabled bool
+	Created  time.Time
+}
+
+func (w sqlWebhook) webhook() Webhook {
+	var events = make([]string, 0)
+	if len(w.Events) != 0 {
+		events = strings.Split(w.Events, ",")
+	}
+	return Webhook{
+		ID:       w.ID,
+		URL:      w.URL,
+		Secret:   w.Secret,
+		Events:   events,
+		Disabled: w.Disabled,
+		Created:  w.Created,
+	}
+}
+
+// ListWebhooks returns a list of all webhooks.
+func (s sqlStore) ListWebhooks() ([]Webhook, error) {
+	var rows []sqlWebhook
+
+	var query = `SELECT id, url, secret, events, disabled, created
+        FROM webhook
+        ORDER BY id`
+
+	var span = s.span("ListWebhooks", query)
+	var err = s.db.Select(&rows, query)
+	span.Finish(err)
+	if err != nil {
+		s.logger().Error("store: list webhooks", "err", err)
+		return nil, err
+	}
+
+	var hooks = make([]Webhook, len(rows))
+	for i, row := range rows {
+		hooks[i] = row.webhook()
+	}
+	return hooks, nil
+}
+
+// FindWebhook returns the webhook with the given id.
+func (s sqlStore) FindWebhook(id string) (Webhook, error) {
+	var row sqlWebhook
+
+	var query = `SELECT id, url, secret, events, disabled, created
+        FROM webhook
+        WHERE id = $1`
+
+	var span = s.span("FindWebhook", query)
+	var err = s.db.Get(&row, query, id)
+	span.Finish(storeError(err))
+
+	if err == sql.ErrNoRows {
+		err = NotFound{sql.ErrNoRows}
+	} else if err != nil {
+		s.logger().Error("store: find webhook", "err", err, "id", id)
+	}
+
+	return row.webhook(), err
+}
+
+// SaveWebhook inserts the given webhook, or updates it if it has an id.
+func (s sqlStore) SaveWebhook(w *Webhook) error {
+	var events = strings.Join(w.Events, ",")
+
+	if len(w.ID) == 0 {
+		var query = `INSERT INTO webhook (url, secret, events, disabled, created)
+                VALUES ($1, $2, $3, $4, $5)`
+
+		var span = s.span("InsertWebhook", query)
+		var r, err = s.db.Exec(query, w.URL, w.Secret, events, w.Disabled, w.Created)
+		span.Finish(err)
+		if err != nil {
+			s.logger().Error("store: insert webhook", "err", err, "query", query)
+			return err
+		}
+
+		autoIncr, err := r.LastInsertId()
+		w.ID = strconv.FormatInt(autoIncr, 10)
+		return err
+	}
+
+	var query = `UPDATE webhook SET url = $1, secret = $2, events = $3, disabled = $4
+                WHERE id = $5`
+
+	var span = s.span("UpdateWebhook", query)
+	var r, err = s.db.Exec(query, w.URL, w.Secret, events, w.Disabled, w.ID)
+	span.Finish(err)
+	if err != nil {
+		s.logger().Error("store: update webhook", "err", err, "query", query, "id", w.ID)
+		return err
+	}
+
+	count, err := r.RowsAffected()
+	if err == nil && count == 0 {
+		err = NotFound{sql.ErrNoRows}
+	}
+	return err
+}
+
+// DeleteWebhook deletes the webhook with the given id, and its deliveries.
+func (s sqlStore) DeleteWebhook(id string) error {
+	var tx = s.db.MustBegin()
+	defer tx.Rollback()
+
+	var query = `DELETE FROM webhook WHERE id = $1`
+
+	var span = s.span("DeleteWebhook", query)
+	var r, err = tx.Exec(query, id)
+	span.Finish(err)
+	if err != nil {
+		s.logger().Error("store: delete webhook", "err", err, "query", query, "id", id)
+		return err
+	}
+
+	count, err := r.RowsAffected()
+	if err == nil && count == 0 {
+		err = NotFound{sql.ErrNoRows}
+	}
+	if err != nil {
+		return err
+	}
+
+	query = `DELETE FROM delivery WHERE webhook = $1`
+
+	span = s.span("DeleteDeliveries", query)
+	_, err = tx.Exec(query, id)
+	span.Finish(err)
+	if err != nil {
+		s.logger().Error("store: delete deliveries", "err", err, "query", query, "id", id)
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// SaveDelivery inserts the given delivery, or updates it if it has an id.
+func (s sqlStore) SaveDelivery(d *Delivery) error {
+	if len(d.ID) == 0 {
+		var query = `INSERT INTO delivery (webhook, event, payload, status, attempts, next, code, error, created, updated)
+                VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`
+
+		var span = s.span("InsertDelivery", query)
+		var r, err = s.db.Exec(query, d.Webhook, d.Event, d.Payload, d.Status,
+			d.Attempts, d.Next, d.Code, d.Error, d.Created, d.Updated)
+		span.Finish(err)
+		if err != nil {
+			s.logger().Error("store: insert delivery", "err", err, "query", query)
+			return err
+		}
+
+		autoIncr, err := r.LastInsertId()
+		d.ID = strconv.FormatInt(autoIncr, 10)
+		return err
+	}
+
+	var query = `UPDATE delivery SET status = $1, attempts = $2, next = $3, code = $4, error = $5, updated = $6
+                WHERE id = $7`
+
+	var span = s.span("UpdateDelivery", query)
+	var _, err = s.db.Exec(query, d.Status, d.Attempts, d.Next, d.Code, d.Error, d.Updated, d.ID)
+	span.Finish(err)
+	if err != nil {
+		s.logger().Error("store: update delivery", "err", err, "query", query, "id", d.ID)
+	}
+	return err
+}
+
+// PendingDeliveries returns the pending deliveries, the next first.
+func (s sqlStore) PendingDeliveries(limit int) ([]Delivery, error) {
+	var deliveries = make([]Delivery, 0)
+
+	var query = `SELECT id, webhook, event, payload, status, attempts, next, code, error, created, updated
+        FROM delivery
+        WHERE status = $1
+        ORDER BY next, id
+        LIMIT $2`
+
+	var span = s.span("PendingDeliveries", query)
+	var err = s.db.Select(&deliveries, query, DeliveryPending, limit)
+	span.Finish(err)
+	if err != nil {
+		s.logger().Error("store: pending deliveries", "err", err)
+	}
+	return deliveries, err
+}
+
+// ListDeliveries returns the deliveries of the given webhook,
+// the most recent first.
+func (s sqlStore) ListDeliveries(webhook string, limit int) ([]Delivery, error) {
+	var deliveries = make([]Delivery, 0)
+
+	var query = `SELECT id, webhook, event, payload, status, attempts, next, code, error, created, updated
+        FROM delivery
+        WHERE webhook = $1
+        ORDER BY created DESC, id DESC
+        LIMIT $2`
+
+	var span = s.span("ListDeliveries", query)
+	var err = s.db.Select(&deliveries, query, webhook, limit)
+	span.Finish(err)
+	if err != nil {
+		s.logger().Error("store: list deliveries", "err", err, "webhook", webhook)
+	}
+	return deliveries, err
+}
+
+// PruneDeliveries deletes the delivered and failed deliveries last updated
+// before the given time, and returns their count.
+func (s sqlStore) PruneDeliveries(before time.Time) (int64, error) {
+	var query = `DELETE FROM delivery WHERE status != $1 AND updated < $2`
+
+	var span = s.span("PruneDeliveries", query)
+	var r, err = s.db.Exec(query, DeliveryPending, before)
+	span.Finish(err)
+	if err != nil {
+		s.logger().Error("store: prune deliveries", "err", err, "query", query)
+		return 0, err
+	}
+	return r.RowsAffected()
+}
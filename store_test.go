@@ -1,9 +1,13 @@
 package todo
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"math/rand"
+	"net/http"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 )
@@ -213,6 +217,343 @@ func TestStoreFilter(t *testing.T) {
 	})
 }
 
+func TestStorePatch(t *testing.T) {
+	withStoreContext(func(store Store) {
+		var todo = NewTodo("todo 1")
+		saveTodo(t, store, todo)
+
+		var status = "completed"
+		var patched, err = store.Patch(todo.ID, TodoPatch{Status: &status})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		todo.Complete()
+		if !patched.Equal(*todo) || !findTodo(t, store, todo.ID).Equal(*todo) {
+			t.Fatal("patch status error", patched)
+		}
+
+		var title = "todo 2"
+		patched, err = store.Patch(todo.ID, TodoPatch{Title: &title})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if patched.Title != title || patched.Status != status {
+			t.Fatal("patch title error", patched)
+		}
+
+		patched, err = store.Patch(todo.ID, TodoPatch{})
+		if err != nil || patched.Title != title {
+			t.Fatal("empty patch error", patched, err)
+		}
+
+		_, err = store.Patch("0", TodoPatch{Title: &title})
+		if _, ok := err.(NotFound); !ok {
+			t.Fatal("patch not found error", err)
+		}
+	})
+}
+
+func TestStoreBatch(t *testing.T) {
+	withStoreContext(func(store Store) {
+
+		// create
+		var todo1 = NewTodo("todo 1")
+		saveTodo(t, store, todo1)
+
+		var todo2 = NewTodo("todo 2")
+		saveTodo(t, store, todo2)
+
+		// batch
+		var completed = Todo{ID: todo1.ID, Title: todo1.Title}
+		completed.Complete()
+
+		var results, err = store.Batch([]Operation{
+			{Op: OpCreate, Todo: Todo{Title: "todo 3"}},
+			{Op: OpUpdate, Todo: completed},
+			{Op: OpDelete, Todo: *todo2},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(results) != 3 || results.Failed() != nil {
+			t.Fatal("batch results error", results)
+		}
+
+		if results[0].Todo == nil || len(results[0].Todo.ID) == 0 {
+			t.Fatal("batch create error", results[0])
+		}
+		if results[1].Todo == nil || !results[1].Todo.Created.Equal(findTodo(t, store, todo1.ID).Created) {
+			t.Fatal("batch update error", results[1])
+		}
+
+		// list
+		var todos = store.List()
+		if len(todos) != 2 {
+			t.Fatal("todos count error", todos)
+		}
+
+		var todo = findTodo(t, store, todo1.ID)
+		if !todo.Completed() {
+			t.Fatal("todo status error", todo.Status)
+		}
+
+		// batch rolled back
+		results, err = store.Batch([]Operation{
+			{Op: OpCreate, Todo: Todo{Title: "todo 4"}},
+			{Op: OpDelete, Todo: *todo2},
+		})
+		switch err.(type) {
+		case NotFound:
+		default:
+			t.Fatal("error type error", err)
+		}
+
+		if results[0].Status != http.StatusFailedDependency ||
+			results[1].Status != http.StatusNotFound {
+			t.Fatal("batch results error", results)
+		}
+
+		todos = store.List()
+		if len(todos) != 2 {
+			t.Fatal("todos count error", todos)
+		}
+
+		// batch invalid
+		_, err = store.Batch([]Operation{{Op: "noop"}})
+		switch err.(type) {
+		case BadRequest:
+		default:
+			t.Fatal("error type error", err)
+		}
+
+		// a failed batch resets the change feed
+		var log = NewChangeLog(10)
+		var logged = log.Store(store)
+		var feed, _ = log.Since(context.Background(), "")
+		logged.Batch([]Operation{{Op: OpDelete, Todo: *todo2}})
+		feed, err = log.Since(context.Background(), feed.Cursor)
+		if err != nil || !feed.Reset {
+			t.Fatal("batch change feed error", err, feed)
+		}
+
+		// a batch delete is logged
+		var deleted = store.List()[0]
+		_, err = logged.Batch([]Operation{{Op: OpDelete, Todo: deleted}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		feed, err = log.Since(context.Background(), feed.Cursor)
+		if err != nil || len(feed.Changes) != 1 || feed.Changes[0].Type != LiveDeleted ||
+			feed.Changes[0].ID != deleted.ID {
+			t.Fatal("batch delete change feed error", err, feed)
+		}
+
+		// a batch delete is broadcast
+		var hub = NewHub()
+		var ctx, cancel = context.WithCancel(context.Background())
+		defer cancel()
+		var sub = hub.Subscribe(ctx)
+		deleted = store.List()[0]
+		_, err = hub.Store(store).Batch([]Operation{{Op: OpDelete, Todo: deleted}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case msg := <-sub:
+			if msg.Type != LiveDeleted || msg.ID != deleted.ID {
+				t.Fatal("batch delete broadcast error", msg)
+			}
+		default:
+			t.Fatal("batch delete broadcast error")
+		}
+	})
+}
+
+func TestStoreBackup(t *testing.T) {
+	withStoreContext(func(store Store) {
+
+		// create
+		var todo1 = NewTodo("todo 1")
+		saveTodo(t, store, todo1)
+
+		var todo2 = NewTodo("todo 2")
+		todo2.Complete()
+		saveTodo(t, store, todo2)
+
+		// backup
+		var buf bytes.Buffer
+		var err = WriteBackup(&buf, NewBackup(store, "test"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		backup, err := ReadBackup(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if backup.Count != 2 {
+			t.Fatal("backup count error", backup.Count)
+		}
+
+		// restore dry run
+		store.CreateTable()
+		restored, err := Restore(store, backup, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if restored.Created != 2 || len(store.List()) != 0 {
+			t.Fatal("restore dry run error", restored)
+		}
+
+		// restore
+		_, err = Restore(store, backup, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = Verify(store, backup, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var todo = findTodo(t, store, todo2.ID)
+		if !todo.Equal(*todo2) {
+			t.Fatalf("equals error:\n%s\n%s\n", todo2, todo)
+		}
+
+		// verify mismatch
+		todo.Title = "todo 3"
+		saveTodo(t, store, &todo)
+
+		err = Verify(store, backup, nil)
+		if err == nil {
+			t.Fatal("verify error")
+		}
+
+		// cross-driver restore, of ids not accepted by the store
+		if _, ok := store.(IDChecker); ok {
+			var uuid = Todo{ID: "9b2d7c1e-4f3a-4e5b-8c6d-0a1b2c3d4e5f", Title: "todo 4", Status: "active", Created: time.Now()}
+			var other = Backup{Version: BackupVersion, Driver: "rethink", Count: 1,
+				Checksum: Checksum(Todos{uuid}), Todos: Todos{uuid}}
+
+			restored, err = Restore(store, other, true)
+			if err != nil || restored.Created != 1 || len(store.List()) != 2 {
+				t.Fatal("restore dry run ids error", err, restored)
+			}
+			restored, err = Restore(store, other, false)
+			if err != nil || restored.Created != 1 || len(store.List()) != 3 {
+				t.Fatal("restore ids error", err, restored)
+			}
+
+			var id = restored.Remapped[uuid.ID]
+			if todo := findTodo(t, store, id); todo.Title != uuid.Title {
+				t.Fatal("restore remapped error", restored, todo)
+			}
+			err = Verify(store, other, restored.Remapped)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestStoreCache(t *testing.T) {
+	withStoreContext(func(store Store) {
+		var cache = NewCache(2, 0)
+		var cached = cache.Store(store)
+
+		var todo1 = NewTodo("todo 1")
+		saveTodo(t, cached, todo1)
+
+		// hits
+		cached.List()
+		cached.List()
+		findTodo(t, cached, todo1.ID)
+		findTodo(t, cached, todo1.ID)
+
+		var stats = cache.Stats()
+		if stats.Hits["List"] != 1 || stats.Misses["List"] != 1 || stats.Hits["Find"] != 1 {
+			t.Fatal("cache hits error", stats)
+		}
+
+		// the mutations of the store alone are not seen
+		var todo2 = NewTodo("todo 2")
+		saveTodo(t, store, todo2)
+		if todos := cached.List(); len(todos) != 1 {
+			t.Fatal("cache list error", todos)
+		}
+
+		// save
+		todo1.Title = "todo one"
+		saveTodo(t, cached, todo1)
+		if todo := findTodo(t, cached, todo1.ID); todo.Title != "todo one" {
+			t.Fatal("cache save error", todo)
+		}
+		if todos := cached.List(); len(todos) != 2 {
+			t.Fatal("cache list error", todos)
+		}
+
+		// toggle
+		findTodo(t, cached, todo2.ID)
+		cached.Toggle("completed")
+		if todo := findTodo(t, cached, todo2.ID); todo.Status != "completed" {
+			t.Fatal("cache toggle error", todo)
+		}
+		if todos := cached.Filter("completed"); len(todos) != 2 {
+			t.Fatal("cache filter error", todos)
+		}
+
+		// delete
+		var err = cached.Delete(todo1.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = cached.Find(todo1.ID); err == nil {
+			t.Fatal("cache delete error")
+		}
+
+		// clear
+		findTodo(t, cached, todo2.ID)
+		cached.Clear("completed")
+		if _, err = cached.Find(todo2.ID); err == nil {
+			t.Fatal("cache clear error")
+		}
+
+		// eviction
+		var evictions = cache.Stats().Evictions
+		var todo3 = NewTodo("todo 3")
+		saveTodo(t, cached, todo3)
+		cached.List()
+		findTodo(t, cached, todo3.ID)
+		cached.Filter("active")
+		stats = cache.Stats()
+		if stats.Entries != 2 || stats.Evictions != evictions+1 {
+			t.Fatal("cache eviction error", stats)
+		}
+
+		// expiration
+		cache = NewCache(10, time.Millisecond)
+		cached = cache.Store(store)
+		cached.List()
+		time.Sleep(5 * time.Millisecond)
+		cached.List()
+		if stats = cache.Stats(); stats.Misses["List"] != 2 {
+			t.Fatal("cache expiration error", stats)
+		}
+
+		// metrics
+		var buf bytes.Buffer
+		writeCacheStats(&buf, stats)
+		if !strings.Contains(buf.String(), `todo_cache_misses_total{op="List"} 2`) {
+			t.Fatal("cache metrics error", buf.String())
+		}
+	})
+}
+
 func BenchmarkStoreC(b *testing.B) {
 	withStoreContext(func(store Store) {
 		saveTodos(b, store)
@@ -0,0 +1,677 @@
+package todo
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Sync mutations.
+const (
+	SyncCreate = "create"
+	SyncUpdate = "update"
+	SyncDelete = "delete"
+)
+
+// localPrefix prefixes the ids of the todos created offline,
+// until they are created on the server.
+const localPrefix = "local-"
+
+// Mutation is a local change of a todo, queued until pushed to the server.
+// Base is the todo of the server it was made from, to merge the change
+// with the ones of the server since.
+type Mutation struct {
+	Op   string `json:"op"`
+	Todo Todo   `json:"todo"`
+	Base *Todo  `json:"base,omitempty"`
+}
+
+// SyncConflict is a local change that could not be merged with the
+// changes of the server. The server wins: the change, or its field
+// changed on both sides, is dropped.
+type SyncConflict struct {
+	Op     string `json:"op"`
+	ID     string `json:"id"`
+	Field  string `json:"field,omitempty"`
+	Reason string `json:"reason"`
+	Local  Todo   `json:"local"`
+	Server *Todo  `json:"server,omitempty"`
+}
+
+// SyncReport reports the changes pulled and pushed by a sync,
+// and the conflicts of the pushed ones.
+type SyncReport struct {
+	Pulled    int
+	Pushed    int
+	Conflicts []SyncConflict
+}
+
+// syncState is the state of a Syncer, saved to its state file.
+// ID identifies the syncer in the idempotency keys of its creations.
+type syncState struct {
+	ID      string     `json:"id,omitempty"`
+	Cursor  string     `json:"cursor"`
+	Pending []Mutation `json:"pending"`
+	LastID  int64      `json:"last_id"`
+}
+
+// Syncer is an offline-first client of the api. It reads the todos from
+// a local replica, and queues the changes until they are pushed to the
+// server, on the next Sync. A Syncer is safe for concurrent use, and
+// is used while syncing: the requests to the server are sent unlocked.
+//
+// The replica is a Store of the todos of the server, as pulled from its
+// change log; the ids of the server must suit the store, integers for
+// the sql stores. The cursor of the change log and the pending changes
+// are saved to the state file, if any, after each change.
+type Syncer struct {
+	client *Client
+	local  Store
+	path   string
+
+	mu    sync.Mutex
+	state syncState
+
+	// syncing serializes the syncs
+	syncing sync.Mutex
+}
+
+// NewSyncer returns a new Syncer of the given client and replica,
+// with the state saved to the file at path, if not empty.
+func NewSyncer(client *Client, local Store, path string) (*Syncer, error) {
+	var s = &Syncer{client: client, local: local, path: path}
+	if len(path) == 0 {
+		return s, nil
+	}
+
+	var data, err = ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, &s.state)
+	if err != nil {
+		return nil, fmt.Errorf("sync: %s: %s", path, err)
+	}
+	return s, nil
+}
+
+// List returns the todos of the replica, with the pending changes.
+func (s *Syncer) List() Todos {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.view()
+}
+
+// Filter returns the todos with the given status.
+func (s *Syncer) Filter(status string) Todos {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var todos = make(Todos, 0)
+	for _, todo := range s.view() {
+		if todo.Status == status {
+			todos = append(todos, todo)
+		}
+	}
+	return todos
+}
+
+// Find returns the todo with the given id.
+func (s *Syncer) Find(id string) (Todo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.find(id)
+}
+
+// Pending returns the changes not pushed yet, in order.
+func (s *Syncer) Pending() []Mutation {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Mutation(nil), s.state.Pending...)
+}
+
+// Save queues the creation of a todo without id,
+// or else the update of the todo.
+func (s *Syncer) Save(t *Todo) error {
+	if len(t.Status) == 0 {
+		t.Status = "active"
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var err = s.put(t)
+	if err != nil {
+		return err
+	}
+	return s.save()
+}
+
+// Delete queues the deletion of the todo with the given id.
+func (s *Syncer) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var err = s.delete(id)
+	if err != nil {
+		return err
+	}
+	return s.save()
+}
+
+// Patch queues the update of the given fields of a todo.
+func (s *Syncer) Patch(id string, patch TodoPatch) (Todo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var todo, err = s.find(id)
+	if err != nil {
+		return todo, err
+	}
+
+	patch.Apply(&todo)
+	err = s.put(&todo)
+	if err != nil {
+		return todo, err
+	}
+	return todo, s.save()
+}
+
+// Clear queues the deletion of the todos with the given status,
+// one by one, to merge them with the changes of the server.
+func (s *Syncer) Clear(status string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var count int64
+	for _, todo := range s.view() {
+		if todo.Status != status {
+			continue
+		}
+
+		var err = s.delete(todo.ID)
+		if err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, s.save()
+}
+
+// Toggle queues the update of the status of all the todos,
+// one by one, to merge them with the changes of the server.
+func (s *Syncer) Toggle(status string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var count int64
+	for _, todo := range s.view() {
+		if todo.Status == status {
+			continue
+		}
+
+		todo.Status = status
+		var err = s.put(&todo)
+		if err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, s.save()
+}
+
+// view returns the todos of the replica with the pending changes.
+// It requires s.mu held.
+func (s *Syncer) view() Todos {
+	var todos = s.local.List()
+
+	for _, m := range s.state.Pending {
+		var i = indexTodo(todos, m.Todo.ID)
+		switch {
+		case m.Op == SyncCreate:
+			todos = append(todos, m.Todo)
+		case i < 0:
+			// deleted on the server since
+		case m.Op == SyncUpdate:
+			todos[i] = m.Todo
+		case m.Op == SyncDelete:
+			todos = append(todos[:i], todos[i+1:]...)
+		}
+	}
+	return todos
+}
+
+// find returns a todo of the view. It requires s.mu held.
+func (s *Syncer) find(id string) (Todo, error) {
+	var todos = s.view()
+
+	var i = indexTodo(todos, id)
+	if i < 0 {
+		return Todo{}, NotFound{fmt.Errorf("sync: todo %s not found", id)}
+	}
+	return todos[i], nil
+}
+
+// pending returns the index of the pending change of a todo, if any.
+// There is one change at most per todo. It requires s.mu held.
+func (s *Syncer) pending(id string) int {
+	for i, m := range s.state.Pending {
+		if m.Todo.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// put queues the creation or the update of a todo,
+// merged with its pending change. It requires s.mu held.
+func (s *Syncer) put(t *Todo) error {
+	var err = validTodo(*t)
+	if err != nil {
+		return BadRequest{err}
+	}
+
+	if len(t.ID) == 0 {
+		if len(s.state.ID) == 0 {
+			var b = make([]byte, 8)
+			rand.Read(b)
+			s.state.ID = hex.EncodeToString(b)
+		}
+		s.state.LastID++
+		t.ID = localPrefix + strconv.FormatInt(s.state.LastID, 10)
+		t.Created = time.Now().UTC()
+		s.state.Pending = append(s.state.Pending, Mutation{Op: SyncCreate, Todo: *t})
+		return nil
+	}
+
+	current, err := s.find(t.ID)
+	if err != nil {
+		return err
+	}
+	t.Created = current.Created
+
+	var i = s.pending(t.ID)
+	if i >= 0 {
+		s.state.Pending[i].Todo = *t
+		return nil
+	}
+
+	s.state.Pending = append(s.state.Pending, Mutation{Op: SyncUpdate, Todo: *t, Base: &current})
+	return nil
+}
+
+// delete queues the deletion of a todo, merged with its pending change.
+// It requires s.mu held.
+func (s *Syncer) delete(id string) error {
+	var current, err = s.find(id)
+	if err != nil {
+		return err
+	}
+
+	var i = s.pending(id)
+	switch {
+	case i >= 0 && s.state.Pending[i].Op == SyncCreate:
+		s.state.Pending = append(s.state.Pending[:i], s.state.Pending[i+1:]...)
+	case i >= 0:
+		s.state.Pending[i].Op = SyncDelete
+		s.state.Pending[i].Todo = current
+	default:
+		s.state.Pending = append(s.state.Pending, Mutation{Op: SyncDelete, Todo: current, Base: &current})
+	}
+	return nil
+}
+
+// save writes the state to the state file, if any. It requires s.mu held.
+func (s *Syncer) save() error {
+	if len(s.path) == 0 {
+		return nil
+	}
+
+	var data, err = json.Marshal(s.state)
+	if err != nil {
+		return err
+	}
+
+	var tmp = s.path + ".tmp"
+	err = ioutil.WriteFile(tmp, data, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// Sync pulls the changes of the server into the replica, then pushes the
+// pending changes merged with them, in order, and pulls their result.
+// The changes left are kept on network or server errors, and pushed on
+// the next sync; the changes rejected by the server are conflicts.
+func (s *Syncer) Sync() (SyncReport, error) {
+	return s.sync(s.client)
+}
+
+// Run syncs every interval until the context is done.
+// The errors, while offline, and the conflicts are logged.
+func (s *Syncer) Run(ctx context.Context, interval time.Duration) {
+	var client = s.client.WithContext(ctx)
+	var ticker = time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		var report, err = s.sync(client)
+		if err != nil && ctx.Err() == nil {
+			slog.Warn("sync: error", "err", err, "pending", len(s.Pending()))
+		}
+		for _, c := range report.Conflicts {
+			slog.Warn("sync: conflict", "op", c.Op, "id", c.ID, "field", c.Field, "reason", c.Reason)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *Syncer) sync(client *Client) (SyncReport, error) {
+	s.syncing.Lock()
+	defer s.syncing.Unlock()
+
+	var report SyncReport
+
+	var err = s.pull(client, &report)
+	if err == nil && len(s.Pending()) != 0 {
+		err = s.push(client, &report)
+		if err == nil {
+			err = s.pull(client, &report)
+		}
+	}
+
+	s.mu.Lock()
+	var saveErr = s.save()
+	s.mu.Unlock()
+
+	if err == nil {
+		err = saveErr
+	}
+	return report, err
+}
+
+// pull applies the changes of the server since the cursor to the replica.
+func (s *Syncer) pull(client *Client, report *SyncReport) error {
+	s.mu.Lock()
+	var cursor = s.state.Cursor
+	s.mu.Unlock()
+
+	var feed, err = client.Changes(cursor)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if feed.Reset {
+		err = s.replace(feed.Todos)
+		if err != nil {
+			return err
+		}
+		report.Pulled += len(feed.Todos)
+	}
+
+	for _, change := range feed.Changes {
+		if change.Type == LiveDeleted {
+			err = s.deleteLocal(change.ID)
+		} else if change.Todo != nil {
+			_, err = s.local.Import(Todos{*change.Todo})
+		}
+		if err != nil {
+			return err
+		}
+		report.Pulled++
+	}
+
+	s.state.Cursor = feed.Cursor
+	return nil
+}
+
+// replace replaces the todos of the replica. It requires s.mu held.
+func (s *Syncer) replace(todos Todos) error {
+	var ids = make(map[string]bool, len(todos))
+	for _, todo := range todos {
+		ids[todo.ID] = true
+	}
+
+	for _, todo := range s.local.List() {
+		if !ids[todo.ID] {
+			var err = s.deleteLocal(todo.ID)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(todos) == 0 {
+		return nil
+	}
+	var _, err = s.local.Import(todos)
+	return err
+}
+
+// deleteLocal deletes a todo of the replica, if any.
+func (s *Syncer) deleteLocal(id string) error {
+	var err = s.local.Delete(id)
+	if _, ok := err.(NotFound); ok {
+		return nil
+	}
+	return err
+}
+
+// push pushes the pending changes in order, until a network or server
+// error. The state is saved after each change.
+func (s *Syncer) push(client *Client, report *SyncReport) error {
+	for {
+		s.mu.Lock()
+		if len(s.state.Pending) == 0 {
+			s.mu.Unlock()
+			return nil
+		}
+		var m = s.state.Pending[0]
+		s.mu.Unlock()
+
+		var server, err = s.pushMutation(client, m, report)
+		if rejected(err) {
+			report.Conflicts = append(report.Conflicts, SyncConflict{
+				Op: m.Op, ID: m.Todo.ID, Reason: err.Error(), Local: m.Todo,
+			})
+			err = nil
+		}
+		if err != nil {
+			return err
+		}
+
+		err = s.settled(m, server)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// settled saves the todo of the server after a pushed change to the
+// replica, along with the pending changes left.
+func (s *Syncer) settled(m Mutation, server *Todo) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if server != nil {
+		var _, err = s.local.Import(Todos{*server})
+		if err != nil {
+			return err
+		}
+	}
+
+	s.settle(m, server)
+	return s.save()
+}
+
+// settle removes a pushed change from the pending ones, unless changed
+// while pushed: it is then rebased on the todo of the server, if any.
+// A todo created while deleted is deleted next. It requires s.mu held.
+func (s *Syncer) settle(m Mutation, server *Todo) {
+	var i = s.pending(m.Todo.ID)
+	if i < 0 {
+		if m.Op == SyncCreate && server != nil {
+			s.state.Pending = append(s.state.Pending, Mutation{Op: SyncDelete, Todo: *server, Base: server})
+		}
+		return
+	}
+
+	var p = &s.state.Pending[i]
+	if p.Op == m.Op && p.Todo.Title == m.Todo.Title && p.Todo.Status == m.Todo.Status {
+		s.state.Pending = append(s.state.Pending[:i], s.state.Pending[i+1:]...)
+		return
+	}
+
+	if server != nil {
+		if p.Op == SyncCreate {
+			p.Op = SyncUpdate
+		}
+		p.Todo.ID = server.ID
+		p.Base = server
+	}
+}
+
+// rejected returns whether the server rejected a change,
+// rather than failed to process it.
+func rejected(err error) bool {
+	var status *StatusError
+	return errors.As(err, &status) &&
+		status.Status < http.StatusInternalServerError && status.Status != http.StatusTooManyRequests
+}
+
+// idempotencyKey returns the idempotency key of the creation of a todo,
+// the same for each push of the creation.
+func (s *Syncer) idempotencyKey(m Mutation) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.state.ID + "." + m.Todo.ID
+}
+
+// pushMutation pushes a change merged with the todo of the server,
+// as pulled in the replica, and returns the todo of the server after
+// the change, if not deleted. The creations are sent with an idempotency key,
+// not to create a todo twice when pushed again.
+func (s *Syncer) pushMutation(client *Client, m Mutation, report *SyncReport) (*Todo, error) {
+	if m.Op == SyncCreate {
+		var todo = m.Todo
+		todo.ID = ""
+		var err = client.WithIdempotencyKey(s.idempotencyKey(m)).Create(&todo)
+		if err != nil {
+			return nil, err
+		}
+		report.Pushed++
+		return &todo, nil
+	}
+
+	s.mu.Lock()
+	var server, err = s.local.Find(m.Todo.ID)
+	s.mu.Unlock()
+
+	if _, ok := err.(NotFound); ok {
+		if m.Op == SyncUpdate {
+			report.Conflicts = append(report.Conflicts, SyncConflict{
+				Op: m.Op, ID: m.Todo.ID, Reason: "deleted on the server", Local: m.Todo,
+			})
+		}
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if m.Op == SyncDelete {
+		if server.Title != m.Base.Title || server.Status != m.Base.Status {
+			report.Conflicts = append(report.Conflicts, SyncConflict{
+				Op: m.Op, ID: m.Todo.ID, Reason: "changed on the server", Local: m.Todo, Server: &server,
+			})
+			return &server, nil
+		}
+
+		err = client.Delete(m.Todo.ID)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return nil, err
+		}
+		report.Pushed++
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return nil, s.deleteLocal(m.Todo.ID)
+	}
+
+	var patch, conflicts = mergeTodo(m, server)
+	report.Conflicts = append(report.Conflicts, conflicts...)
+	if patch.Empty() {
+		return &server, nil
+	}
+
+	todo, err := client.Patch(m.Todo.ID, patch)
+	if err != nil {
+		return nil, err
+	}
+	report.Pushed++
+	return &todo, nil
+}
+
+// mergeTodo returns the patch of the fields of an update changed from
+// its base, and not on the server, with the conflicts of the fields
+// changed to different values on both sides.
+func mergeTodo(m Mutation, server Todo) (TodoPatch, []SyncConflict) {
+	var conflicts []SyncConflict
+
+	var field = func(name, base, local, remote string) *string {
+		if local == base || local == remote {
+			return nil
+		}
+		if remote != base {
+			conflicts = append(conflicts, SyncConflict{
+				Op: m.Op, ID: server.ID, Field: name, Reason: name + " changed on the server",
+				Local: m.Todo, Server: &server,
+			})
+			return nil
+		}
+		return &local
+	}
+
+	var patch = TodoPatch{
+		Title:  field("title", m.Base.Title, m.Todo.Title, server.Title),
+		Status: field("status", m.Base.Status, m.Todo.Status, server.Status),
+	}
+	return patch, conflicts
+}
+
+// indexTodo returns the index of the todo with the given id, if any.
+func indexTodo(todos Todos, id string) int {
+	for i, todo := range todos {
+		if todo.ID == id {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"todo"
+)
+
+// backupCommand writes a backup archive of the store.
+func backupCommand(args []string) {
+	var flags = flag.NewFlagSet("backup", flag.ExitOnError)
+	var driver, url = storeFlags(flags)
+	var output = flags.String("o", "", "output file (default stdout)")
+	flags.Parse(args)
+
+	var store = todo.OpenStore(*driver, *url)
+	var backup = todo.NewBackup(store, *driver)
+	store.Close()
+
+	var err = writeOutput(*output, func(w io.Writer) error {
+		return todo.WriteBackup(w, backup)
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Fprintf(os.Stderr, "backed up %d todos, checksum %s\n", backup.Count, backup.Checksum)
+}
+
+// restoreCommand loads a backup archive into the store and verifies it.
+func restoreCommand(args []string) {
+	var flags = flag.NewFlagSet("restore", flag.ExitOnError)
+	var driver, url = storeFlags(flags)
+	var dryRun = flags.Bool("dry-run", false, "check the archive and report changes without writing")
+	flags.Parse(args)
+
+	var r io.Reader = os.Stdin
+
+	if name := flags.Arg(0); len(name) != 0 && name != "-" {
+		var file, err = os.Open(name)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer file.Close()
+		r = file
+	}
+
+	var backup, err = todo.ReadBackup(r)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var store = todo.OpenStore(*driver, *url)
+	defer store.Close()
+
+	restored, err := todo.Restore(store, backup, *dryRun)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if restored.DryRun {
+		fmt.Printf("would restore %d todos from %s backup of %s: %d created, %d replaced\n",
+			restored.Count, backup.Driver, backup.Created.Format("2006-01-02 15:04:05"),
+			restored.Created, restored.Replaced)
+		return
+	}
+
+	err = todo.Verify(store, backup, restored.Remapped)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("restored %d todos: %d created, %d replaced, checksum %s verified\n",
+		restored.Count, restored.Created, restored.Replaced, backup.Checksum)
+	if len(restored.Remapped) != 0 {
+		fmt.Printf("the ids of the %s backup are not accepted by the store: the todos were given new ids\n",
+			backup.Driver)
+	}
+}
+
+// storeFlags defines the -driver and -url flags of the store.
+// Their defaults are read from the environment, the url of the
+// driver by default.
+func storeFlags(flags *flag.FlagSet) (*string, *string) {
+	var driver, _, err = todo.DefaultStore()
+	if err != nil {
+		log.Fatal(err)
+	}
+	return flags.String("driver", driver, "store driver, rethink or sqlite3 (TODO_DRIVER)"),
+		flags.String("url", strings.TrimSpace(os.Getenv("DATABASE_URL")), "store url, the default of the driver if empty (DATABASE_URL)")
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"todo"
+)
+
+// clientFlags defines the flags shared by the client commands.
+type clientFlags struct {
+	*flag.FlagSet
+	server *string
+	api    *string
+	token  *string
+	json   *bool
+}
+
+func newClientFlags(name, args string) clientFlags {
+	var flags = flag.NewFlagSet(name, flag.ExitOnError)
+
+	var server = os.Getenv("TODO_SERVER")
+	if len(server) == 0 {
+		server = "http://localhost:8000"
+	}
+
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: todo %s [flags] %s\n", name, args)
+		flags.PrintDefaults()
+	}
+
+	return clientFlags{
+		FlagSet: flags,
+		server:  flags.String("server", server, "server URL (TODO_SERVER)"),
+		api:     flags.String("api", "", "api version, v1 or v2; unversioned paths by default"),
+		token:   flags.String("token", os.Getenv("TODO_TOKEN"), "bearer token of the requests (TODO_TOKEN)"),
+		json:    flags.Bool("json", false, "print JSON instead of a table"),
+	}
+}
+
+func (f clientFlags) client() *todo.Client {
+	var server = strings.TrimRight(*f.server, "/")
+	var options = []todo.ClientOption{todo.WithUserAgent("todo-cli/" + todo.Version)}
+	if len(*f.token) != 0 {
+		options = append(options, todo.WithToken(*f.token))
+	}
+	if len(*f.api) != 0 {
+		options = append(options, todo.WithVersion(*f.api))
+	}
+	return todo.NewClient(server, options...)
+}
+
+// arg returns the only argument or exits.
+func (f clientFlags) arg() string {
+	if f.NArg() != 1 {
+		f.Usage()
+		os.Exit(2)
+	}
+	return f.Arg(0)
+}
+
+// addCommand creates a todo.
+func addCommand(args []string) {
+	var flags = newClientFlags("add", "<title>")
+	flags.Parse(args)
+
+	var title = strings.Join(flags.Args(), " ")
+	if len(strings.TrimSpace(title)) == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	var client = flags.client()
+	var t = todo.NewTodo(title)
+
+	var err = client.Create(t)
+	check(err)
+
+	printTodos(*flags.json, todo.Todos{*t})
+}
+
+// lsCommand lists todos.
+func lsCommand(args []string) {
+	var flags = newClientFlags("ls", "")
+	var status = flags.String("status", "", "list only todos with status (active or completed)")
+	flags.Parse(args)
+
+	var client = flags.client()
+	var todos todo.Todos
+	var err error
+
+	if len(*status) == 0 {
+		todos, err = client.List()
+	} else {
+		todos, err = client.Filter(*status)
+	}
+	check(err)
+
+	printTodos(*flags.json, todos)
+}
+
+// doneCommand completes a todo.
+func doneCommand(args []string) {
+	var flags = newClientFlags("done", "<id>")
+	flags.Parse(args)
+
+	var client = flags.client()
+
+	var status = "completed"
+	var t, err = client.Patch(flags.arg(), todo.TodoPatch{Status: &status})
+	check(err)
+
+	printTodos(*flags.json, todo.Todos{t})
+}
+
+// editCommand changes the title or the status of a todo.
+func editCommand(args []string) {
+	var flags = newClientFlags("edit", "<id>")
+	var title = flags.String("title", "", "new title")
+	var status = flags.String("status", "", "new status (active or completed)")
+	flags.Parse(args)
+
+	var client = flags.client()
+
+	var patch todo.TodoPatch
+	if len(*title) != 0 {
+		patch.Title = title
+	}
+	if len(*status) != 0 {
+		patch.Status = status
+	}
+
+	var t, err = client.Patch(flags.arg(), patch)
+	check(err)
+
+	printTodos(*flags.json, todo.Todos{t})
+}
+
+// rmCommand deletes a todo.
+func rmCommand(args []string) {
+	var flags = newClientFlags("rm", "<id>")
+	flags.Parse(args)
+
+	var client = flags.client()
+
+	var err = client.Delete(flags.arg())
+	check(err)
+}
+
+// clearCommand deletes the completed todos.
+func clearCommand(args []string) {
+	var flags = newClientFlags("clear", "")
+	var status = flags.String("status", "completed", "delete todos with status")
+	flags.Parse(args)
+
+	var client = flags.client()
+
+	var count, err = client.Clear(*status)
+	check(err)
+
+	printCount(*flags.json, "cleared", count)
+}
+
+// toggleAllCommand completes all todos, or activates them all
+// if they are all completed already.
+func toggleAllCommand(args []string) {
+	var flags = newClientFlags("toggle-all", "")
+	flags.Parse(args)
+
+	var client = flags.client()
+
+	var active, err = client.Filter("active")
+	check(err)
+
+	var status = "completed"
+	if len(active) == 0 {
+		status = "active"
+	}
+
+	count, err := client.Toggle(status)
+	check(err)
+
+	printCount(*flags.json, "toggled", count)
+}
+
+// check exits with a non-zero code if the request failed.
+func check(err error) {
+	if err == nil {
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "todo: %s\n", err)
+	os.Exit(1)
+}
+
+func printTodos(asJSON bool, todos todo.Todos) {
+	if asJSON {
+		var encoder = json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(todos)
+		return
+	}
+
+	var w = tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSTATUS\tCREATED\tTITLE")
+
+	for _, t := range todos {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n",
+			t.ID, t.Status, t.Created.Local().Format("2006-01-02 15:04"), t.Title)
+	}
+
+	w.Flush()
+}
+
+func printCount(asJSON bool, name string, count int64) {
+	if asJSON {
+		json.NewEncoder(os.Stdout).Encode(map[string]int64{"count": count})
+		return
+	}
+
+	fmt.Printf("%s %d todos\n", name, count)
+}
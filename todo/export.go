@@ -0,0 +1,110 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"todo"
+)
+
+// exportCommand writes all todos of the store.
+func exportCommand(args []string) {
+	var flags = flag.NewFlagSet("export", flag.ExitOnError)
+	var driver, url = storeFlags(flags)
+	var format = flags.String("format", "", "json, csv, markdown or todotxt (default from file extension, or json)")
+	var output = flags.String("o", "", "output file (default stdout)")
+	flags.Parse(args)
+
+	var store = todo.OpenStore(*driver, *url)
+	var todos = store.List()
+	store.Close()
+
+	var err = writeOutput(*output, func(w io.Writer) error {
+		return todo.WriteTodos(w, fileFormat(*format, *output), todos)
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// writeOutput calls write with the named file, created, or stdout if
+// no name. The file is closed before returning, its error returned as
+// a failed write, and removed on failure rather than left truncated.
+func writeOutput(name string, write func(io.Writer) error) error {
+	if len(name) == 0 {
+		return write(os.Stdout)
+	}
+
+	var file, err = os.Create(name)
+	if err != nil {
+		return err
+	}
+
+	err = write(file)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(name)
+	}
+	return err
+}
+
+// importCommand reads todos into the store.
+// Nothing is imported if any line fails to parse.
+func importCommand(args []string) {
+	var flags = flag.NewFlagSet("import", flag.ExitOnError)
+	var driver, url = storeFlags(flags)
+	var format = flags.String("format", "", "json, csv, markdown or todotxt (default from file extension, or json)")
+	flags.Parse(args)
+
+	var name = flags.Arg(0)
+	var r io.Reader = os.Stdin
+
+	if len(name) != 0 && name != "-" {
+		var file, err = os.Open(name)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer file.Close()
+		r = file
+	} else {
+		name = "stdin"
+	}
+
+	var todos, errs, err = todo.ReadTodos(r, fileFormat(*format, name))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if len(errs) != 0 {
+		for _, e := range errs {
+			fmt.Fprintf(os.Stderr, "%s:%d: %s\n", name, e.Line, e.Message)
+		}
+		os.Exit(1)
+	}
+
+	var store = todo.OpenStore(*driver, *url)
+	defer store.Close()
+
+	count, err := store.Import(todos)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("imported %d todos\n", count)
+}
+
+// fileFormat returns the given format, or the format of the given file name.
+func fileFormat(format, name string) string {
+	if len(format) != 0 {
+		return format
+	}
+	if format, ok := todo.FormatByExt(name); ok {
+		return format
+	}
+	return todo.FormatJSON
+}
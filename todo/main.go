@@ -1,28 +1,131 @@
 package main
 
 import (
-    "log"
-    "net/http"
-    "os"
-    "strings"
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
 
-    "todo"
+	"todo"
 )
 
+const usage = `usage: todo [command] [arguments]
+
+server commands:
+  serve       start the web server (default)
+  export      write all todos to a file or stdout
+  import      read todos from a file or stdin
+  backup      write a backup archive of all todos
+  restore     load a backup archive into a store
+
+client commands:
+  add         create a todo
+  ls          list todos
+  done        complete a todo
+  edit        change the title or the status of a todo
+  rm          delete a todo
+  clear       delete the completed todos
+  toggle-all  complete all todos, or activate them all
+  tui         browse and edit the todos in the terminal
+`
+
 func main() {
-    var store = todo.NewStore()
-    defer store.Close()
+	var command = "serve"
+	var args []string
+
+	if len(os.Args) > 1 {
+		command, args = os.Args[1], os.Args[2:]
+	}
+
+	// serve flags without command
+	if strings.HasPrefix(command, "-") && command != "-h" && command != "-help" {
+		command, args = "serve", os.Args[1:]
+	}
+
+	switch command {
+	case "serve":
+		serve(args)
+	case "export":
+		exportCommand(args)
+	case "import":
+		importCommand(args)
+	case "backup":
+		backupCommand(args)
+	case "restore":
+		restoreCommand(args)
+	case "add":
+		addCommand(args)
+	case "ls":
+		lsCommand(args)
+	case "done":
+		doneCommand(args)
+	case "edit":
+		editCommand(args)
+	case "rm":
+		rmCommand(args)
+	case "clear":
+		clearCommand(args)
+	case "toggle-all":
+		toggleAllCommand(args)
+	case "tui":
+		tuiCommand(args)
+	case "help", "-h", "-help", "--help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+}
+
+func serve(args []string) {
+	var flags = flag.NewFlagSet("serve", flag.ExitOnError)
+	var printConfig = flags.Bool("print-config", false, "print the configuration and exit")
+
+	var config, err = todo.ParseConfig(flags, args)
+	if *printConfig {
+		config.WriteYAML(os.Stdout)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *printConfig {
+		return
+	}
+
+	logger, err := todo.NewLogger(os.Stderr, config.LogFormat, config.LogLevel)
+	if err != nil {
+		log.Fatal(err)
+	}
+	slog.SetDefault(logger)
+
+	var store = todo.OpenStore(config.Driver, config.URL)
+
+	handler, err := todo.NewConfigHandler(store, config)
+	if err != nil {
+		store.Close()
+		log.Fatal(err)
+	}
+
+	var server = todo.NewServer(config, handler)
+	server.AfterShutdown(store.Close)
 
-    var handler = todo.NewAppHandler(store)
+	if len(config.Trace) != 0 {
+		exporter, err := todo.NewExporter(config.Trace)
+		if err != nil {
+			log.Fatal(err)
+		}
 
-    var port = os.Getenv("PORT")
-    port = strings.TrimSpace(port)
-    if len(port) == 0 {
-        port = "8000"
-    }
+		var tracer = todo.NewTracer(exporter, "todo", 5*time.Second)
+		todo.SetTracer(tracer)
+		server.AfterShutdown(tracer.Close)
+	}
 
-    var err = http.ListenAndServe(":"+port, handler)
-    if err != nil {
-        log.Fatal(err)
-    }
+	err = server.Run()
+	if err != nil && err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
 }
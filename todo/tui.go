@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/gdamore/tcell/v2"
+
+	"todo"
+)
+
+// tuiCommand browses and edits the todos in the terminal.
+func tuiCommand(args []string) {
+	var flags = newClientFlags("tui", "")
+	flags.Parse(args)
+
+	var client = flags.client()
+
+	var screen, err = tcell.NewScreen()
+	check(err)
+	check(screen.Init())
+
+	var ctx, stop = signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	err = todo.NewTUI(client, screen).Run(ctx)
+	stop()
+	screen.Fini()
+
+	if err == context.Canceled {
+		err = nil
+	}
+	check(err)
+}
@@ -0,0 +1,5 @@
+// Package todopb defines the TodoService gRPC api of the todos.
+// The client and server stubs are generated from todo.proto.
+package todopb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative todo.proto
@@ -0,0 +1,408 @@
+package todo
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// TraceparentHeader is the W3C trace context header.
+const TraceparentHeader = "traceparent"
+
+// Span kinds, as defined by OpenTelemetry.
+const (
+	SpanInternal = 1
+	SpanServer   = 2
+	SpanClient   = 3
+)
+
+const spanKey contextKey = 100
+
+var (
+	tracerMu sync.RWMutex
+	tracer   *Tracer
+)
+
+// SetTracer sets the tracer of the spans started with StartSpan.
+// Spans are not recorded while the tracer is nil, as by default.
+func SetTracer(t *Tracer) {
+	tracerMu.Lock()
+	tracer = t
+	tracerMu.Unlock()
+}
+
+func currentTracer() *Tracer {
+	tracerMu.RLock()
+	defer tracerMu.RUnlock()
+	return tracer
+}
+
+// Span defines a timed operation of a trace.
+// The methods of a nil Span do nothing.
+type Span struct {
+	TraceID    string
+	SpanID     string
+	ParentID   string
+	Name       string
+	Kind       int
+	Start      time.Time
+	End        time.Time
+	Attributes [][2]string
+	Error      string
+
+	tracer *Tracer
+}
+
+// StartSpan starts a span, child of the span of ctx if any,
+// and returns a context holding the new span.
+func StartSpan(ctx context.Context, name string, kind int) (context.Context, *Span) {
+	var t = currentTracer()
+	if t == nil {
+		return ctx, nil
+	}
+
+	var span = &Span{
+		SpanID: randomHex(8),
+		Name:   name,
+		Kind:   kind,
+		Start:  time.Now(),
+		tracer: t,
+	}
+
+	if parent, ok := ctx.Value(spanKey).(*Span); ok {
+		span.TraceID = parent.TraceID
+		span.ParentID = parent.SpanID
+	} else {
+		span.TraceID = randomHex(16)
+	}
+
+	return context.WithValue(ctx, spanKey, span), span
+}
+
+// SpanFromContext returns the span of ctx, or nil.
+func SpanFromContext(ctx context.Context) *Span {
+	var span, _ = ctx.Value(spanKey).(*Span)
+	return span
+}
+
+// SetAttribute sets an attribute of the span.
+func (s *Span) SetAttribute(key, value string) {
+	if s == nil {
+		return
+	}
+	s.Attributes = append(s.Attributes, [2]string{key, value})
+}
+
+// Finish ends the span, failed if err is not nil, and queues it for export.
+func (s *Span) Finish(err error) {
+	if s == nil {
+		return
+	}
+	s.End = time.Now()
+	if err != nil {
+		s.Error = err.Error()
+	}
+	s.tracer.record(s)
+}
+
+// Traceparent returns the W3C traceparent header value of the span.
+func (s *Span) Traceparent() string {
+	if s == nil {
+		return ""
+	}
+	return "00-" + s.TraceID + "-" + s.SpanID + "-01"
+}
+
+// remoteParent returns a context holding the remote span
+// of the given traceparent header value, if valid.
+func remoteParent(ctx context.Context, traceparent string) context.Context {
+	var parts = strings.Split(traceparent, "-")
+	if len(parts) != 4 || parts[0] != "00" ||
+		!isHex(parts[1], 32) || !isHex(parts[2], 16) || !isHex(parts[3], 2) {
+		return ctx
+	}
+
+	var remote = &Span{TraceID: parts[1], SpanID: parts[2]}
+	return context.WithValue(ctx, spanKey, remote)
+}
+
+// TraceHandler returns a handler tracing the requests of next in
+// a server span, named after the route of router matching the request,
+// or else after the given name. The W3C traceparent header is honoured.
+func TraceHandler(router *mux.Router, name string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		var fn = func(w http.ResponseWriter, r *http.Request) {
+			var route = name
+
+			var match mux.RouteMatch
+			if router != nil && router.Match(r, &match) && match.Route != nil {
+				route = match.Route.GetName()
+			}
+
+			var ctx = remoteParent(r.Context(), r.Header.Get(TraceparentHeader))
+			ctx, span := StartSpan(ctx, route, SpanServer)
+			span.SetAttribute("http.method", r.Method)
+			span.SetAttribute("http.route", route)
+			span.SetAttribute("http.target", r.URL.RequestURI())
+			span.SetAttribute("http.request_id", RequestID(r))
+
+			var sw = &statusWriter{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(sw, r.WithContext(ctx))
+
+			span.SetAttribute("http.status_code", strconv.Itoa(sw.status))
+
+			var err error
+			if sw.status >= http.StatusInternalServerError {
+				err = fmt.Errorf("%d %s", sw.status, http.StatusText(sw.status))
+			}
+			span.Finish(err)
+		}
+
+		return http.HandlerFunc(fn)
+	}
+}
+
+// Exporter sends finished spans to a tracing backend.
+type Exporter interface {
+	Export(spans []*Span) error
+	Close() error
+}
+
+// NewExporter returns the exporter of the given target: an OTLP/HTTP
+// collector URL such as "http://localhost:4318", or "file:" followed by
+// the path of a file where spans are appended as OTLP JSON lines.
+func NewExporter(target string) (Exporter, error) {
+	if strings.HasPrefix(target, "file:") {
+		var file, err = os.OpenFile(strings.TrimPrefix(target, "file:"),
+			os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, err
+		}
+		return &fileExporter{file: file}, nil
+	}
+
+	if !validTraceTarget(target) {
+		return nil, fmt.Errorf("trace: unknown exporter %q", target)
+	}
+
+	var u, _ = url.Parse(target)
+
+	if u.Path == "" || u.Path == "/" {
+		u.Path = "/v1/traces"
+	}
+
+	return &otlpExporter{
+		url:    u.String(),
+		client: &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// validTraceTarget checks the target of NewExporter, without opening it.
+func validTraceTarget(target string) bool {
+	if strings.HasPrefix(target, "file:") {
+		return len(target) > len("file:")
+	}
+	var u, err = url.Parse(target)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && len(u.Host) != 0
+}
+
+// Tracer batches finished spans and exports them periodically.
+type Tracer struct {
+	exporter Exporter
+	service  string
+
+	mu    sync.Mutex
+	spans []*Span
+	done  chan struct{}
+	wg    sync.WaitGroup
+}
+
+// NewTracer returns a tracer of the given service,
+// exporting spans every interval.
+func NewTracer(exporter Exporter, service string, interval time.Duration) *Tracer {
+	var t = &Tracer{
+		exporter: exporter,
+		service:  service,
+		done:     make(chan struct{}),
+	}
+
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+
+		var ticker = time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				t.Flush()
+			case <-t.done:
+				return
+			}
+		}
+	}()
+
+	return t
+}
+
+const maxQueuedSpans = 4096
+
+func (t *Tracer) record(span *Span) {
+	t.mu.Lock()
+	if len(t.spans) < maxQueuedSpans {
+		t.spans = append(t.spans, span)
+	}
+	t.mu.Unlock()
+}
+
+// Flush exports the queued spans.
+func (t *Tracer) Flush() {
+	t.mu.Lock()
+	var spans = t.spans
+	t.spans = nil
+	t.mu.Unlock()
+
+	if len(spans) == 0 {
+		return
+	}
+
+	var err = t.exporter.Export(spans)
+	if err != nil {
+		slog.Error("trace: export", "err", err, "spans", len(spans))
+	}
+}
+
+// Close stops the tracer, exports the queued spans and closes the exporter.
+func (t *Tracer) Close() {
+	close(t.done)
+	t.wg.Wait()
+	t.Flush()
+	t.exporter.Close()
+}
+
+type otlpExporter struct {
+	url    string
+	client *http.Client
+}
+
+func (e *otlpExporter) Export(spans []*Span) error {
+	var data, err = json.Marshal(otlpRequest(spans))
+	if err != nil {
+		return err
+	}
+
+	res, err := e.client.Post(e.url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, res.Body)
+
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("trace: collector status %d", res.StatusCode)
+	}
+	return nil
+}
+
+func (e *otlpExporter) Close() error {
+	return nil
+}
+
+type fileExporter struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+func (e *fileExporter) Export(spans []*Span) error {
+	var data, err = json.Marshal(otlpRequest(spans))
+	if err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	_, err = e.file.Write(append(data, '\n'))
+	return err
+}
+
+func (e *fileExporter) Close() error {
+	return e.file.Close()
+}
+
+// otlpRequest returns the OTLP/HTTP JSON export request of the given spans.
+func otlpRequest(spans []*Span) interface{} {
+	type kv = map[string]interface{}
+
+	var attributes = func(attrs [][2]string) []kv {
+		var list = make([]kv, len(attrs))
+		for i, a := range attrs {
+			list[i] = kv{"key": a[0], "value": kv{"stringValue": a[1]}}
+		}
+		return list
+	}
+
+	var service = "todo"
+	if len(spans) != 0 && spans[0].tracer != nil && len(spans[0].tracer.service) != 0 {
+		service = spans[0].tracer.service
+	}
+
+	var list = make([]kv, len(spans))
+	for i, s := range spans {
+		var status = kv{"code": 1} // ok
+		if len(s.Error) != 0 {
+			status = kv{"code": 2, "message": s.Error}
+		}
+
+		list[i] = kv{
+			"traceId":           s.TraceID,
+			"spanId":            s.SpanID,
+			"parentSpanId":      s.ParentID,
+			"name":              s.Name,
+			"kind":              s.Kind,
+			"startTimeUnixNano": strconv.FormatInt(s.Start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(s.End.UnixNano(), 10),
+			"attributes":        attributes(s.Attributes),
+			"status":            status,
+		}
+	}
+
+	return kv{
+		"resourceSpans": []kv{{
+			"resource": kv{"attributes": attributes([][2]string{{"service.name", service}})},
+			"scopeSpans": []kv{{
+				"scope": kv{"name": "todo"},
+				"spans": list,
+			}},
+		}},
+	}
+}
+
+func randomHex(n int) string {
+	var b = make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func isHex(s string, n int) bool {
+	if len(s) != n {
+		return false
+	}
+	var _, err = hex.DecodeString(s)
+	return err == nil && strings.Trim(s, "0") != ""
+}
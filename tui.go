@@ -0,0 +1,538 @@
+package todo
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// TUI modes.
+const (
+	tuiList = iota
+	tuiAdd
+	tuiEdit
+)
+
+// tuiReconnect is the delay before watching the live channel again.
+const tuiReconnect = 2 * time.Second
+
+const tuiHelp = "space toggle  a add  e edit  d delete  c clear completed  t toggle all  tab filter  q quit"
+
+// tuiFilters are the status filter tabs.
+var tuiFilters = []struct{ name, status string }{
+	{"All", ""},
+	{"Active", "active"},
+	{"Completed", "completed"},
+}
+
+// TUI is the terminal interface of the todos, over a Client: a
+// scrollable list with status filter tabs, refreshed live by the
+// changes of the server. It runs on any tcell.Screen, a
+// tcell.SimulationScreen to test it.
+type TUI struct {
+	client *Client
+	screen tcell.Screen
+
+	todos   Todos
+	filter  int
+	cursor  int
+	offset  int
+	mode    int
+	input   []rune
+	editing string
+	message string
+}
+
+// NewTUI returns a new TUI of the given client, drawn on the given
+// initialized screen.
+func NewTUI(client *Client, screen tcell.Screen) *TUI {
+	return &TUI{client: client, screen: screen, todos: make(Todos, 0)}
+}
+
+// Run lists the todos, and handles the keys and the live changes until
+// quit, or the context is done. The live channel is watched again if
+// disconnected.
+func (t *TUI) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var client = t.client.WithContext(ctx)
+
+	var events = make(chan tcell.Event, 16)
+	var quit = make(chan struct{})
+	defer close(quit)
+	go t.screen.ChannelEvents(events, quit)
+
+	var todos, err = client.List()
+	if err != nil {
+		t.fail(err)
+	} else {
+		t.replace(todos)
+	}
+
+	var live <-chan LiveMessage
+	var reconnect <-chan time.Time
+	var watch = func() {
+		var err error
+		live, err = client.Watch("tui")
+		if err != nil {
+			t.fail(err)
+			reconnect = time.After(tuiReconnect)
+		}
+	}
+	watch()
+
+	for {
+		t.draw()
+
+		select {
+		case ev, ok := <-events:
+			if !ok || !t.handle(ev) {
+				return nil
+			}
+		case msg, ok := <-live:
+			if !ok {
+				live = nil
+				t.message = "live: disconnected"
+				reconnect = time.After(tuiReconnect)
+				continue
+			}
+			t.apply(msg)
+		case <-reconnect:
+			reconnect = nil
+			watch()
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// handle handles an event, and returns false to quit.
+func (t *TUI) handle(ev tcell.Event) bool {
+	switch ev := ev.(type) {
+	case *tcell.EventResize:
+		t.screen.Sync()
+	case *tcell.EventKey:
+		if ev.Key() == tcell.KeyCtrlC {
+			return false
+		}
+		if t.mode != tuiList {
+			t.handleInput(ev)
+			return true
+		}
+		return t.handleKey(ev)
+	}
+	return true
+}
+
+// handleKey handles a key of the list, and returns false to quit.
+func (t *TUI) handleKey(ev *tcell.EventKey) bool {
+	switch ev.Key() {
+	case tcell.KeyEscape:
+		return false
+	case tcell.KeyUp:
+		t.move(-1)
+	case tcell.KeyDown:
+		t.move(1)
+	case tcell.KeyPgUp:
+		t.move(-t.rows())
+	case tcell.KeyPgDn:
+		t.move(t.rows())
+	case tcell.KeyHome:
+		t.move(-len(t.todos))
+	case tcell.KeyEnd:
+		t.move(len(t.todos))
+	case tcell.KeyTab:
+		t.setFilter((t.filter + 1) % len(tuiFilters))
+	case tcell.KeyBacktab:
+		t.setFilter((t.filter + len(tuiFilters) - 1) % len(tuiFilters))
+	case tcell.KeyEnter:
+		t.edit()
+	case tcell.KeyDelete:
+		t.delete()
+	case tcell.KeyRune:
+		switch r := ev.Rune(); r {
+		case 'q':
+			return false
+		case 'k':
+			t.move(-1)
+		case 'j':
+			t.move(1)
+		case '1', '2', '3':
+			t.setFilter(int(r - '1'))
+		case ' ':
+			t.toggle()
+		case 'a', 'n':
+			t.mode, t.input = tuiAdd, nil
+		case 'e':
+			t.edit()
+		case 'd', 'x':
+			t.delete()
+		case 'c':
+			t.clear()
+		case 't':
+			t.toggleAll()
+		case 'r':
+			t.refresh()
+		}
+	}
+	return true
+}
+
+// handleInput handles a key of the title being added or edited.
+func (t *TUI) handleInput(ev *tcell.EventKey) {
+	switch ev.Key() {
+	case tcell.KeyEscape:
+		t.mode, t.editing = tuiList, ""
+	case tcell.KeyEnter:
+		t.submit()
+	case tcell.KeyBackspace, tcell.KeyBackspace2:
+		if len(t.input) != 0 {
+			t.input = t.input[:len(t.input)-1]
+		}
+	case tcell.KeyCtrlU:
+		t.input = nil
+	case tcell.KeyRune:
+		t.input = append(t.input, ev.Rune())
+	}
+}
+
+// visible returns the todos of the current filter.
+func (t *TUI) visible() Todos {
+	var status = tuiFilters[t.filter].status
+	if len(status) == 0 {
+		return t.todos
+	}
+
+	var todos = make(Todos, 0)
+	for _, todo := range t.todos {
+		if todo.Status == status {
+			todos = append(todos, todo)
+		}
+	}
+	return todos
+}
+
+// selected returns the todo under the cursor, if any.
+func (t *TUI) selected() (Todo, bool) {
+	var todos = t.visible()
+	if t.cursor < len(todos) {
+		return todos[t.cursor], true
+	}
+	return Todo{}, false
+}
+
+// count returns the number of todos with the given status, all if empty.
+func (t *TUI) count(status string) int {
+	var count int
+	for _, todo := range t.todos {
+		if len(status) == 0 || todo.Status == status {
+			count++
+		}
+	}
+	return count
+}
+
+// rows returns the number of rows of the list.
+func (t *TUI) rows() int {
+	var _, h = t.screen.Size()
+	if h < 4 {
+		return 1
+	}
+	return h - 3
+}
+
+func (t *TUI) move(n int) {
+	t.cursor += n
+	t.clamp()
+}
+
+func (t *TUI) setFilter(filter int) {
+	t.filter, t.cursor = filter, 0
+	t.clamp()
+}
+
+// clamp keeps the cursor on a todo, and the todo in view.
+func (t *TUI) clamp() {
+	var n = len(t.visible())
+	if t.cursor >= n {
+		t.cursor = n - 1
+	}
+	if t.cursor < 0 {
+		t.cursor = 0
+	}
+
+	var rows = t.rows()
+	if t.offset > t.cursor {
+		t.offset = t.cursor
+	}
+	if t.cursor >= t.offset+rows {
+		t.offset = t.cursor - rows + 1
+	}
+}
+
+// follow moves the cursor to the todo with the given id, if visible.
+func (t *TUI) follow(id string) {
+	if i := indexTodo(t.visible(), id); i >= 0 {
+		t.cursor = i
+	}
+	t.clamp()
+}
+
+// fail shows the error of an action.
+func (t *TUI) fail(err error) {
+	t.message = err.Error()
+}
+
+// replace replaces all the todos, keeping the cursor on its todo.
+func (t *TUI) replace(todos Todos) {
+	var current, _ = t.selected()
+	if todos == nil {
+		todos = make(Todos, 0)
+	}
+	t.todos = todos
+	t.follow(current.ID)
+}
+
+// saved updates a todo, or adds it first, as of the newest.
+func (t *TUI) saved(todo Todo) {
+	var current, _ = t.selected()
+	if i := indexTodo(t.todos, todo.ID); i >= 0 {
+		t.todos[i] = todo
+	} else {
+		t.todos = append(Todos{todo}, t.todos...)
+	}
+	t.follow(current.ID)
+}
+
+// deleted removes a todo.
+func (t *TUI) deleted(id string) {
+	var current, _ = t.selected()
+	if i := indexTodo(t.todos, id); i >= 0 {
+		t.todos = append(t.todos[:i:i], t.todos[i+1:]...)
+	}
+	t.follow(current.ID)
+}
+
+// apply applies a message of the live channel.
+func (t *TUI) apply(msg LiveMessage) {
+	switch msg.Type {
+	case LiveSnapshot, LiveReset:
+		t.replace(msg.Todos)
+		t.message = ""
+	case LiveSaved:
+		if msg.Todo != nil {
+			t.saved(*msg.Todo)
+		}
+	case LiveDeleted:
+		t.deleted(msg.ID)
+	}
+	t.keepEditing()
+}
+
+func (t *TUI) refresh() {
+	var todos, err = t.client.List()
+	if err != nil {
+		t.fail(err)
+		return
+	}
+	t.message = ""
+	t.replace(todos)
+}
+
+// edit edits the title of the todo under the cursor.
+func (t *TUI) edit() {
+	var todo, ok = t.selected()
+	if ok {
+		t.mode, t.input, t.editing = tuiEdit, []rune(todo.Title), todo.ID
+	}
+}
+
+// keepEditing leaves the edit mode if the todo being edited is no
+// longer listed, as deleted or filtered out by a live change.
+func (t *TUI) keepEditing() {
+	if t.mode == tuiEdit && indexTodo(t.visible(), t.editing) < 0 {
+		t.mode, t.input, t.editing = tuiList, nil, ""
+		t.message = "edit: the todo is no longer listed"
+	}
+}
+
+// submit creates the todo being added, or updates the title of the todo
+// being edited; an empty title deletes it.
+func (t *TUI) submit() {
+	var mode, title = t.mode, strings.TrimSpace(string(t.input))
+	t.mode, t.input = tuiList, nil
+
+	if mode == tuiAdd {
+		if len(title) == 0 {
+			return
+		}
+
+		var todo = NewTodo(title)
+		var err = t.client.Create(todo)
+		if err != nil {
+			t.fail(err)
+			return
+		}
+		t.saved(*todo)
+		t.follow(todo.ID)
+		return
+	}
+
+	var i = indexTodo(t.todos, t.editing)
+	t.editing = ""
+	if i < 0 || title == t.todos[i].Title {
+		return
+	}
+	if len(title) == 0 {
+		t.remove(t.todos[i].ID)
+		return
+	}
+
+	todo, err := t.client.Patch(t.todos[i].ID, TodoPatch{Title: &title})
+	if err != nil {
+		t.fail(err)
+		return
+	}
+	t.saved(todo)
+}
+
+// toggle completes the todo under the cursor, or activates it.
+func (t *TUI) toggle() {
+	var todo, ok = t.selected()
+	if !ok {
+		return
+	}
+
+	var status = "completed"
+	if todo.Completed() {
+		status = "active"
+	}
+
+	todo, err := t.client.Patch(todo.ID, TodoPatch{Status: &status})
+	if err != nil {
+		t.fail(err)
+		return
+	}
+	t.saved(todo)
+}
+
+func (t *TUI) delete() {
+	var todo, ok = t.selected()
+	if ok {
+		t.remove(todo.ID)
+	}
+}
+
+// remove deletes the todo with the given id.
+func (t *TUI) remove(id string) {
+	var err = t.client.Delete(id)
+	if err != nil {
+		t.fail(err)
+		return
+	}
+	t.deleted(id)
+}
+
+// clear deletes the completed todos.
+func (t *TUI) clear() {
+	var _, err = t.client.Clear("completed")
+	if err != nil {
+		t.fail(err)
+		return
+	}
+	t.refresh()
+}
+
+// toggleAll completes all todos, or activates them all
+// if they are all completed already.
+func (t *TUI) toggleAll() {
+	var status = "completed"
+	if t.count("active") == 0 {
+		status = "active"
+	}
+
+	var _, err = t.client.Toggle(status)
+	if err != nil {
+		t.fail(err)
+		return
+	}
+	t.refresh()
+}
+
+// draw draws the tabs, the input line, the list and the status line.
+func (t *TUI) draw() {
+	t.screen.Clear()
+	t.screen.HideCursor()
+
+	var w, h = t.screen.Size()
+	var plain = tcell.StyleDefault
+
+	var x = t.text(0, 0, w, "todos ", plain.Bold(true))
+	for i, filter := range tuiFilters {
+		var style = plain
+		if i == t.filter {
+			style = style.Reverse(true)
+		}
+		x = t.text(x, 0, w, fmt.Sprintf(" %s (%d) ", filter.name, t.count(filter.status)), style)
+	}
+
+	if t.mode == tuiAdd {
+		var x = t.text(0, 1, w, "new: "+string(t.input), plain)
+		t.screen.ShowCursor(x, 1)
+	}
+
+	var todos = t.visible()
+	if len(todos) == 0 {
+		t.text(2, 2, w, "nothing to do", plain.Dim(true))
+	}
+
+	t.clamp()
+	for row := 0; row < t.rows() && t.offset+row < len(todos); row++ {
+		var i = t.offset + row
+		var todo = todos[i]
+
+		var mark, style = "[ ] ", plain
+		if todo.Completed() {
+			mark, style = "[x] ", style.Dim(true)
+		}
+
+		var title = todo.Title
+		if i == t.cursor {
+			style = style.Reverse(true)
+			if t.mode == tuiEdit {
+				title = string(t.input)
+			}
+		}
+
+		var x = t.text(0, 2+row, w, mark+title, style)
+		if i == t.cursor && t.mode == tuiEdit {
+			t.screen.ShowCursor(x, 2+row)
+		}
+		for ; x < w; x++ {
+			t.screen.SetContent(x, 2+row, ' ', nil, style)
+		}
+	}
+
+	var status, style = tuiHelp, plain.Dim(true)
+	if len(t.message) != 0 {
+		status, style = t.message, plain.Foreground(tcell.ColorRed)
+	}
+	t.text(0, h-1, w, status, style)
+
+	t.screen.Show()
+}
+
+// text draws a line of text from x to at most w, and returns its end.
+func (t *TUI) text(x, y, w int, s string, style tcell.Style) int {
+	for _, r := range s {
+		if x >= w {
+			break
+		}
+		t.screen.SetContent(x, y, r, nil, style)
+		x++
+	}
+	return x
+}
@@ -0,0 +1,168 @@
+package todo
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// simScreen is a simulation screen whose contents are read while not
+// being shown, as GetContents returns the cells being drawn.
+type simScreen struct {
+	tcell.SimulationScreen
+	mu sync.Mutex
+}
+
+func (s *simScreen) Show() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.SimulationScreen.Show()
+}
+
+func (s *simScreen) Sync() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.SimulationScreen.Sync()
+}
+
+// text returns the text of the screen, a line per row.
+func (s *simScreen) text() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var cells, w, _ = s.GetContents()
+
+	var text strings.Builder
+	for i, cell := range cells {
+		if len(cell.Runes) == 0 {
+			text.WriteRune(' ')
+		} else {
+			text.WriteRune(cell.Runes[0])
+		}
+		if (i+1)%w == 0 {
+			text.WriteRune('\n')
+		}
+	}
+	return text.String()
+}
+
+// waitScreen waits for the text of the screen to match.
+func waitScreen(t *testing.T, screen *simScreen, match func(string) bool) {
+	for i := 0; i < 200; i++ {
+		if match(screen.text()) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("screen error:\n%s", screen.text())
+}
+
+func showing(s string) func(string) bool {
+	return func(text string) bool { return strings.Contains(text, s) }
+}
+
+func typeKeys(screen *simScreen, s string) {
+	for _, r := range s {
+		screen.InjectKey(tcell.KeyRune, r, tcell.ModNone)
+	}
+}
+
+func TestTUI(t *testing.T) {
+	withClientContext(func(client *Client, store Store) {
+		client.Create(NewTodo("todo 1"))
+
+		var screen = &simScreen{SimulationScreen: tcell.NewSimulationScreen("UTF-8")}
+		if err := screen.Init(); err != nil {
+			t.Fatal(err)
+		}
+		defer screen.Fini()
+		screen.SetSize(80, 8)
+
+		var done = make(chan error, 1)
+		go func() {
+			done <- NewTUI(client, screen).Run(context.Background())
+		}()
+		waitScreen(t, screen, showing("[ ] todo 1"))
+
+		// add
+		typeKeys(screen, "atodo 2")
+		waitScreen(t, screen, showing("new: todo 2"))
+		screen.InjectKey(tcell.KeyEnter, 0, tcell.ModNone)
+		waitScreen(t, screen, showing("All (2)"))
+
+		// toggle the new todo, first
+		typeKeys(screen, " ")
+		waitScreen(t, screen, showing("[x] todo 2"))
+		if todos := store.Filter("completed"); len(todos) != 1 || todos[0].Title != "todo 2" {
+			t.Fatal("tui toggle error", todos)
+		}
+
+		// filter tabs
+		screen.InjectKey(tcell.KeyTab, 0, tcell.ModNone)
+		waitScreen(t, screen, func(text string) bool {
+			return !strings.Contains(text, "todo 2") && strings.Contains(text, "todo 1")
+		})
+		typeKeys(screen, "3")
+		waitScreen(t, screen, func(text string) bool {
+			return strings.Contains(text, "todo 2") && !strings.Contains(text, "todo 1")
+		})
+		typeKeys(screen, "1")
+
+		// edit
+		typeKeys(screen, "je")
+		screen.InjectKey(tcell.KeyCtrlU, 0, tcell.ModNone)
+		typeKeys(screen, "todo one")
+		screen.InjectKey(tcell.KeyEnter, 0, tcell.ModNone)
+		waitScreen(t, screen, showing("[ ] todo one"))
+
+		// live refresh
+		var other = NewTodo("todo 3")
+		NewClient(client.BaseURL).Create(other)
+		waitScreen(t, screen, showing("[ ] todo 3"))
+
+		// clear completed
+		typeKeys(screen, "c")
+		waitScreen(t, screen, func(text string) bool {
+			return !strings.Contains(text, "todo 2") && strings.Contains(text, "All (2)")
+		})
+
+		// toggle all
+		typeKeys(screen, "t")
+		waitScreen(t, screen, showing("Completed (2)"))
+
+		// delete
+		typeKeys(screen, "d")
+		waitScreen(t, screen, showing("All (1)"))
+		if todos := store.List(); len(todos) != 1 {
+			t.Fatal("tui delete error", todos)
+		}
+
+		// the todo being edited is deleted
+		var remaining = store.List()[0]
+		NewClient(client.BaseURL).Create(NewTodo("todo 5"))
+		waitScreen(t, screen, showing("todo 5"))
+		typeKeys(screen, "e")
+		typeKeys(screen, " edited")
+		waitScreen(t, screen, showing(remaining.Title+" edited"))
+		NewClient(client.BaseURL).Delete(remaining.ID)
+		waitScreen(t, screen, showing("edit: the todo is no longer listed"))
+		if todos := store.List(); len(todos) != 1 || todos[0].Title != "todo 5" {
+			t.Fatal("tui edit deleted error", todos)
+		}
+
+		// quit
+		typeKeys(screen, "q")
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("tui quit error")
+		}
+	})
+}
@@ -0,0 +1,293 @@
+package todo
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gorilla/csrf"
+	"github.com/gorilla/mux"
+)
+
+// UIPrefix is the path of the html interface.
+const UIPrefix = "/todos"
+
+// maxFormSize is the maximum size of a form post.
+const maxFormSize = 64 << 10
+
+const (
+	RouteUIList   = "UI.List"
+	RouteUICreate = "UI.Create"
+	RouteUIClear  = "UI.Clear"
+	RouteUIToggle = "UI.Toggle"
+
+	// _/{id}
+	RouteUIEdit   = "UI.Edit"
+	RouteUIUpdate = "UI.Update"
+	RouteUIDelete = "UI.Delete"
+)
+
+// NewUIRouter creates a new mux.Router and defines the html interface
+// pages and form posts with URL paths starting with UIPrefix.
+func NewUIRouter() *mux.Router {
+	var router = mux.NewRouter()
+
+	router.Methods("GET").Path(UIPrefix).Name(RouteUIList)
+	router.Methods("POST").Path(UIPrefix).Name(RouteUICreate)
+	router.Methods("POST").Path(UIPrefix + "/clear").Name(RouteUIClear)
+	router.Methods("POST").Path(UIPrefix + "/toggle").Name(RouteUIToggle)
+
+	router.Methods("GET").Path(UIPrefix + "/{id:[A-Za-z0-9-]+}/edit").Name(RouteUIEdit)
+	router.Methods("POST").Path(UIPrefix + "/{id:[A-Za-z0-9-]+}").Name(RouteUIUpdate)
+	router.Methods("POST").Path(UIPrefix + "/{id:[A-Za-z0-9-]+}/delete").Name(RouteUIDelete)
+
+	return router
+}
+
+// UI serves the server-rendered html interface of the todos,
+// working without javascript. Forms are posted, validated as
+// the api patches, and redirected to the list.
+type UI struct {
+	Store
+	assets *Assets
+}
+
+// NewUI returns the html interface of the given store.
+func NewUI(store Store, assets *Assets) UI {
+	return UI{store, assets}
+}
+
+// uiPage is the data of the "todos.html" template.
+type uiPage struct {
+	Todos     Todos
+	Filter    string
+	Active    int
+	Completed int
+	Edit      string
+	Error     string
+	CSRF      template.HTML
+}
+
+// Register sets the handlers to the routes.
+func (ui UI) Register(router *mux.Router) {
+	router.Get(RouteUIList).Handler(ui.handler(ui.List))
+	router.Get(RouteUICreate).Handler(ui.handler(ui.Create))
+	router.Get(RouteUIClear).Handler(ui.handler(ui.Clear))
+	router.Get(RouteUIToggle).Handler(ui.handler(ui.Toggle))
+
+	// _/{id}
+	router.Get(RouteUIEdit).Handler(ui.handler(ui.Edit))
+	router.Get(RouteUIUpdate).Handler(ui.handler(ui.Update))
+	router.Get(RouteUIDelete).Handler(ui.handler(ui.Delete))
+}
+
+// handler returns the handler of fn, rendering the list
+// with the error of invalid forms.
+func (ui UI) handler(fn func(http.ResponseWriter, *http.Request) error) http.Handler {
+	var render = func(w http.ResponseWriter, r *http.Request) error {
+		var err = fn(w, r)
+		if _, ok := err.(BadRequest); ok {
+			return ui.render(w, r, http.StatusBadRequest, uiPage{Error: err.Error()}) // 400
+		}
+		return err
+	}
+	return ErrorFunc(render)
+}
+
+// render writes the list of todos, filtered by the "filter" form value.
+func (ui UI) render(w http.ResponseWriter, r *http.Request, status int, page uiPage) error {
+	var templates, err = ui.assets.Templates()
+	if err != nil {
+		return err // 500
+	}
+
+	var all = ui.store(r).List()
+	page.Filter = readFilter(r)
+	page.CSRF = csrf.TemplateField(r)
+
+	for _, todo := range all {
+		if todo.Completed() {
+			page.Completed++
+		} else {
+			page.Active++
+		}
+		if len(page.Filter) == 0 || todo.Status == page.Filter {
+			page.Todos = append(page.Todos, todo)
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	return templates.ExecuteTemplate(w, "todos.html", page)
+}
+
+// store returns the store of the given request.
+func (ui UI) store(r *http.Request) Store {
+	return ui.Store.WithContext(r.Context())
+}
+
+// redirect redirects form posts to the filtered list.
+func (ui UI) redirect(w http.ResponseWriter, r *http.Request) error {
+	var location = UIPrefix
+	if filter := readFilter(r); len(filter) != 0 {
+		location += "?" + url.Values{"filter": {filter}}.Encode()
+	}
+
+	http.Redirect(w, r, location, http.StatusSeeOther)
+	return nil // 303
+}
+
+// List handles the todos page.
+func (ui UI) List(w http.ResponseWriter, r *http.Request) error {
+	return ui.render(w, r, http.StatusOK, uiPage{}) // 200
+}
+
+// Edit handles the todos page editing a todo.
+func (ui UI) Edit(w http.ResponseWriter, r *http.Request) error {
+	var todo, err = ui.store(r).Find(readID(w, r))
+	if err != nil {
+		return err // 404, 500
+	}
+	return ui.render(w, r, http.StatusOK, uiPage{Edit: todo.ID}) // 200
+}
+
+// Create handles todo creation.
+func (ui UI) Create(w http.ResponseWriter, r *http.Request) error {
+	var title = strings.TrimSpace(r.PostFormValue("title"))
+
+	var err = TodoPatch{Title: &title}.Validate()
+	if err != nil {
+		return BadRequest{err} // 400
+	}
+
+	err = ui.store(r).Save(NewTodo(title))
+	if err != nil {
+		return err // 500
+	}
+	return ui.redirect(w, r) // 303
+}
+
+// Update handles todo update, of the posted title or status.
+func (ui UI) Update(w http.ResponseWriter, r *http.Request) error {
+	var err = r.ParseForm()
+	if err != nil {
+		return BadRequest{err} // 400
+	}
+
+	var patch TodoPatch
+	if values, ok := r.PostForm["title"]; ok {
+		var title = strings.TrimSpace(values[0])
+		patch.Title = &title
+	}
+	if values, ok := r.PostForm["status"]; ok {
+		patch.Status = &values[0]
+	}
+
+	err = patch.Validate()
+	if err != nil {
+		return BadRequest{err} // 400
+	}
+
+	_, err = ui.store(r).Patch(readID(w, r), patch)
+	if err != nil {
+		return err // 404, 500
+	}
+	return ui.redirect(w, r) // 303
+}
+
+// Delete handles todo deletion.
+func (ui UI) Delete(w http.ResponseWriter, r *http.Request) error {
+	var err = ui.store(r).Delete(readID(w, r))
+	if err != nil {
+		return err // 404, 500
+	}
+	return ui.redirect(w, r) // 303
+}
+
+// Clear handles completed todos deletion.
+func (ui UI) Clear(w http.ResponseWriter, r *http.Request) error {
+	var _, err = ui.store(r).Clear("completed")
+	if err != nil {
+		return err // 500
+	}
+	return ui.redirect(w, r) // 303
+}
+
+// Toggle handles all todos update to the posted status.
+func (ui UI) Toggle(w http.ResponseWriter, r *http.Request) error {
+	var status = r.PostFormValue("status")
+
+	var err = TodoPatch{Status: &status}.Validate()
+	if err != nil {
+		return BadRequest{err} // 400
+	}
+
+	_, err = ui.store(r).Toggle(status)
+	if err != nil {
+		return err // 500
+	}
+	return ui.redirect(w, r) // 303
+}
+
+// readFilter returns the status filter of the given request,
+// empty for all todos.
+func readFilter(r *http.Request) string {
+	var filter = r.FormValue("filter")
+	if filter != "active" && filter != "completed" {
+		return ""
+	}
+	return filter
+}
+
+// ParseCSRFKey decodes the given hex encoded CSRF key.
+// An empty key returns nil.
+func ParseCSRFKey(s string) ([]byte, error) {
+	if len(s) == 0 {
+		return nil, nil
+	}
+
+	var key, err = hex.DecodeString(s)
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("csrf: key must be 32 hex encoded bytes")
+	}
+	return key, nil
+}
+
+// CSRFHandler returns the middleware checking the CSRF tokens of
+// the form posts with the given key, or a random key if nil. The
+// tokens of a random key are lost on restart.
+func CSRFHandler(key []byte, secure bool) (func(http.Handler) http.Handler, error) {
+	if key == nil {
+		key = make([]byte, 32)
+		var _, err = rand.Read(key)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var protect = csrf.Protect(key,
+		csrf.Path(UIPrefix),
+		csrf.Secure(secure),
+		csrf.SameSite(csrf.SameSiteStrictMode))
+
+	var middleware = func(next http.Handler) http.Handler {
+		var protected = protect(next)
+
+		var fn = func(w http.ResponseWriter, r *http.Request) {
+			r.Body = http.MaxBytesReader(w, r.Body, maxFormSize)
+
+			// the referer is checked over tls only
+			if r.TLS == nil {
+				r = csrf.PlaintextHTTPRequest(r)
+			}
+			protected.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+	return middleware, nil
+}
@@ -0,0 +1,165 @@
+package todo
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// API versions. Unversioned paths default to APIv1.
+const (
+	APIv1 = "v1"
+	APIv2 = "v2"
+)
+
+// VersionHeader is the response header of the served API version.
+const VersionHeader = "API-Version"
+
+// Page limits of the v2 todos lists.
+const (
+	DefaultPageLimit = 50
+	MaxPageLimit     = 500
+)
+
+// ProblemType is the content type of the v2 errors.
+const ProblemType = "application/problem+json"
+
+var acceptVersion = regexp.MustCompile(`application/vnd\.todo\.(v[0-9]+)\+json`)
+
+// NewRouterVersion creates a new mux.Router and defines HTTP methods
+// with URL paths starting with "/api/{version}/todos".
+func NewRouterVersion(version string) *mux.Router {
+	return NewRouterPrefix("/api/" + version + "/todos")
+}
+
+// Page defines a page of a v2 todos list.
+// Next is the path of the next page, if any.
+type Page struct {
+	Items  Todos  `json:"items"`
+	Total  int    `json:"total"`
+	Offset int    `json:"offset"`
+	Limit  int    `json:"limit"`
+	Next   string `json:"next,omitempty"`
+}
+
+// Problem defines a v2 error, as of RFC 7807.
+type Problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+}
+
+// ProblemFunc augments http.HandlerFunc with error return value,
+// written as a problem.
+type ProblemFunc func(http.ResponseWriter, *http.Request) error
+
+// ServeHTTP calls f(w, r)
+func (f ProblemFunc) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var err = f(w, r)
+	if err == nil {
+		return
+	}
+
+	writeProblem(w, r, errorStatus(r, err), err.Error())
+}
+
+func writeProblem(w http.ResponseWriter, r *http.Request, status int, detail string) {
+	var problem = Problem{
+		Type:     "about:blank",
+		Title:    http.StatusText(status),
+		Status:   status,
+		Detail:   detail,
+		Instance: r.URL.Path,
+	}
+
+	w.Header().Set("Content-Type", ProblemType)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(problem)
+}
+
+// problemHandler writes problems of the given status.
+type problemHandler int
+
+func (status problemHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	writeProblem(w, r, int(status), "")
+}
+
+// writePage writes the page of todos requested with
+// the "offset" and "limit" query parameters.
+func writePage(w http.ResponseWriter, r *http.Request, todos Todos) error {
+	var query = r.URL.Query()
+
+	var offset, err = queryInt(query, "offset", 0)
+	if err != nil {
+		return err // 400
+	}
+	limit, err := queryInt(query, "limit", DefaultPageLimit)
+	if err != nil {
+		return err // 400
+	}
+	if limit == 0 || limit > MaxPageLimit {
+		return BadRequest{fmt.Errorf("web: limit must be between 1 and %d", MaxPageLimit)} // 400
+	}
+
+	var page = Page{Items: make(Todos, 0), Total: len(todos), Offset: offset, Limit: limit}
+
+	if offset < len(todos) {
+		var end = offset + limit
+		if end > len(todos) {
+			end = len(todos)
+		}
+		page.Items = todos[offset:end]
+
+		if end < len(todos) {
+			query.Set("offset", strconv.Itoa(end))
+			query.Set("limit", strconv.Itoa(limit))
+			page.Next = r.URL.Path + "?" + query.Encode()
+		}
+	}
+
+	return writeJSON(w, page, http.StatusOK) // 200
+}
+
+func queryInt(query url.Values, name string, value int) (int, error) {
+	var s = query.Get(name)
+	if len(s) == 0 {
+		return value, nil
+	}
+
+	var i, err = strconv.Atoi(s)
+	if err != nil || i < 0 {
+		return 0, BadRequest{fmt.Errorf("web: %s must be a positive integer", name)}
+	}
+	return i, nil
+}
+
+// VersionHandler returns a handler serving the unversioned paths with
+// the version requested in the Accept header, as in
+// "application/vnd.todo.v2+json", or else with APIv1.
+func VersionHandler(versions map[string]http.Handler) http.Handler {
+	var fn = func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept")
+
+		var version = APIv1
+		if m := acceptVersion.FindStringSubmatch(r.Header.Get("Accept")); m != nil {
+			version = m[1]
+		}
+
+		var handler, ok = versions[version]
+		if !ok {
+			http.Error(w, fmt.Sprintf("web: unknown api version %q", version), http.StatusNotAcceptable)
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	}
+
+	return http.HandlerFunc(fn)
+}
@@ -2,54 +2,104 @@ package todo
 
 import (
 	"fmt"
+	"io/ioutil"
+	"mime"
 	"net/http"
 
 	"github.com/gorilla/mux"
 )
 
+// maxImportSize is the maximum size of an import request body.
+const maxImportSize = 10 << 20
+
 // Context manages todos.
 type Context struct {
 	Store
+	Version string
 }
 
-// NewContext returns a new context.
+// NewContext returns a new context of the v1 api.
 func NewContext(store Store) Context {
-	return Context{store}
+	return Context{store, APIv1}
+}
+
+// NewContextVersion returns a new context of the given api version.
+func NewContextVersion(store Store, version string) Context {
+	return Context{store, version}
+}
+
+// store returns the store logging with the logger of the given request,
+// and tracing its queries within the request span.
+func (ctx Context) store(r *http.Request) Store {
+	return ctx.Store.WithContext(r.Context())
 }
 
 // Register sets a handlers to the routes.
 func (ctx Context) Register(router *mux.Router) {
-	router.Get(RouteList).Handler(ErrorFunc(ctx.List))
-	router.Get(RouteCreate).Handler(ErrorFunc(ctx.Create))
+	router.Get(RouteList).Handler(ctx.handler(ctx.List))
+	router.Get(RouteCreate).Handler(ctx.handler(ctx.Create))
+	router.Get(RouteBatch).Handler(ctx.handler(ctx.Batch))
+	router.Get(RouteExport).Handler(ctx.handler(ctx.Export))
+	router.Get(RouteImport).Handler(ctx.handler(ctx.Import))
 
 	// _/{id}
-	router.Get(RouteFind).Handler(ErrorFunc(ctx.Find))
-	router.Get(RouteUpdate).Handler(ErrorFunc(ctx.Update))
-	router.Get(RouteDelete).Handler(ErrorFunc(ctx.Delete))
+	router.Get(RouteFind).Handler(ctx.handler(ctx.Find))
+	router.Get(RouteUpdate).Handler(ctx.handler(ctx.Update))
+	router.Get(RouteDelete).Handler(ctx.handler(ctx.Delete))
+	router.Get(RoutePatch).Handler(ctx.handler(ctx.Patch))
 
 	// _/{status}
-	router.Get(RouteFilter).Handler(ErrorFunc(ctx.Filter))
-	router.Get(RouteClear).Handler(ErrorFunc(ctx.Clear))
-	router.Get(RouteToggle).Handler(ErrorFunc(ctx.Toggle))
+	router.Get(RouteFilter).Handler(ctx.handler(ctx.Filter))
+	router.Get(RouteClear).Handler(ctx.handler(ctx.Clear))
+	router.Get(RouteToggle).Handler(ctx.handler(ctx.Toggle))
+
+	if ctx.Version == APIv2 {
+		router.NotFoundHandler = problemHandler(http.StatusNotFound)
+		router.MethodNotAllowedHandler = problemHandler(http.StatusMethodNotAllowed)
+	}
+}
+
+// handler returns the handler of fn, which sets the api version headers.
+// The v1 api is deprecated, and the v2 api writes errors as problems.
+func (ctx Context) handler(fn func(http.ResponseWriter, *http.Request) error) http.Handler {
+	var versioned = func(w http.ResponseWriter, r *http.Request) error {
+		w.Header().Set(VersionHeader, ctx.Version)
+		if ctx.Version == APIv1 {
+			w.Header().Set("Deprecation", "true")
+			w.Header().Set("Link", `</api/v2/todos>; rel="successor-version"`)
+		}
+		return fn(w, r)
+	}
+
+	if ctx.Version == APIv2 {
+		return ProblemFunc(versioned)
+	}
+	return ErrorFunc(versioned)
 }
 
 // List handles todos listing.
 func (ctx Context) List(w http.ResponseWriter, r *http.Request) error {
-	var todos = ctx.Store.List()
+	var todos = ctx.store(r).List()
+	if ctx.Version == APIv2 {
+		return writePage(w, r, todos) // 200, 400
+	}
 	return writeJSON(w, todos, http.StatusOK) // 200
 }
 
 // Filter handles todos filtering by status.
 func (ctx Context) Filter(w http.ResponseWriter, r *http.Request) error {
 	var status = readStatus(w, r)
-	var todos = ctx.Store.Filter(status)
+	var todos = ctx.store(r).Filter(status)
+	if ctx.Version == APIv2 {
+		return writePage(w, r, todos) // 200, 400
+	}
 	return writeJSON(w, todos, http.StatusOK) // 200
 }
 
 // Clear handles todos deletion by status.
 func (ctx Context) Clear(w http.ResponseWriter, r *http.Request) error {
 	var status = readStatus(w, r)
-	var count, err = ctx.Store.Clear(status)
+	var count, err = ctx.store(r).Clear(status)
 	if err != nil {
 		return err // 500
 	}
@@ -60,7 +110,7 @@ func (ctx Context) Clear(w http.ResponseWriter, r *http.Request) error {
 // Toggle handles todos updates by status.
 func (ctx Context) Toggle(w http.ResponseWriter, r *http.Request) error {
 	var status = readStatus(w, r)
-	var count, err = ctx.Store.Toggle(status)
+	var count, err = ctx.store(r).Toggle(status)
 	if err != nil {
 		return err // 500
 	}
@@ -77,7 +127,7 @@ func (ctx Context) Create(w http.ResponseWriter, r *http.Request) error {
 
 	todo.ID = ""
 
-	err = ctx.Store.Save(todo)
+	err = ctx.store(r).Save(todo)
 	if err != nil {
 		return err // 500
 	}
@@ -85,11 +135,77 @@ func (ctx Context) Create(w http.ResponseWriter, r *http.Request) error {
 	return writeJSON(w, todo, http.StatusCreated) // 201
 }
 
+// Batch handles many todos creations, updates and deletions at once.
+// The response status is the one of the first failed operation, if any.
+func (ctx Context) Batch(w http.ResponseWriter, r *http.Request) error {
+	var ops []Operation
+	var err = readJSON(r, &ops)
+	if err != nil {
+		return BadRequest{err} // 400
+	}
+
+	results, err := ctx.store(r).Batch(ops)
+	if results == nil {
+		return err // 400, 500
+	}
+
+	var status = http.StatusOK
+	if failed := results.Failed(); failed != nil {
+		status = failed.Status
+	}
+
+	return writeJSON(w, results, status) // 200, 400, 404
+}
+
+// Export handles todos export in the format negotiated with
+// the "format" query parameter or the Accept header.
+func (ctx Context) Export(w http.ResponseWriter, r *http.Request) error {
+	var format, err = negotiateFormat(r)
+	if err != nil {
+		return err // 400
+	}
+
+	var todos = ctx.store(r).List()
+
+	w.Header().Set("Content-Type", FormatType(format))
+	return WriteTodos(w, format, todos) // 200
+}
+
+// Import handles todos import in the format given by
+// the "format" query parameter or the Content-Type header.
+// Nothing is imported if any line fails to parse.
+func (ctx Context) Import(w http.ResponseWriter, r *http.Request) error {
+	var format, ok = FormatByType(r.Header.Get("Content-Type"))
+	if query := r.URL.Query().Get("format"); len(query) != 0 {
+		format, ok = query, len(FormatType(query)) != 0
+	}
+	if !ok {
+		return BadRequest{fmt.Errorf("web: unsupported import format")} // 400
+	}
+
+	var body = http.MaxBytesReader(w, r.Body, maxImportSize)
+	var todos, errs, err = ReadTodos(body, format)
+	if err != nil {
+		return BadRequest{err} // 400
+	}
+
+	if len(errs) != 0 {
+		return writeJSON(w, Imported{Errors: errs}, http.StatusBadRequest) // 400
+	}
+
+	count, err := ctx.store(r).Import(todos)
+	if err != nil {
+		return err // 400, 500
+	}
+
+	return writeJSON(w, Imported{Count: count}, http.StatusOK) // 200
+}
+
 // Find handles todo selection by ID.
 func (ctx Context) Find(w http.ResponseWriter, r *http.Request) error {
 	var id = readID(w, r)
 
-	var todo, err = ctx.Store.Find(id)
+	var todo, err = ctx.store(r).Find(id)
 	if err != nil {
 		return err // 500
 	}
@@ -109,7 +225,7 @@ func (ctx Context) Update(w http.ResponseWriter, r *http.Request) error {
 		return BadRequest{fmt.Errorf("web: mismatch ids")} // 400
 	}
 
-	err = ctx.Store.Save(todo)
+	err = ctx.store(r).Save(todo)
 	if err != nil {
 		return err // 500
 	}
@@ -122,7 +238,7 @@ func (ctx Context) Update(w http.ResponseWriter, r *http.Request) error {
 func (ctx Context) Delete(w http.ResponseWriter, r *http.Request) error {
 	var id = readID(w, r)
 
-	var err = ctx.Store.Delete(id)
+	var err = ctx.store(r).Delete(id)
 	if err != nil {
 		return err // 500
 	}
@@ -131,6 +247,46 @@ func (ctx Context) Delete(w http.ResponseWriter, r *http.Request) error {
 	return nil
 }
 
+// Patch handles partial todo update, with a JSON merge patch
+// or a JSON patch given by the Content-Type header.
+func (ctx Context) Patch(w http.ResponseWriter, r *http.Request) error {
+	var id = readID(w, r)
+
+	var data, err = ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxPatchSize))
+	if err != nil {
+		return BadRequest{err} // 400
+	}
+
+	var patch TodoPatch
+	var mtype, _, _ = mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	switch mtype {
+	case MergePatchType, "application/json":
+		patch, err = readMergePatch(data, id)
+	case JSONPatchType:
+		patch, err = readJSONPatch(data, func() (Todo, error) {
+			return ctx.store(r).Find(id)
+		})
+	default:
+		err = fmt.Errorf("web: unsupported patch type %q", mtype)
+	}
+
+	switch err.(type) {
+	case nil:
+	case NotFound, Conflict:
+		return err // 404, 409
+	default:
+		return BadRequest{err} // 400
+	}
+
+	todo, err := ctx.store(r).Patch(id, patch)
+	if err != nil {
+		return err // 404, 500
+	}
+
+	return writeJSON(w, todo, http.StatusOK) // 200
+}
+
 // readTodo returns the todo from the given request.
 func readTodo(w http.ResponseWriter, r *http.Request) (*Todo, error) {
 	var todo = new(Todo)
@@ -1,11 +1,46 @@
 package todo
 
 import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	crand "crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"database/sql"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/big"
 	"math/rand"
+	"net"
 	"net/http"
+	"net/http/cookiejar"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
 	"testing"
 	"time"
+
+	"github.com/gorilla/handlers"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"todo/todopb"
 )
 
 func withClientContext(fn func(client *Client, store Store)) {
@@ -14,6 +49,7 @@ func withClientContext(fn func(client *Client, store Store)) {
 	store.CreateTable()
 
 	var handler = NewAppHandler(store)
+	defer handler.(io.Closer).Close()
 	var server = httptest.NewServer(handler)
 	defer server.Close()
 
@@ -38,14 +74,12 @@ func TestClientSimple(t *testing.T) {
 		if err != nil {
 			t.Fatal(err)
 		}
-		assertStatus(t, http.StatusCreated, client.Status)
 
 		// list
 		todos, err := client.List()
 		if err != nil {
 			t.Fatal(err)
 		}
-		assertStatus(t, http.StatusOK, client.Status)
 
 		if len(todos) != 1 {
 			t.Fatal("todos list error", todos)
@@ -57,7 +91,6 @@ func TestClientSimple(t *testing.T) {
 		if err != nil {
 			t.Fatal(err)
 		}
-		assertStatus(t, http.StatusOK, client.Status)
 
 		if !todo.Completed() {
 			t.Fatal("todo status error", todo.Status)
@@ -68,7 +101,6 @@ func TestClientSimple(t *testing.T) {
 		if err != nil {
 			t.Fatal(err)
 		}
-		assertStatus(t, http.StatusOK, client.Status)
 
 		if !todo2.Equal(*todo) {
 			t.Fatalf("equals error:\n%s\n%s\n", todo, todo2)
@@ -79,7 +111,6 @@ func TestClientSimple(t *testing.T) {
 		if err != nil {
 			t.Fatal(err)
 		}
-		assertStatus(t, http.StatusOK, client.Status)
 
 		if len(todos) != 1 {
 			t.Fatal("todos filter error", todos)
@@ -90,31 +121,99 @@ func TestClientSimple(t *testing.T) {
 		if err != nil {
 			t.Fatal(err)
 		}
-		assertStatus(t, http.StatusNoContent, client.Status)
 
 		// find
 		_, err = client.Find(todo.ID)
-		if err != nil {
-			t.Error(err)
+		if !errors.Is(err, ErrNotFound) {
+			t.Error("find error", err)
 		}
-		assertStatus(t, http.StatusNotFound, client.Status)
 
 		// update
 		err = client.Update(todo)
-		if err != nil {
-			t.Error(err)
+		if !errors.Is(err, ErrNotFound) {
+			t.Error("update error", err)
 		}
-		assertStatus(t, http.StatusNotFound, client.Status)
 
 		// delete
 		err = client.Delete(todo.ID)
-		if err != nil {
-			t.Error(err)
+		if !errors.Is(err, ErrNotFound) {
+			t.Error("delete error", err)
 		}
-		assertStatus(t, http.StatusNotFound, client.Status)
 	})
 }
 
+func TestClientOptions(t *testing.T) {
+	var mu sync.Mutex
+	var requests []*http.Request
+	var unavailable = 1
+
+	var server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests = append(requests, r)
+
+		if unavailable > 0 {
+			unavailable--
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		if r.Method == "POST" {
+			http.Error(w, "invalid todo", http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte("[]"))
+	}))
+
+	var received = func() []*http.Request {
+		mu.Lock()
+		defer mu.Unlock()
+		return requests
+	}
+
+	var client = NewClient(server.URL,
+		WithToken("secret"),
+		WithUserAgent("todo-test"),
+		WithHTTPClient(&http.Client{Timeout: time.Second}),
+		WithRetries(2, time.Millisecond))
+
+	// idempotent requests are retried
+	var _, err = client.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(received()) != 2 {
+		t.Fatal("retry error", len(received()))
+	}
+
+	var req = received()[1]
+	if req.Header.Get("Authorization") != "Bearer secret" || req.Header.Get("User-Agent") != "todo-test" {
+		t.Fatal("headers error", req.Header)
+	}
+
+	// others are not
+	mu.Lock()
+	unavailable = 1
+	mu.Unlock()
+	err = client.Create(NewTodo("todo"))
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.Status != http.StatusServiceUnavailable ||
+		statusErr.Message != "unavailable" || len(received()) != 3 {
+		t.Fatal("create error", err, len(received()))
+	}
+
+	err = client.Create(NewTodo("todo"))
+	if !errors.Is(err, ErrValidation) || errors.Is(err, ErrNotFound) {
+		t.Fatal("validation error", err)
+	}
+
+	// network errors are returned
+	server.Close()
+	_, err = client.Find("0")
+	if err == nil || errors.As(err, &statusErr) {
+		t.Fatal("network error", err)
+	}
+}
+
 func TestClientFilter(t *testing.T) {
 	withClientContext(func(client *Client, store Store) {
 		// create
@@ -187,25 +286,1844 @@ func TestClientFilter(t *testing.T) {
 	})
 }
 
-func BenchmarkClientR(b *testing.B) {
+func TestClientBatch(t *testing.T) {
 	withClientContext(func(client *Client, store Store) {
-		var ids = saveTodos(b, store)
+		// create
+		var todo1 = NewTodo("todo 1")
+		client.Create(todo1)
 
-		rand.Seed(time.Now().UnixNano())
-		b.ResetTimer()
+		// batch
+		todo1.Complete()
+		results, err := client.Batch([]Operation{
+			{Op: OpCreate, Todo: *NewTodo("todo 2")},
+			{Op: OpCreate, Todo: *NewTodo("todo 3")},
+			{Op: OpUpdate, Todo: *todo1},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
 
-		for i := 0; i < b.N; i++ {
-			var j = rand.Intn(b.N)
-			var id = ids[j]
+		if len(results) != 3 || results.Failed() != nil {
+			t.Fatal("batch results error", results)
+		}
 
-			var _, err = client.Find(id)
+		// filter
+		todos, _ := client.Filter(todo1.Status)
+		if len(todos) != 1 {
+			t.Fatal("todos filter error", todos)
+		}
+
+		// batch rolled back
+		results, err = client.Batch([]Operation{
+			{Op: OpDelete, Todo: *todo1},
+			{Op: OpDelete, Todo: *todo1},
+		})
+		if !errors.Is(err, ErrNotFound) {
+			t.Fatal("batch error", err)
+		}
+
+		if len(results) != 2 || results.Failed() != &results[1] {
+			t.Fatal("batch results error", results)
+		}
+
+		// list
+		todos, _ = client.List()
+		if len(todos) != 3 {
+			t.Fatal("todos list error", todos)
+		}
+	})
+}
+
+func TestClientExport(t *testing.T) {
+	withClientContext(func(client *Client, store Store) {
+		// create
+		var todo1 = NewTodo("todo 1")
+		client.Create(todo1)
+
+		var todo2 = NewTodo("todo 2")
+		todo2.Complete()
+		client.Create(todo2)
+
+		// export
+		data, err := client.Export(FormatCSV)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// import
+		store.CreateTable()
+		imported, err := client.Import(FormatCSV, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if imported.Count != 2 {
+			t.Fatal("todos import error", imported)
+		}
+
+		// find
+		todo, err := client.Find(todo2.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !todo.Equal(*todo2) {
+			t.Fatalf("equals error:\n%s\n%s\n", todo2, todo)
+		}
+
+		// import errors
+		imported, err = client.Import(FormatMarkdown, []byte("- [x] todo 3\n- [x]\n"))
+		if !errors.Is(err, ErrValidation) {
+			t.Fatal("import error", err)
+		}
+
+		if imported.Count != 0 || len(imported.Errors) != 1 || imported.Errors[0].Line != 2 {
+			t.Fatal("todos import error", imported)
+		}
+	})
+}
+
+type pingStore struct {
+	Store
+	delay    time.Duration
+	canceled chan error
+}
+
+func (s pingStore) Ping(ctx context.Context) error {
+	select {
+	case <-time.After(s.delay):
+	case <-ctx.Done():
+		s.canceled <- ctx.Err()
+		return ctx.Err()
+	}
+	return s.Store.Ping(ctx)
+}
+
+func TestHealth(t *testing.T) {
+	withClientContext(func(client *Client, store Store) {
+		for _, path := range []string{"/healthz", "/readyz", "/debug/info"} {
+			var res, err = http.Get(client.BaseURL + path)
 			if err != nil {
-				b.Error(err)
+				t.Fatal(err)
+			}
+			res.Body.Close()
+			assertStatus(t, http.StatusOK, res.StatusCode)
+		}
+
+		// schema version
+		var info Info
+		var res, err = http.Get(client.BaseURL + "/debug/info")
+		if err != nil {
+			t.Fatal(err)
+		}
+		json.NewDecoder(res.Body).Decode(&info)
+		res.Body.Close()
+		if _, ok := store.(SchemaVersioner); ok && info.SchemaVersion != SQLSchemaVersion {
+			t.Fatal("info schema version error", info)
+		}
+
+		// ping timeout
+		var canceled = make(chan error, 1)
+		var handler = ReadyPage(pingStore{store, time.Minute, canceled}, 10*time.Millisecond)
+		var w = httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/readyz", nil))
+		assertStatus(t, http.StatusServiceUnavailable, w.Code)
+
+		select {
+		case err = <-canceled:
+			if err != context.DeadlineExceeded {
+				t.Fatal("ping cancel error", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("ping cancel timeout")
+		}
+	})
+}
+
+func TestMetrics(t *testing.T) {
+	withClientContext(func(client *Client, store Store) {
+		client.List()
+		client.Find("0")
+
+		var res, err = http.Get(client.BaseURL + "/metrics")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		assertStatus(t, http.StatusOK, res.StatusCode)
+
+		body, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, line := range []string{
+			`todo_http_requests_total{route="Todo.List",method="GET",code="200"} 1`,
+			`todo_http_requests_total{route="Todo.Find",method="GET",code="404"} 1`,
+			`todo_store_operation_duration_seconds_count{op="Find"} 1`,
+		} {
+			if !strings.Contains(string(body), line+"\n") {
+				t.Errorf("metrics error, missing %s", line)
+			}
+		}
+
+		if strings.Contains(string(body), `todo_store_errors_total{op="Find"}`) {
+			t.Error("metrics error, not found is not a store error")
+		}
+
+		// the pool of an sql store, decorated by the webhooks
+		if _, ok := store.(interface{ Stats() sql.DBStats }); ok &&
+			!strings.Contains(string(body), "# TYPE todo_db_open_connections gauge\n") {
+			t.Error("metrics error, missing todo_db_open_connections")
+		}
+	})
+}
+
+func TestRequestID(t *testing.T) {
+	var buf bytes.Buffer
+	var logger, _ = NewLogger(&buf, LogLogfmt, "info")
+
+	var handler = RequestIDHandler(logger)(StructuredLoggingHandler(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			Logger(r).Warn("handled")
+		})))
+
+	// honoured
+	var w = httptest.NewRecorder()
+	var r = httptest.NewRequest("GET", "/api/todos", nil)
+	r.Header.Set(RequestIDHeader, "abc-123")
+	handler.ServeHTTP(w, r)
+
+	if id := w.Header().Get(RequestIDHeader); id != "abc-123" {
+		t.Fatal("request id error", id)
+	}
+
+	var lines = strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatal("log lines error", lines)
+	}
+	for _, line := range lines {
+		if !strings.Contains(line, "request_id=abc-123") {
+			t.Fatal("log request id error", line)
+		}
+	}
+
+	// generated
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest("GET", "/api/todos", nil)
+	r.Header.Set(RequestIDHeader, "bad id")
+	handler.ServeHTTP(w, r)
+
+	if id := w.Header().Get(RequestIDHeader); len(id) != 36 {
+		t.Fatal("request id error", id)
+	}
+
+	// access logs
+	for _, format := range []struct {
+		logging func(io.Writer, http.Handler) http.Handler
+		suffix  string
+	}{
+		{handlers.LoggingHandler, `404 19 "abc-123"` + "\n"},
+		{handlers.CombinedLoggingHandler, `404 19 "" "" "abc-123"` + "\n"},
+	} {
+		buf.Reset()
+		handler = RequestIDHandler(logger)(accessLogHandler(&buf, format.logging)(http.NotFoundHandler()))
+		r = httptest.NewRequest("GET", "/api/todos", nil)
+		r.Header.Set(RequestIDHeader, "abc-123")
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+
+		if line := buf.String(); !strings.HasSuffix(line, format.suffix) {
+			t.Fatal("access log request id error", line)
+		}
+	}
+}
+
+type memExporter struct {
+	mu    sync.Mutex
+	spans []*Span
+}
+
+func (e *memExporter) Export(spans []*Span) error {
+	e.mu.Lock()
+	e.spans = append(e.spans, spans...)
+	e.mu.Unlock()
+	return nil
+}
+
+func (e *memExporter) Close() error {
+	return nil
+}
+
+func (e *memExporter) find(name string) *Span {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, span := range e.spans {
+		if span.Name == name {
+			return span
+		}
+	}
+	return nil
+}
+
+func TestTracing(t *testing.T) {
+	var exporter = &memExporter{}
+	var tracer = NewTracer(exporter, "todo", time.Hour)
+	SetTracer(tracer)
+	defer SetTracer(nil)
+
+	withClientContext(func(client *Client, store Store) {
+		var ctx, root = StartSpan(context.Background(), "test", SpanInternal)
+		var traced = client.WithContext(ctx)
+		var _, err = traced.Find("0")
+		if !errors.Is(err, ErrNotFound) {
+			t.Fatal("find error", err)
+		}
+		root.Finish(nil)
+
+		// the server span ends after the response is sent
+		var server *Span
+		for i := 0; i < 100 && server == nil; i++ {
+			tracer.Flush()
+			server = exporter.find(RouteFind)
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		var call, query = exporter.find("HTTP GET"), exporter.find("store.Find")
+		if call == nil || server == nil || query == nil {
+			t.Fatal("spans error", exporter.spans)
+		}
+
+		if call.TraceID != root.TraceID || server.TraceID != root.TraceID || query.TraceID != root.TraceID {
+			t.Fatal("trace id error", root.TraceID, call.TraceID, server.TraceID, query.TraceID)
+		}
+		if call.ParentID != root.SpanID || server.ParentID != call.SpanID || query.ParentID != server.SpanID {
+			t.Fatal("parent span error", call, server, query)
+		}
+
+		var statement, system string
+		for _, attr := range query.Attributes {
+			switch attr[0] {
+			case "db.statement":
+				statement = attr[1]
+			case "db.system":
+				system = attr[1]
+			}
+		}
+		if _, ok := store.(sqlStore); ok && system != "sqlite" {
+			t.Fatal("db system error", system)
+		}
+		if !strings.Contains(statement, "FROM todo") {
+			t.Fatal("statement error", query.Attributes)
+		}
+	})
+}
+
+func TestRateLimit(t *testing.T) {
+	var store = NewStore()
+	defer store.Close()
+	store.CreateTable()
+
+	var config = DefaultConfig()
+	config.LogFormat = LogNone
+	config.RateRead = "100/1m"
+	config.RateWrite = "2/1s"
+	config.Tokens = "alice:secret"
+	config.GRPCAddr = "127.0.0.1:0"
+	config.Webhooks = true
+
+	var invalid = config
+	invalid.RateWrite = "2/0s"
+	if _, err := NewConfigHandler(store, invalid); err == nil {
+		t.Fatal("rate limit config error")
+	}
+
+	var handler, err = NewConfigHandler(store, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer handler.(io.Closer).Close()
+	var server = httptest.NewServer(handler)
+	defer server.Close()
+
+	var client = NewClient(server.URL, WithRetries(0, 0))
+
+	for i := 0; i < 2; i++ {
+		err = client.Create(NewTodo("todo"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the client does not retry
+	err = client.Create(NewTodo("todo"))
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.Status != http.StatusTooManyRequests {
+		t.Fatal("rate limit error", err)
+	}
+
+	// writes are limited
+	var res, _ = http.Post(server.URL+"/api/todos", "application/json",
+		strings.NewReader(`{"title":"todo"}`))
+	res.Body.Close()
+	assertStatus(t, http.StatusTooManyRequests, res.StatusCode)
+
+	if res.Header.Get("Retry-After") != "1" || res.Header.Get("RateLimit-Limit") != "2" ||
+		res.Header.Get("RateLimit-Remaining") != "0" {
+		t.Fatal("rate limit headers error", res.Header)
+	}
+
+	// reads are not
+	_, err = client.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// every interface is limited
+	for _, post := range []struct{ path, ctype, body string }{
+		{GraphQLPath, "application/json", `{"query":"mutation { createTodo(input: {title: \"todo\"}) { id } }"}`},
+		{UIPrefix, "application/x-www-form-urlencoded", "title=todo"},
+		{"/api/webhooks", "application/json", `{"url":"https://example.com"}`},
+	} {
+		res, err = http.Post(server.URL+post.path, post.ctype, strings.NewReader(post.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if post.path == "/api/webhooks" && res.StatusCode == http.StatusNotFound {
+			continue // no webhooks of the store
+		}
+		assertStatus(t, http.StatusTooManyRequests, res.StatusCode)
+	}
+
+	var url = "ws" + strings.TrimPrefix(server.URL, "http") + "/api/live"
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.WriteJSON(LiveMessage{Type: LiveMutate, Ref: "1", Op: OpCreate, Todo: NewTodo("todo")})
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		var msg LiveMessage
+		if err = conn.ReadJSON(&msg); err != nil {
+			t.Fatal(err)
+		}
+		if msg.Ref == "1" {
+			if msg.Type != LiveError || msg.Status != http.StatusTooManyRequests {
+				t.Fatal("live rate limit error", msg)
 			}
+			break
+		}
+	}
+
+	var grpcServer = handler.(interface{ GRPCServer() *GRPCServer }).GRPCServer()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go grpcServer.Serve(ln)
+	defer grpcServer.Stop()
+
+	grpcConn, err := grpc.NewClient(ln.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer grpcConn.Close()
+	var grpcClient = todopb.NewTodoServiceClient(grpcConn)
+	_, err = grpcClient.Create(context.Background(), &todopb.CreateRequest{Todo: &todopb.Todo{Title: "todo"}})
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatal("grpc rate limit error", err)
+	}
+	var guess = metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer guess")
+	_, err = grpcClient.List(guess, &todopb.ListRequest{})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Fatal("grpc token error", err)
+	}
+
+	// users are limited apart, unknown tokens are rejected
+	err = NewClient(server.URL, WithRetries(0, 0), WithToken("secret")).Create(NewTodo("todo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = NewClient(server.URL, WithRetries(0, 0), WithToken("guess")).Create(NewTodo("todo"))
+	if !errors.As(err, &statusErr) || statusErr.Status != http.StatusUnauthorized {
+		t.Fatal("rate limit token error", err)
+	}
+
+	// the client backs off
+	client = NewClient(server.URL)
+	var start = time.Now()
+	err = client.Create(NewTodo("todo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if time.Since(start) < 500*time.Millisecond {
+		t.Fatal("client backoff error", time.Since(start))
+	}
+}
+
+func TestIdempotency(t *testing.T) {
+	var calls int
+	var handler = NewIdempotency(time.Minute).Handler(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			w.WriteHeader(http.StatusCreated)
+		}))
+
+	var post = func(user string) *httptest.ResponseRecorder {
+		var w = httptest.NewRecorder()
+		var r = httptest.NewRequest("POST", "/api/todos", nil)
+		r.Header.Set(IdempotencyHeader, "abc-123")
+		handler.ServeHTTP(w, WithUser(r, user))
+		return w
+	}
+
+	// replayed to the same user
+	post("alice")
+	var w = post("alice")
+	if calls != 1 || w.Code != http.StatusCreated || w.Header().Get("Idempotent-Replayed") != "true" {
+		t.Fatal("replay error", calls, w.Code, w.Header())
+	}
+
+	// processed for another user
+	w = post("bob")
+	if calls != 2 || w.Header().Get("Idempotent-Replayed") != "" {
+		t.Fatal("user key error", calls, w.Header())
+	}
+}
+
+func TestCORS(t *testing.T) {
+	var store = NewStore()
+	defer store.Close()
+	store.CreateTable()
+
+	var config = DefaultConfig()
+	config.LogFormat = LogNone
+	config.CORSOrigins = "https://dash.example.com"
+	config.CORSCredentials = true
+
+	var handler, err = NewConfigHandler(store, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer handler.(io.Closer).Close()
+
+	var preflight = func(origin, path, method, headers string) *httptest.ResponseRecorder {
+		var w = httptest.NewRecorder()
+		var r = httptest.NewRequest("OPTIONS", path, nil)
+		r.Header.Set("Origin", origin)
+		r.Header.Set("Access-Control-Request-Method", method)
+		if len(headers) != 0 {
+			r.Header.Set("Access-Control-Request-Headers", headers)
+		}
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	// allowed
+	var w = preflight("https://dash.example.com", "/api/todos/1", "PUT", "content-type")
+	assertStatus(t, http.StatusNoContent, w.Code)
+
+	if w.Header().Get("Access-Control-Allow-Origin") != "https://dash.example.com" ||
+		w.Header().Get("Access-Control-Allow-Credentials") != "true" ||
+		w.Header().Get("Access-Control-Allow-Methods") != "GET, PUT, PATCH, DELETE" {
+		t.Fatal("preflight headers error", w.Header())
+	}
+
+	// method of another route
+	w = preflight("https://dash.example.com", "/api/todos", "DELETE", "")
+	assertStatus(t, http.StatusMethodNotAllowed, w.Code)
+
+	// unknown origin and header
+	w = preflight("https://evil.example.com", "/api/todos", "POST", "")
+	assertStatus(t, http.StatusForbidden, w.Code)
 
-			if http.StatusOK != client.Status {
-				b.Errorf("expected response status %d but was %d",
-					http.StatusOK, client.Status)
+	w = preflight("https://dash.example.com", "/api/todos", "POST", "x-api-key")
+	assertStatus(t, http.StatusForbidden, w.Code)
+
+	// bearer tokens
+	w = preflight("https://dash.example.com", "/api/todos", "POST", "authorization, content-type")
+	assertStatus(t, http.StatusNoContent, w.Code)
+
+	// actual request
+	w = httptest.NewRecorder()
+	var r = httptest.NewRequest("GET", "/api/todos", nil)
+	r.Header.Set("Origin", "https://dash.example.com")
+	handler.ServeHTTP(w, r)
+	assertStatus(t, http.StatusOK, w.Code)
+
+	if w.Header().Get("Access-Control-Allow-Origin") != "https://dash.example.com" ||
+		!strings.Contains(w.Header().Get("Access-Control-Expose-Headers"), RequestIDHeader) {
+		t.Fatal("cors headers error", w.Header())
+	}
+
+	// same origin
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/api/todos", nil))
+	if len(w.Header().Get("Access-Control-Allow-Origin")) != 0 {
+		t.Fatal("cors headers error", w.Header())
+	}
+
+	config.CORSOrigins = "*"
+	if config.Validate() == nil {
+		t.Fatal("cors credentials error")
+	}
+}
+
+func TestAssets(t *testing.T) {
+	var assets, err = EmbeddedAssets()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var get = func(path string, header ...string) *httptest.ResponseRecorder {
+		var w = httptest.NewRecorder()
+		var r = httptest.NewRequest("GET", path, nil)
+		for i := 0; i < len(header); i += 2 {
+			r.Header.Set(header[i], header[i+1])
+		}
+		assets.ServeHTTP(w, r)
+		return w
+	}
+
+	// fingerprinted
+	var path = assets.Path("app.css")
+	if path == "app.css" || !strings.HasPrefix(path, "app.") {
+		t.Fatal("asset path error", path)
+	}
+
+	var w = get("/"+path, "Accept-Encoding", "gzip, br;q=0")
+	assertStatus(t, http.StatusOK, w.Code)
+	if w.Header().Get("Cache-Control") != ImmutableCache ||
+		w.Header().Get("Content-Encoding") != "gzip" ||
+		!strings.HasPrefix(w.Header().Get("Content-Type"), "text/css") {
+		t.Fatal("asset headers error", w.Header())
+	}
+
+	w = get("/"+path, "Accept-Encoding", "gzip, br")
+	if w.Header().Get("Content-Encoding") != "br" {
+		t.Fatal("asset encoding error", w.Header())
+	}
+
+	// revalidated
+	w = get("/app.css")
+	var etag = w.Header().Get("ETag")
+	if w.Header().Get("Cache-Control") != "no-cache" || len(etag) == 0 ||
+		len(w.Header().Get("Content-Encoding")) != 0 {
+		t.Fatal("asset headers error", w.Header())
+	}
+
+	w = get("/app.css", "If-None-Match", etag)
+	assertStatus(t, http.StatusNotModified, w.Code)
+
+	w = get("/missing.css")
+	assertStatus(t, http.StatusNotFound, w.Code)
+
+	// home page links
+	withClientContext(func(client *Client, store Store) {
+		var res, err = http.Get(client.BaseURL + "/index.html")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+
+		var body, _ = ioutil.ReadAll(res.Body)
+		if !bytes.Contains(body, []byte(`href="`+path+`"`)) {
+			t.Fatal("home page assets error", string(body))
+		}
+	})
+}
+
+func TestUI(t *testing.T) {
+	withClientContext(func(client *Client, store Store) {
+		var jar, _ = cookiejar.New(nil)
+		var browser = &http.Client{
+			Jar: jar,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		}
+
+		var get = func(path string) string {
+			var res, err = browser.Get(client.BaseURL + path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer res.Body.Close()
+			assertStatus(t, http.StatusOK, res.StatusCode)
+
+			var body, _ = ioutil.ReadAll(res.Body)
+			return string(body)
+		}
+
+		var token = regexp.MustCompile(`name="gorilla.csrf.Token" value="([^"]+)"`)
+		var post = func(path string, form url.Values) (int, string) {
+			if m := token.FindStringSubmatch(get("/todos")); m != nil && form.Get("gorilla.csrf.Token") != "-" {
+				form.Set("gorilla.csrf.Token", m[1])
+			}
+			var res, err = browser.PostForm(client.BaseURL+path, form)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer res.Body.Close()
+
+			var body, _ = ioutil.ReadAll(res.Body)
+			return res.StatusCode, string(body)
+		}
+
+		// create
+		var status, _ = post("/todos", url.Values{"title": {"todo 1"}})
+		assertStatus(t, http.StatusSeeOther, status)
+
+		var todos = store.List()
+		if len(todos) != 1 || todos[0].Title != "todo 1" {
+			t.Fatal("ui create error", todos)
+		}
+		if !strings.Contains(get("/todos"), "todo 1") {
+			t.Fatal("ui list error")
+		}
+
+		// csrf
+		status, _ = post("/todos", url.Values{"title": {"todo 2"}, "gorilla.csrf.Token": {"-"}})
+		assertStatus(t, http.StatusForbidden, status)
+
+		// invalid
+		status, body := post("/todos", url.Values{"title": {" "}})
+		assertStatus(t, http.StatusBadRequest, status)
+		if !strings.Contains(body, "empty title") {
+			t.Fatal("ui error error", body)
+		}
+
+		// edit, complete
+		if !strings.Contains(get("/todos/"+todos[0].ID+"/edit"), `value="todo 1"`) {
+			t.Fatal("ui edit error")
+		}
+		status, _ = post("/todos/"+todos[0].ID, url.Values{"title": {"todo 3"}, "filter": {"active"}})
+		assertStatus(t, http.StatusSeeOther, status)
+		status, _ = post("/todos/"+todos[0].ID, url.Values{"status": {"completed"}})
+		assertStatus(t, http.StatusSeeOther, status)
+
+		var todo = findTodo(t, store, todos[0].ID)
+		if todo.Title != "todo 3" || !todo.Completed() {
+			t.Fatal("ui update error", todo)
+		}
+		if strings.Contains(get("/todos?filter=active"), "todo 3") {
+			t.Fatal("ui filter error")
+		}
+
+		// toggle, clear, delete
+		post("/todos", url.Values{"title": {"todo 4"}})
+		status, _ = post("/todos/toggle", url.Values{"status": {"completed"}})
+		assertStatus(t, http.StatusSeeOther, status)
+		if len(store.Filter("completed")) != 2 {
+			t.Fatal("ui toggle error", store.List())
+		}
+
+		status, _ = post("/todos/clear", url.Values{})
+		assertStatus(t, http.StatusSeeOther, status)
+		if len(store.List()) != 0 {
+			t.Fatal("ui clear error", store.List())
+		}
+
+		status, _ = post("/todos/"+todo.ID+"/delete", url.Values{})
+		assertStatus(t, http.StatusNotFound, status)
+	})
+}
+
+func TestLive(t *testing.T) {
+	var store = NewStore()
+	defer store.Close()
+	store.CreateTable()
+
+	var hub = NewHub()
+	hub.LockTimeout = 100 * time.Millisecond
+	store = hub.Store(store)
+
+	var server = httptest.NewServer(hub)
+	defer server.Close()
+
+	var dial = func(name string) *websocket.Conn {
+		var url = "ws" + strings.TrimPrefix(server.URL, "http") + "/?name=" + name
+		var conn, _, err = websocket.DefaultDialer.Dial(url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return conn
+	}
+
+	// read skips messages until one matching the given type and test
+	var read = func(conn *websocket.Conn, typ string, test func(LiveMessage) bool) LiveMessage {
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		for {
+			var msg LiveMessage
+			var err = conn.ReadJSON(&msg)
+			if err != nil {
+				t.Fatal("live read error", typ, err)
+			}
+			if msg.Type == typ && (test == nil || test(msg)) {
+				return msg
+			}
+		}
+	}
+
+	var alice = dial("alice")
+	defer alice.Close()
+	read(alice, LiveSnapshot, nil)
+
+	var bob = dial("bob")
+	defer bob.Close()
+	read(bob, LiveSnapshot, nil)
+
+	var presence = read(alice, LivePresence, func(msg LiveMessage) bool { return len(msg.Peers) == 2 })
+	if presence.Peers[1].Name != "bob" {
+		t.Fatal("live presence error", presence)
+	}
+
+	// mutation
+	alice.WriteJSON(LiveMessage{Type: LiveMutate, Ref: "1", Op: OpCreate, Todo: &Todo{Title: "todo 1"}})
+	var ack = read(alice, LiveAck, nil)
+	if ack.Ref != "1" || ack.Todo == nil || len(ack.ID) == 0 {
+		t.Fatal("live ack error", ack)
+	}
+	var saved = read(bob, LiveSaved, nil)
+	if saved.Todo.Title != "todo 1" || saved.ID != ack.ID {
+		t.Fatal("live saved error", saved)
+	}
+
+	// lock
+	var title = "todo 2"
+	alice.WriteJSON(LiveMessage{Type: LiveLock, Ref: "2", ID: ack.ID})
+	read(alice, LiveAck, nil)
+	read(bob, LivePresence, func(msg LiveMessage) bool { return len(msg.Locks) == 1 && msg.Locks[0].Name == "alice" })
+
+	bob.WriteJSON(LiveMessage{Type: LiveMutate, Ref: "3", Op: OpPatch, ID: ack.ID, Patch: &TodoPatch{Title: &title}})
+	var failed = read(bob, LiveError, nil)
+	if failed.Ref != "3" || failed.Status != http.StatusConflict {
+		t.Fatal("live lock error", failed)
+	}
+
+	// lock timeout
+	read(bob, LivePresence, func(msg LiveMessage) bool { return len(msg.Locks) == 0 })
+	bob.WriteJSON(LiveMessage{Type: LiveMutate, Ref: "4", Op: OpPatch, ID: ack.ID, Patch: &TodoPatch{Title: &title}})
+	read(bob, LiveAck, nil)
+	read(alice, LiveSaved, func(msg LiveMessage) bool { return msg.Todo.Title == title })
+
+	// missing id
+	for _, op := range []string{OpUpdate, OpDelete, OpPatch} {
+		bob.WriteJSON(LiveMessage{Type: LiveMutate, Ref: op, Op: op, Todo: &Todo{Title: "todo 3"},
+			Patch: &TodoPatch{Title: &title}})
+		failed = read(bob, LiveError, nil)
+		if failed.Ref != op || failed.Status != http.StatusBadRequest {
+			t.Fatal("live missing id error", op, failed)
+		}
+	}
+
+	// store mutation
+	var err = store.Delete(ack.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read(alice, LiveDeleted, func(msg LiveMessage) bool { return msg.ID == ack.ID })
+
+	// leave
+	bob.Close()
+	read(alice, LivePresence, func(msg LiveMessage) bool { return len(msg.Peers) == 1 })
+
+	// shutdown
+	server.Config.Shutdown(context.Background())
+	alice.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for err == nil {
+		_, _, err = alice.ReadMessage()
+	}
+	if !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+		t.Fatal("live shutdown error", err)
+	}
+}
+
+func TestWebhooks(t *testing.T) {
+	var store = NewStore()
+	defer store.Close()
+	store.CreateTable()
+
+	var ws = store.(WebhookStore)
+	var hooks = NewWebhooks(ws)
+	hooks.Backoff = 10 * time.Millisecond
+	hooks.Allow, _ = ParseNetworks("127.0.0.1")
+	hooks.Start()
+	defer hooks.Close()
+	store = hooks.Store(store)
+
+	var router = NewWebhookRouter()
+	hooks.Register(router)
+	var server = httptest.NewServer(router)
+	defer server.Close()
+
+	// receiver fails the first attempt of each delivery
+	type received struct {
+		header http.Header
+		body   []byte
+	}
+	var deliveries = make(chan received, 10)
+	var attempts = make(map[string]int)
+	var receiver = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body, _ = ioutil.ReadAll(r.Body)
+		var id = r.Header.Get(DeliveryHeader)
+		attempts[id]++
+		if attempts[id] == 1 && r.Header.Get(EventHeader) != EventPing {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		deliveries <- received{r.Header, body}
+	}))
+	defer receiver.Close()
+
+	var do = func(method, path string, body interface{}, v interface{}) int {
+		var b, _ = json.Marshal(body)
+		var req, _ = http.NewRequest(method, server.URL+path, bytes.NewReader(b))
+		var res, err = http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		if v != nil {
+			json.NewDecoder(res.Body).Decode(v)
+		}
+		return res.StatusCode
+	}
+
+	var receive = func() (WebhookEvent, http.Header, []byte) {
+		select {
+		case d := <-deliveries:
+			var event WebhookEvent
+			var err = json.Unmarshal(d.body, &event)
+			if err != nil {
+				t.Fatal("webhook payload error", err)
+			}
+			return event, d.header, d.body
+		case <-time.After(2 * time.Second):
+			t.Fatal("webhook delivery timeout")
+		}
+		return WebhookEvent{}, nil, nil
+	}
+
+	// validation
+	for _, hook := range []Webhook{{URL: "ftp://localhost"}, {URL: receiver.URL, Events: []string{"todo.unknown"}},
+		{URL: "http://169.254.169.254/latest/meta-data"}, {URL: "http://[::1]/"}, {URL: "http://10.0.0.1/"},
+		{URL: "http://100.64.0.1/"}, {URL: "http://0.0.0.1/"}} {
+		if status := do("POST", "/api/webhooks", hook, nil); status != http.StatusBadRequest {
+			t.Fatal("webhook validate status error", hook, status)
+		}
+	}
+
+	// create
+	var hook Webhook
+	var status = do("POST", "/api/webhooks", Webhook{URL: receiver.URL,
+		Events: []string{EventTodoCreated, EventTodoCompleted}}, &hook)
+	if status != http.StatusCreated || len(hook.ID) == 0 || len(hook.Secret) == 0 {
+		t.Fatal("webhook create error", status, hook)
+	}
+
+	var list []Webhook
+	do("GET", "/api/webhooks", nil, &list)
+	if len(list) != 1 || list[0].ID != hook.ID || len(list[0].Secret) != 0 {
+		t.Fatal("webhook list error", list)
+	}
+
+	// signed delivery, after a retry
+	var todo = Todo{Title: "webhook", Status: "active"}
+	var err = store.Save(&todo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var event, header, body = receive()
+	if event.Event != EventTodoCreated || event.Todo == nil || event.Todo.ID != todo.ID {
+		t.Fatal("webhook event error", event)
+	}
+	if header.Get(SignatureHeader) != WebhookSignature(hook.Secret, body) {
+		t.Fatal("webhook signature error", header.Get(SignatureHeader))
+	}
+	if attempts[header.Get(DeliveryHeader)] != 2 {
+		t.Fatal("webhook retry error", attempts)
+	}
+
+	// unsubscribed updates are not delivered
+	todo.Title = "webhook updated"
+	store.Save(&todo)
+	todo.Status = "completed"
+	store.Save(&todo)
+
+	event, _, _ = receive()
+	if event.Event != EventTodoCompleted || event.Todo.Title != "webhook updated" {
+		t.Fatal("webhook completed error", event)
+	}
+
+	// log
+	var logged []Delivery
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		do("GET", "/api/webhooks/"+hook.ID+"/deliveries", nil, &logged)
+		if len(logged) == 2 && logged[0].Status == DeliveryDelivered {
+			break
+		}
+	}
+	if len(logged) != 2 || logged[0].Event != EventTodoCompleted || logged[1].Attempts != 2 ||
+		logged[1].Status != DeliveryDelivered || logged[1].Code != http.StatusOK {
+		t.Fatal("webhook deliveries error", logged)
+	}
+
+	// test
+	var ping Delivery
+	status = do("POST", "/api/webhooks/"+hook.ID+"/test", nil, &ping)
+	if status != http.StatusOK || ping.Event != EventPing || ping.Status != DeliveryDelivered {
+		t.Fatal("webhook test error", status, ping)
+	}
+	event, _, _ = receive()
+	if event.Event != EventPing {
+		t.Fatal("webhook ping error", event)
+	}
+
+	// update keeps the secret
+	hook.Secret = ""
+	hook.Events = nil
+	status = do("PUT", "/api/webhooks/"+hook.ID, hook, &hook)
+	current, _ := ws.FindWebhook(hook.ID)
+	if status != http.StatusOK || len(hook.Events) != 0 || len(current.Secret) == 0 {
+		t.Fatal("webhook update error", status, hook, current)
+	}
+
+	// batch delete
+	_, err = store.Batch([]Operation{{Op: OpDelete, Todo: todo}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	event, _, _ = receive()
+	if event.Event != EventTodoDeleted || event.Todo == nil || event.Todo.ID != todo.ID {
+		t.Fatal("webhook batch delete error", event)
+	}
+
+	// prune the ended deliveries
+	pruned, err := ws.PruneDeliveries(time.Now().Add(-time.Hour).UTC())
+	if err != nil || pruned != 0 {
+		t.Fatal("webhook prune error", pruned, err)
+	}
+	pruned, err = ws.PruneDeliveries(time.Now().Add(time.Hour).UTC())
+	logged, _ = ws.ListDeliveries(hook.ID, 10)
+	if err != nil || pruned == 0 {
+		t.Fatal("webhook prune error", pruned, err)
+	}
+	for _, d := range logged {
+		if d.Status != DeliveryPending {
+			t.Fatal("webhook prune error", logged)
+		}
+	}
+
+	// delete
+	if status = do("DELETE", "/api/webhooks/"+hook.ID, nil, nil); status != http.StatusNoContent {
+		t.Fatal("webhook delete error", status)
+	}
+	if status = do("GET", "/api/webhooks/"+hook.ID, nil, nil); status != http.StatusNotFound {
+		t.Fatal("webhook find error", status)
+	}
+
+	// named private destinations are rejected when dialed
+	var named = Webhook{URL: strings.Replace(receiver.URL, "127.0.0.1", "localhost", 1), Secret: "secret"}
+	_, err = NewWebhooks(ws).send(named, Delivery{ID: "1", Event: EventPing, Payload: "{}"})
+	if err == nil || !strings.Contains(err.Error(), "not allowed") {
+		t.Fatal("webhook destination error", err)
+	}
+
+	// the api is disabled by default
+	var handler = NewAppHandler(store)
+	defer handler.(io.Closer).Close()
+	var rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/api/webhooks", nil))
+	assertStatus(t, http.StatusNotFound, rec.Code)
+}
+
+func TestGraphQL(t *testing.T) {
+	withClientContext(func(client *Client, store Store) {
+		type graphqlResult struct {
+			Data   json.RawMessage
+			Errors []struct {
+				Message    string
+				Extensions map[string]string
+			}
+		}
+
+		var exec = func(query string, vars map[string]interface{}, data interface{}) graphqlResult {
+			var body, _ = json.Marshal(map[string]interface{}{"query": query, "variables": vars})
+			var res, err = http.Post(client.BaseURL+GraphQLPath, "application/json", bytes.NewReader(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer res.Body.Close()
+			assertStatus(t, http.StatusOK, res.StatusCode)
+
+			var result graphqlResult
+			json.NewDecoder(res.Body).Decode(&result)
+			if data != nil && len(result.Errors) == 0 {
+				json.Unmarshal(result.Data, data)
+			}
+			return result
+		}
+
+		// subscription
+		var url = "ws" + strings.TrimPrefix(client.BaseURL, "http") + GraphQLPath
+		var dialer = websocket.Dialer{Subprotocols: []string{GraphQLProtocol}}
+		var conn, _, err = dialer.Dial(url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		var read = func(typ string) graphqlMessage {
+			conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+			var msg graphqlMessage
+			var err = conn.ReadJSON(&msg)
+			if err != nil || msg.Type != typ {
+				t.Fatal("graphql read error", typ, msg.Type, string(msg.Payload), err)
+			}
+			return msg
+		}
+
+		conn.WriteJSON(graphqlMessage{Type: GraphQLConnectionInit})
+		read(GraphQLConnectionAck)
+		var payload, _ = json.Marshal(graphqlRequest{Query: `subscription { todoChanged { type id todo { title } } }`})
+		conn.WriteJSON(graphqlMessage{ID: "1", Type: GraphQLSubscribe, Payload: payload})
+		conn.WriteJSON(graphqlMessage{Type: GraphQLPing})
+		read(GraphQLPong)
+
+		// mutations
+		var created struct {
+			CreateTodo struct{ ID, Title, Status string }
+		}
+		var result = exec(`mutation ($title: String!) { createTodo(input: {title: $title}) { id title status } }`,
+			map[string]interface{}{"title": "graphql"}, &created)
+		if len(result.Errors) != 0 || created.CreateTodo.Status != "active" {
+			t.Fatal("graphql create error", result.Errors, created)
+		}
+		var id = created.CreateTodo.ID
+
+		result = exec(`mutation { createTodo(input: {title: " "}) { id } }`, nil, nil)
+		if len(result.Errors) != 1 || result.Errors[0].Extensions["code"] != "BAD_REQUEST" {
+			t.Fatal("graphql validate error", result.Errors)
+		}
+
+		var change struct {
+			Data struct {
+				TodoChanged struct {
+					Type, ID string
+					Todo     struct{ Title string }
+				}
+			}
+		}
+		json.Unmarshal(read(GraphQLNext).Payload, &change)
+		if change.Data.TodoChanged.Type != LiveSaved || change.Data.TodoChanged.ID != id ||
+			change.Data.TodoChanged.Todo.Title != "graphql" {
+			t.Fatal("graphql subscription error", change)
+		}
+
+		exec(`mutation { createTodo(input: {title: "other", status: completed}) { id } }`, nil, nil)
+		read(GraphQLNext)
+
+		var updated struct{ UpdateTodo struct{ Completed bool } }
+		result = exec(`mutation ($id: ID!) { updateTodo(id: $id, input: {status: completed}) { completed } }`,
+			map[string]interface{}{"id": id}, &updated)
+		if len(result.Errors) != 0 || !updated.UpdateTodo.Completed {
+			t.Fatal("graphql update error", result.Errors, updated)
+		}
+		read(GraphQLNext)
+
+		result = exec(`mutation { updateTodo(id: "unknown", input: {title: "x"}) { id } }`, nil, nil)
+		if len(result.Errors) != 1 || result.Errors[0].Extensions["code"] != "NOT_FOUND" {
+			t.Fatal("graphql update not found error", result.Errors)
+		}
+
+		// queries
+		var query struct {
+			Todo    *struct{ Title string }
+			Missing *struct{ Title string }
+			Todos   struct {
+				Items   []struct{ Title string }
+				Total   int
+				HasNext bool
+			}
+			Stats struct{ Total, Active, Completed int }
+		}
+		result = exec(`query ($id: ID!) {
+			todo(id: $id) { title }
+			missing: todo(id: "unknown") { title }
+			todos(status: completed, limit: 1) { items { title } total hasNext }
+			stats { total active completed }
+		}`, map[string]interface{}{"id": id}, &query)
+		if len(result.Errors) != 0 || query.Todo == nil || query.Todo.Title != "graphql" || query.Missing != nil {
+			t.Fatal("graphql todo error", result.Errors, query)
+		}
+		if len(query.Todos.Items) != 1 || query.Todos.Total != 2 || !query.Todos.HasNext {
+			t.Fatal("graphql todos error", query.Todos)
+		}
+		if query.Stats.Total != 2 || query.Stats.Completed != 2 || query.Stats.Active != 0 {
+			t.Fatal("graphql stats error", query.Stats)
+		}
+
+		var cleared struct{ ClearTodos int }
+		exec(`mutation { clearTodos(status: completed) }`, nil, &cleared)
+		if cleared.ClearTodos != 2 {
+			t.Fatal("graphql clear error", cleared)
+		}
+		json.Unmarshal(read(GraphQLNext).Payload, &change)
+		if change.Data.TodoChanged.Type != LiveReset {
+			t.Fatal("graphql reset error", change)
+		}
+
+		// complete
+		conn.WriteJSON(graphqlMessage{ID: "1", Type: GraphQLComplete})
+		conn.WriteJSON(graphqlMessage{Type: GraphQLPing})
+		read(GraphQLPong)
+
+		// bad request
+		var res, _ = http.Post(client.BaseURL+GraphQLPath, "application/json", strings.NewReader(`{}`))
+		assertStatus(t, http.StatusBadRequest, res.StatusCode)
+	})
+}
+
+func TestGRPC(t *testing.T) {
+	var store = NewStore()
+	defer store.Close()
+	store.CreateTable()
+
+	var hub = NewHub()
+	store = hub.Store(store)
+
+	var server = NewGRPCServer(NewTodoService(store, hub))
+	var ln, err = net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var served = make(chan error, 1)
+	go func() {
+		served <- server.Serve(ln)
+	}()
+
+	conn, err := grpc.NewClient(ln.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	var client = todopb.NewTodoServiceClient(conn)
+	var ctx = context.Background()
+
+	// watch
+	watch, err := client.Watch(ctx, &todopb.WatchRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = watch.Header()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// create
+	created, err := client.Create(ctx, &todopb.CreateRequest{Todo: &todopb.Todo{Title: "grpc"}})
+	if err != nil || created.Status != "active" || len(created.Id) == 0 {
+		t.Fatal("grpc create error", created, err)
+	}
+
+	_, err = client.Create(ctx, &todopb.CreateRequest{Todo: &todopb.Todo{Title: " "}})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatal("grpc validate error", err)
+	}
+
+	change, err := watch.Recv()
+	if err != nil || change.Type != todopb.Change_SAVED || change.Id != created.Id {
+		t.Fatal("grpc watch error", change, err)
+	}
+
+	// update, find
+	created.Status = "completed"
+	updated, err := client.Update(ctx, &todopb.UpdateRequest{Todo: created})
+	if err != nil || updated.Status != "completed" {
+		t.Fatal("grpc update error", updated, err)
+	}
+
+	found, err := client.Find(ctx, &todopb.FindRequest{Id: created.Id})
+	if err != nil || !TodoFromMessage(found).Equal(TodoFromMessage(updated)) {
+		t.Fatal("grpc find error", found, err)
+	}
+
+	_, err = client.Find(ctx, &todopb.FindRequest{Id: "unknown"})
+	if status.Code(err) != codes.NotFound {
+		t.Fatal("grpc find not found error", err)
+	}
+
+	// status
+	client.Create(ctx, &todopb.CreateRequest{Todo: &todopb.Todo{Title: "grpc 2"}})
+
+	list, err := client.List(ctx, &todopb.ListRequest{})
+	if err != nil || len(list.Todos) != 2 {
+		t.Fatal("grpc list error", list, err)
+	}
+	filtered, err := client.Filter(ctx, &todopb.StatusRequest{Status: "active"})
+	if err != nil || len(filtered.Todos) != 1 || filtered.Todos[0].Title != "grpc 2" {
+		t.Fatal("grpc filter error", filtered, err)
+	}
+
+	toggled, err := client.Toggle(ctx, &todopb.StatusRequest{Status: "completed"})
+	if err != nil || toggled.Count != 1 {
+		t.Fatal("grpc toggle error", toggled, err)
+	}
+	cleared, err := client.Clear(ctx, &todopb.StatusRequest{Status: "completed"})
+	if err != nil || cleared.Count != 2 {
+		t.Fatal("grpc clear error", cleared, err)
+	}
+
+	_, err = client.Delete(ctx, &todopb.DeleteRequest{Id: created.Id})
+	if status.Code(err) != codes.NotFound {
+		t.Fatal("grpc delete error", err)
+	}
+
+	for change.Type != todopb.Change_RESET || len(change.Todos) != 0 {
+		change, err = watch.Recv()
+		if err != nil {
+			t.Fatal("grpc watch reset error", err)
+		}
+	}
+
+	// shutdown ends the watch
+	err = server.Shutdown(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = watch.Recv()
+	if status.Code(err) != codes.Unavailable {
+		t.Fatal("grpc watch shutdown error", err)
+	}
+	if err = <-served; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOpenAPI(t *testing.T) {
+	withClientContext(func(client *Client, store Store) {
+		var res, err = http.Get(client.BaseURL + "/api/openapi.json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		assertStatus(t, http.StatusOK, res.StatusCode)
+
+		var spec struct {
+			Paths map[string]map[string]struct {
+				OperationID string `json:"operationId"`
+			}
+		}
+		err = json.NewDecoder(res.Body).Decode(&spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// every route is in the spec
+		var routes = 0
+		NewRouter().Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+			var tpl, _ = route.GetPathTemplate()
+			var path = pathVar.ReplaceAllString(tpl, "{$1}")
+			var methods, _ = route.GetMethods()
+
+			for _, method := range methods {
+				var op = spec.Paths[path][strings.ToLower(method)]
+				if op.OperationID != route.GetName() {
+					t.Error("openapi route error", method, path, route.GetName(), op.OperationID)
+				}
+				routes++
+			}
+			return nil
+		})
+
+		// and nothing else
+		var operations = 0
+		for _, item := range spec.Paths {
+			operations += len(item)
+		}
+		if operations != routes {
+			t.Fatal("openapi operations error", operations, routes)
+		}
+	})
+
+	var router = NewRouter()
+	router.Methods("GET").Path("/api/todos/undocumented").Name("Todo.Undocumented")
+	if _, err := OpenAPI(router, APIv1); err == nil {
+		t.Fatal("openapi undocumented route error")
+	}
+}
+
+func TestClientPatch(t *testing.T) {
+	withClientContext(func(client *Client, store Store) {
+		var todo = NewTodo("todo 1")
+		if err := client.Create(todo); err != nil {
+			t.Fatal(err)
+		}
+
+		// merge patch
+		var status = "completed"
+		var patched, err = client.Patch(todo.ID, TodoPatch{Status: &status})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if patched.Title != "todo 1" || patched.Status != status {
+			t.Fatal("merge patch error", patched)
+		}
+
+		var patch = func(ctype, body string) int {
+			var req, _ = http.NewRequest("PATCH", client.BaseURL+"/api/todos/"+todo.ID, strings.NewReader(body))
+			req.Header.Set("Content-Type", ctype)
+			var res, err = http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+			return res.StatusCode
+		}
+
+		assertStatus(t, http.StatusBadRequest, patch(MergePatchType, `{"title": null}`))
+		assertStatus(t, http.StatusBadRequest, patch(MergePatchType, `{"created": "2020-01-01T00:00:00Z"}`))
+		assertStatus(t, http.StatusBadRequest, patch(MergePatchType, `{"status": "done"}`))
+		assertStatus(t, http.StatusBadRequest, patch("text/plain", `{}`))
+
+		// json patch
+		assertStatus(t, http.StatusConflict, patch(JSONPatchType,
+			`[{"op": "test", "path": "/status", "value": "active"}]`))
+		assertStatus(t, http.StatusBadRequest, patch(JSONPatchType,
+			`[{"op": "test", "path": "/status", "value": 1}]`))
+		assertStatus(t, http.StatusOK, patch(JSONPatchType,
+			`[{"op": "test", "path": "/status", "value": "completed"},
+			  {"op": "replace", "path": "/title", "value": "todo 2"}]`))
+
+		found, _ := client.Find(todo.ID)
+		if found.Title != "todo 2" || found.Status != status {
+			t.Fatal("json patch error", found)
+		}
+
+		_, err = client.Patch("0", TodoPatch{Status: &status})
+		if !errors.Is(err, ErrNotFound) {
+			t.Fatal("patch error", err)
+		}
+	})
+}
+
+func TestSync(t *testing.T) {
+	withClientContext(func(client *Client, store Store) {
+		var dir, err = ioutil.TempDir("", "todo")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		var local = OpenStore("sqlite3", filepath.Join(dir, "replica.sqlite"))
+		defer local.Close()
+		local.CreateTable()
+		var state = filepath.Join(dir, "sync.json")
+
+		var todo1, todo2 = NewTodo("todo 1"), NewTodo("todo 2")
+		client.Create(todo1)
+		client.Create(todo2)
+
+		// first sync
+		syncer, err := NewSyncer(client, local, state)
+		if err != nil {
+			t.Fatal(err)
+		}
+		report, err := syncer.Sync()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.Pulled != 2 || len(syncer.List()) != 2 {
+			t.Fatal("sync pull error", report, syncer.List())
+		}
+
+		// offline changes
+		var down = httptest.NewServer(http.NotFoundHandler())
+		down.Close()
+		offline, err := NewSyncer(NewClient(down.URL, WithRetries(0, 0)), local, state)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var todo3 = NewTodo("todo 3")
+		err = offline.Save(todo3)
+		if err != nil || !strings.HasPrefix(todo3.ID, "local-") {
+			t.Fatal("sync create error", err, todo3)
+		}
+		var title, status = "todo 1 local", "completed"
+		offline.Patch(todo1.ID, TodoPatch{Title: &title})
+		offline.Patch(todo2.ID, TodoPatch{Status: &status})
+
+		_, err = offline.Sync()
+		if err == nil || len(offline.Pending()) != 3 {
+			t.Fatal("sync offline error", err, offline.Pending())
+		}
+		if todos := offline.Filter("completed"); len(todos) != 1 || todos[0].ID != todo2.ID {
+			t.Fatal("sync filter error", todos)
+		}
+
+		// server changes
+		var serverTitle = "todo 1 server"
+		client.Patch(todo1.ID, TodoPatch{Title: &serverTitle})
+		var title2 = "todo 2 server"
+		client.Patch(todo2.ID, TodoPatch{Title: &title2})
+
+		// reconnect
+		syncer, err = NewSyncer(client, local, state)
+		if err != nil {
+			t.Fatal(err)
+		}
+		report, err = syncer.Sync()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.Pushed != 2 || len(report.Conflicts) != 1 || len(syncer.Pending()) != 0 {
+			t.Fatal("sync push error", report, syncer.Pending())
+		}
+		var conflict = report.Conflicts[0]
+		if conflict.ID != todo1.ID || conflict.Field != "title" || conflict.Server.Title != serverTitle {
+			t.Fatal("sync conflict error", conflict)
+		}
+
+		var found, _ = client.Find(todo2.ID)
+		if found.Title != title2 || found.Status != status {
+			t.Fatal("sync merge error", found)
+		}
+		var todos, _ = client.List()
+		var synced = syncer.List()
+		if len(todos) != 3 || len(synced) != 3 {
+			t.Fatal("sync list error", todos, synced)
+		}
+		for _, todo := range todos {
+			var i = indexTodo(synced, todo.ID)
+			if i < 0 || !synced[i].Equal(todo) {
+				t.Fatal("sync replica error", todo, synced)
+			}
+		}
+
+		// bulk changes reset the replica
+		client.Clear("completed")
+		_, err = syncer.Sync()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = syncer.Find(todo2.ID); err == nil || len(syncer.List()) != 2 {
+			t.Fatal("sync reset error", err, syncer.List())
+		}
+
+		// lost response of a creation, pushed again with the same key
+		var lost = 0
+		var lossy = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			var res, err = http.DefaultTransport.RoundTrip(r)
+			if err == nil && r.Method == "POST" && lost == 0 {
+				lost++
+				res.Body.Close()
+				return nil, errors.New("connection reset")
+			}
+			return res, err
+		})
+		lossySyncer, err := NewSyncer(NewClient(client.BaseURL, WithRetries(0, 0),
+			WithHTTPClient(&http.Client{Transport: lossy})), local, state)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lossySyncer.Save(NewTodo("todo 4"))
+		if _, err = lossySyncer.Sync(); err == nil || len(lossySyncer.Pending()) != 1 {
+			t.Fatal("sync lost error", err, lossySyncer.Pending())
+		}
+		if _, err = lossySyncer.Sync(); err != nil {
+			t.Fatal(err)
+		}
+		if todos, _ := client.Filter("active"); len(todos) != 3 || len(lossySyncer.List()) != 3 {
+			t.Fatal("sync idempotency error", todos, lossySyncer.List())
+		}
+
+		// reads while syncing with a hung server
+		var hung = make(chan struct{})
+		var slow = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-hung
+		}))
+		defer slow.Close()
+		slowSyncer, err := NewSyncer(NewClient(slow.URL, WithRetries(0, 0)), local, state)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var hungSync = make(chan error, 1)
+		go func() {
+			var _, err = slowSyncer.Sync()
+			hungSync <- err
+		}()
+		time.Sleep(50 * time.Millisecond)
+
+		var read = make(chan Todos, 1)
+		go func() { read <- slowSyncer.List() }()
+		select {
+		case todos := <-read:
+			if len(todos) != 3 {
+				t.Fatal("sync list error", todos)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("sync lock error")
+		}
+		close(hung)
+		if err = <-hungSync; err == nil {
+			t.Fatal("sync hung error")
+		}
+
+		// invalid cursor
+		_, err = client.Changes("cursor")
+		if !errors.Is(err, ErrValidation) {
+			t.Fatal("changes error", err)
+		}
+	})
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (fn roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return fn(r)
+}
+
+func TestClientVersions(t *testing.T) {
+	withClientContext(func(client *Client, store Store) {
+		for _, title := range []string{"todo 1", "todo 2", "todo 3"} {
+			client.Create(NewTodo(title))
+		}
+
+		for _, version := range []string{APIv1, APIv2} {
+			var vclient = NewClientVersion(client.BaseURL, version)
+			var todos, err = vclient.List()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(todos) != 3 {
+				t.Fatal("todos list error", version, todos)
+			}
+		}
+
+		var get = func(path, accept string) *http.Response {
+			var req, _ = http.NewRequest("GET", client.BaseURL+path, nil)
+			if len(accept) != 0 {
+				req.Header.Set("Accept", accept)
+			}
+			var res, err = http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return res
+		}
+
+		// v1 is deprecated
+		var res = get("/api/v1/todos", "")
+		res.Body.Close()
+		if res.Header.Get("Deprecation") != "true" || res.Header.Get(VersionHeader) != APIv1 {
+			t.Fatal("v1 headers error", res.Header)
+		}
+
+		// v2 pages, negotiated
+		res = get("/api/todos?limit=2", "application/vnd.todo.v2+json")
+		var page Page
+		json.NewDecoder(res.Body).Decode(&page)
+		res.Body.Close()
+
+		if res.Header.Get(VersionHeader) != APIv2 || len(res.Header.Get("Deprecation")) != 0 {
+			t.Fatal("v2 headers error", res.Header)
+		}
+		if page.Total != 3 || len(page.Items) != 2 || page.Next != "/api/todos?limit=2&offset=2" {
+			t.Fatal("v2 page error", page)
+		}
+
+		// v2 problems
+		res = get("/api/v2/todos/0", "")
+		var problem Problem
+		json.NewDecoder(res.Body).Decode(&problem)
+		res.Body.Close()
+
+		if res.Header.Get("Content-Type") != ProblemType || problem.Status != http.StatusNotFound {
+			t.Fatal("v2 problem error", res.Header, problem)
+		}
+
+		res = get("/api/todos", "application/vnd.todo.v3+json")
+		res.Body.Close()
+		assertStatus(t, http.StatusNotAcceptable, res.StatusCode)
+	})
+}
+
+func TestServerShutdown(t *testing.T) {
+	var started = make(chan bool)
+	var handler = func(w http.ResponseWriter, r *http.Request) {
+		started <- true
+		time.Sleep(100 * time.Millisecond)
+		w.WriteHeader(http.StatusNoContent)
+	}
+
+	var config = DefaultConfig()
+	config.ShutdownTimeout = time.Second
+
+	var server = NewServer(config, http.HandlerFunc(handler))
+	var closed = false
+	server.AfterShutdown(func() { closed = true })
+
+	var ln, err = net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var stop = make(chan os.Signal, 1)
+	var done = make(chan error, 1)
+	go func() {
+		done <- server.serve(ln, stop)
+	}()
+
+	// in-flight request
+	var status = make(chan int, 1)
+	go func() {
+		var res, err = http.Get("http://" + ln.Addr().String())
+		if err != nil {
+			status <- 0
+			return
+		}
+		res.Body.Close()
+		status <- res.StatusCode
+	}()
+
+	<-started
+	stop <- os.Interrupt
+
+	err = <-done
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertStatus(t, http.StatusNoContent, <-status)
+
+	if !closed {
+		t.Fatal("after shutdown error")
+	}
+}
+
+// writeCert writes a self-signed certificate of 127.0.0.1 and its key
+// to dir, and returns their files and the pool of the certificate.
+func writeCert(t *testing.T, dir string) (string, string, *x509.CertPool) {
+	var key, err = ecdsa.GenerateKey(elliptic.P256(), crand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var template = &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "todo"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(crand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	var certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	ioutil.WriteFile(certFile, certPEM, 0600)
+	ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+
+	var pool = x509.NewCertPool()
+	pool.AppendCertsFromPEM(certPEM)
+	return certFile, keyFile, pool
+}
+
+func TestServerGRPCTLS(t *testing.T) {
+	var dir, err = ioutil.TempDir("", "todo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var store = NewStore()
+	defer store.Close()
+	store.CreateTable()
+
+	var config = DefaultConfig()
+	var pool *x509.CertPool
+	config.TLSCert, config.TLSKey, pool = writeCert(t, dir)
+	config.GRPCAddr = "127.0.0.1:0"
+	if err = config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	handler, err := NewConfigHandler(store, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var server = NewServer(config, handler)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.grpcLn, err = net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var stop = make(chan os.Signal, 1)
+	var done = make(chan error, 1)
+	go func() {
+		done <- server.serve(ln, stop)
+	}()
+	defer func() {
+		stop <- os.Interrupt
+		<-done
+	}()
+
+	var list = func(creds credentials.TransportCredentials) error {
+		var conn, err = grpc.NewClient(server.grpcLn.Addr().String(), grpc.WithTransportCredentials(creds))
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+
+		var ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_, err = todopb.NewTodoServiceClient(conn).List(ctx, &todopb.ListRequest{})
+		return err
+	}
+
+	if err = list(credentials.NewTLS(&tls.Config{RootCAs: pool})); err != nil {
+		t.Fatal("grpc tls error", err)
+	}
+	if err = list(insecure.NewCredentials()); err == nil {
+		t.Fatal("grpc plaintext error")
+	}
+}
+
+func BenchmarkClientR(b *testing.B) {
+	withClientContext(func(client *Client, store Store) {
+		var ids = saveTodos(b, store)
+
+		rand.Seed(time.Now().UnixNano())
+		b.ResetTimer()
+
+		for i := 0; i < b.N; i++ {
+			var j = rand.Intn(b.N)
+			var id = ids[j]
+
+			var _, err = client.Find(id)
+			if err != nil {
+				b.Error(err)
 			}
 		}
 	})
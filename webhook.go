@@ -0,0 +1,802 @@
+package todo
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Webhook events.
+const (
+	EventTodoCreated   = "todo.created"
+	EventTodoUpdated   = "todo.updated"
+	EventTodoCompleted = "todo.completed"
+	EventTodoDeleted   = "todo.deleted"
+	EventTodosCleared  = "todos.cleared"
+	EventTodosToggled  = "todos.toggled"
+	EventTodosImported = "todos.imported"
+	EventTodosChanged  = "todos.changed"
+	EventPing          = "ping"
+)
+
+var webhookEvents = []string{
+	EventTodoCreated, EventTodoUpdated, EventTodoCompleted, EventTodoDeleted,
+	EventTodosCleared, EventTodosToggled, EventTodosImported, EventTodosChanged, EventPing,
+}
+
+// Delivery statuses.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// Webhook delivery headers. The signature is the hex encoded
+// HMAC-SHA256 of the body with the webhook secret, as "sha256=...".
+const (
+	EventHeader     = "X-Todo-Event"
+	DeliveryHeader  = "X-Todo-Delivery"
+	SignatureHeader = "X-Todo-Signature"
+)
+
+const (
+	RouteWebhookList       = "Webhook.List"
+	RouteWebhookCreate     = "Webhook.Create"
+	RouteWebhookFind       = "Webhook.Find"
+	RouteWebhookUpdate     = "Webhook.Update"
+	RouteWebhookDelete     = "Webhook.Delete"
+	RouteWebhookDeliveries = "Webhook.Deliveries"
+	RouteWebhookTest       = "Webhook.Test"
+)
+
+// webhookBatch is the number of pending deliveries read at once.
+const webhookBatch = 20
+
+// maxWebhookResponse is the size of the delivery responses read.
+const maxWebhookResponse = 64 << 10
+
+// reservedNetworks are the "this network", shared address space (carrier-grade
+// NAT), IETF protocol, benchmarking and reserved networks, which the net.IP
+// methods do not report.
+var reservedNetworks, _ = ParseNetworks("0.0.0.0/8, 100.64.0.0/10, 192.0.0.0/24, 198.18.0.0/15, 240.0.0.0/4")
+
+// Webhook defines a subscription to the todo events.
+// No events subscribe to all of them.
+type Webhook struct {
+	ID       string    `json:"id" gorethink:"id,omitempty"`
+	URL      string    `json:"url"`
+	Secret   string    `json:"secret,omitempty"`
+	Events   []string  `json:"events"`
+	Disabled bool      `json:"disabled"`
+	Created  time.Time `json:"created"`
+}
+
+// Delivery defines the delivery of an event to a webhook, retried
+// until delivered or failed. Code and Error are of the last attempt.
+type Delivery struct {
+	ID       string    `json:"id" gorethink:"id,omitempty"`
+	Webhook  string    `json:"webhook"`
+	Event    string    `json:"event"`
+	Payload  string    `json:"payload"`
+	Status   string    `json:"status"`
+	Attempts int       `json:"attempts"`
+	Next     time.Time `json:"next"`
+	Code     int       `json:"code"`
+	Error    string    `json:"error"`
+	Created  time.Time `json:"created"`
+	Updated  time.Time `json:"updated"`
+}
+
+// WebhookEvent is the payload of the deliveries.
+type WebhookEvent struct {
+	Event   string    `json:"event"`
+	Created time.Time `json:"created"`
+	Todo    *Todo     `json:"todo,omitempty"`
+	Status  string    `json:"status,omitempty"`
+	Count   int64     `json:"count,omitempty"`
+}
+
+// WebhookStore manages the webhooks and their deliveries storage.
+type WebhookStore interface {
+	ListWebhooks() ([]Webhook, error)
+	FindWebhook(id string) (Webhook, error)
+	SaveWebhook(w *Webhook) error
+	DeleteWebhook(id string) error
+	// deliveries
+	SaveDelivery(d *Delivery) error
+	PendingDeliveries(limit int) ([]Delivery, error)
+	ListDeliveries(webhook string, limit int) ([]Delivery, error)
+	PruneDeliveries(before time.Time) (int64, error)
+}
+
+// Subscribed returns whether the webhook subscribes to the given event.
+func (w Webhook) Subscribed(event string) bool {
+	if w.Disabled {
+		return false
+	}
+	return len(w.Events) == 0 || contains(w.Events, event)
+}
+
+// Validate checks the url and the events of the webhook.
+func (w Webhook) Validate() error {
+	var u, err = url.Parse(w.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+		return fmt.Errorf("webhook: url %q is not an http(s) url", w.URL)
+	}
+
+	for _, event := range w.Events {
+		if !contains(webhookEvents, event) {
+			return fmt.Errorf("webhook: unknown event %q", event)
+		}
+	}
+	return nil
+}
+
+// ParseNetworks parses comma separated networks, in CIDR notation or as
+// single IP addresses.
+func ParseNetworks(s string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if len(field) == 0 {
+			continue
+		}
+
+		if ip := net.ParseIP(field); ip != nil {
+			var bits = 8 * len(ip)
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		var _, network, err = net.ParseCIDR(field)
+		if err != nil {
+			return nil, fmt.Errorf("webhook: network %q is not an ip or a cidr", field)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// WebhookSignature returns the signature of the body with the given secret.
+func WebhookSignature(secret string, body []byte) string {
+	var mac = hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Webhooks delivers the events of the todos to the webhooks.
+//
+// The deliveries are queued in the WebhookStore, and sent by a single
+// worker until delivered, retrying failed attempts with an exponential
+// backoff, up to MaxAttempts. Pending deliveries are resumed on start.
+// The delivered and failed deliveries are kept for Retention.
+//
+// The loopback, link-local, private, shared, reserved and unspecified
+// destinations are rejected, when registered and when dialed, unless in
+// the Allow networks.
+type Webhooks struct {
+	Client      *http.Client
+	MaxAttempts int
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+	Retention   time.Duration
+	Allow       []*net.IPNet
+
+	store  WebhookStore
+	pruned time.Time
+	wake   chan struct{}
+	done   chan struct{}
+	ended  chan struct{}
+	once   sync.Once
+}
+
+// NewWebhooks returns new Webhooks of the given store, not started.
+func NewWebhooks(store WebhookStore) *Webhooks {
+	var w = &Webhooks{
+		MaxAttempts: 8,
+		Backoff:     time.Second,
+		MaxBackoff:  time.Hour,
+		Retention:   7 * 24 * time.Hour,
+
+		store: store,
+		wake:  make(chan struct{}, 1),
+		done:  make(chan struct{}),
+		ended: make(chan struct{}),
+	}
+
+	// the destinations are checked once resolved, as dialed
+	var dialer = &net.Dialer{Timeout: 10 * time.Second, Control: w.control}
+	w.Client = &http.Client{
+		Timeout:   10 * time.Second,
+		Transport: &http.Transport{DialContext: dialer.DialContext},
+	}
+	return w
+}
+
+// allowed reports whether the given destination is public, or allowed.
+func (w *Webhooks) allowed(ip net.IP) bool {
+	for _, network := range w.Allow {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	for _, network := range reservedNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast())
+}
+
+// control rejects the connections to the destinations not allowed.
+func (w *Webhooks) control(network, address string, c syscall.RawConn) error {
+	var host, _, err = net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !w.allowed(ip) {
+		return fmt.Errorf("webhook: destination %s is not allowed", host)
+	}
+	return nil
+}
+
+// validate checks the hook, and its destination if an ip address.
+// The destinations named are checked when dialed.
+func (w *Webhooks) validate(hook Webhook) error {
+	var err = hook.Validate()
+	if err != nil {
+		return err
+	}
+
+	var u, _ = url.Parse(hook.URL)
+	if ip := net.ParseIP(u.Hostname()); ip != nil && !w.allowed(ip) {
+		return fmt.Errorf("webhook: destination %s is not allowed", ip)
+	}
+	return nil
+}
+
+// Start starts the delivery worker.
+func (w *Webhooks) Start() {
+	go w.run()
+}
+
+// Close stops the delivery worker, after the current delivery.
+func (w *Webhooks) Close() error {
+	w.once.Do(func() {
+		close(w.done)
+		<-w.ended
+	})
+	return nil
+}
+
+// Publish queues the deliveries of the given event
+// to the subscribed webhooks.
+func (w *Webhooks) Publish(event WebhookEvent) error {
+	if event.Created.IsZero() {
+		event.Created = time.Now().UTC()
+	}
+
+	var hooks, err = w.store.ListWebhooks()
+	if err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	var queued = false
+	for _, hook := range hooks {
+		if !hook.Subscribed(event.Event) {
+			continue
+		}
+
+		var d = newDelivery(hook.ID, event.Event, payload)
+		err = w.store.SaveDelivery(&d)
+		if err != nil {
+			return err
+		}
+		queued = true
+	}
+
+	if queued {
+		select {
+		case w.wake <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+func newDelivery(webhook, event string, payload []byte) Delivery {
+	var now = time.Now().UTC()
+	return Delivery{
+		Webhook: webhook,
+		Event:   event,
+		Payload: string(payload),
+		Status:  DeliveryPending,
+		Next:    now,
+		Created: now,
+		Updated: now,
+	}
+}
+
+// run sends the due deliveries, then waits for new ones
+// or the next retry.
+func (w *Webhooks) run() {
+	defer close(w.ended)
+
+	var timer = time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-w.wake:
+		case <-timer.C:
+		}
+
+		var next = w.dispatch()
+		w.prune()
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		if !next.IsZero() {
+			timer.Reset(time.Until(next))
+		}
+	}
+}
+
+// dispatch sends the due deliveries, and returns the time of
+// the next pending one, if any.
+func (w *Webhooks) dispatch() time.Time {
+	for {
+		var pending, err = w.store.PendingDeliveries(webhookBatch)
+		if err != nil {
+			slog.Error("webhook: pending deliveries", "err", err)
+			return time.Now().Add(w.Backoff)
+		}
+		if len(pending) == 0 {
+			return time.Time{}
+		}
+
+		for _, d := range pending {
+			if d.Next.After(time.Now()) {
+				return d.Next
+			}
+
+			select {
+			case <-w.done:
+				return time.Time{}
+			default:
+			}
+
+			err = w.deliver(&d)
+			if err != nil {
+				slog.Error("webhook: save delivery", "err", err, "delivery", d.ID)
+				return time.Now().Add(w.Backoff)
+			}
+		}
+	}
+}
+
+// prune deletes the deliveries ended before the retention, once an hour.
+func (w *Webhooks) prune() {
+	var now = time.Now()
+	if now.Sub(w.pruned) < time.Hour {
+		return
+	}
+	w.pruned = now
+
+	var count, err = w.store.PruneDeliveries(now.Add(-w.Retention).UTC())
+	if err != nil {
+		slog.Error("webhook: prune deliveries", "err", err)
+		return
+	}
+	if count != 0 {
+		slog.Info("webhook: pruned deliveries", "count", count)
+	}
+}
+
+// deliver attempts the given delivery, and saves its outcome.
+func (w *Webhooks) deliver(d *Delivery) error {
+	var hook, err = w.store.FindWebhook(d.Webhook)
+	if err != nil {
+		if _, ok := err.(NotFound); !ok {
+			return err
+		}
+	}
+
+	d.Attempts++
+	d.Updated = time.Now().UTC()
+
+	if err != nil || hook.Disabled {
+		d.Status, d.Error = DeliveryFailed, "webhook: deleted or disabled"
+		return w.store.SaveDelivery(d)
+	}
+
+	d.Code, err = w.send(hook, *d)
+	switch {
+	case err == nil:
+		d.Status, d.Error = DeliveryDelivered, ""
+	case d.Attempts >= w.MaxAttempts:
+		d.Status, d.Error = DeliveryFailed, err.Error()
+	default:
+		d.Error = err.Error()
+		d.Next = d.Updated.Add(w.backoff(d.Attempts))
+	}
+
+	slog.Info("webhook: delivery", "delivery", d.ID, "webhook", hook.ID,
+		"event", d.Event, "attempt", d.Attempts, "code", d.Code, "status", d.Status)
+
+	return w.store.SaveDelivery(d)
+}
+
+// backoff returns the delay before the retry of the given attempt.
+func (w *Webhooks) backoff(attempt int) time.Duration {
+	var delay = w.Backoff
+	for i := 1; i < attempt && delay < w.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > w.MaxBackoff {
+		delay = w.MaxBackoff
+	}
+	return delay
+}
+
+// send posts the payload of the delivery, signed with the webhook secret,
+// and returns the response status.
+func (w *Webhooks) send(hook Webhook, d Delivery) (int, error) {
+	var body = []byte(d.Payload)
+
+	var req, err = http.NewRequest("POST", hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "todo-webhooks/"+Version)
+	req.Header.Set(EventHeader, d.Event)
+	req.Header.Set(DeliveryHeader, d.ID)
+	req.Header.Set(SignatureHeader, WebhookSignature(hook.Secret, body))
+
+	res, err := w.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	io.Copy(ioutil.Discard, io.LimitReader(res.Body, maxWebhookResponse))
+	res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return res.StatusCode, fmt.Errorf("webhook: response status %d", res.StatusCode)
+	}
+	return res.StatusCode, nil
+}
+
+// NewWebhookRouter creates a new mux.Router and defines HTTP methods
+// with URL paths starting with "/api/webhooks".
+func NewWebhookRouter() *mux.Router {
+	var router = mux.NewRouter()
+	var prefix = "/api/webhooks"
+
+	router.Methods("GET").Path(prefix).Name(RouteWebhookList)
+	router.Methods("POST").Path(prefix).Name(RouteWebhookCreate)
+
+	router.Methods("GET").Path(prefix + "/{id:[A-Za-z0-9-]+}").Name(RouteWebhookFind)
+	router.Methods("PUT").Path(prefix + "/{id:[A-Za-z0-9-]+}").Name(RouteWebhookUpdate)
+	router.Methods("DELETE").Path(prefix + "/{id:[A-Za-z0-9-]+}").Name(RouteWebhookDelete)
+	router.Methods("GET").Path(prefix + "/{id:[A-Za-z0-9-]+}/deliveries").Name(RouteWebhookDeliveries)
+	router.Methods("POST").Path(prefix + "/{id:[A-Za-z0-9-]+}/test").Name(RouteWebhookTest)
+
+	return router
+}
+
+// Register sets the handlers to the routes.
+func (w *Webhooks) Register(router *mux.Router) {
+	router.Get(RouteWebhookList).Handler(ErrorFunc(w.List))
+	router.Get(RouteWebhookCreate).Handler(ErrorFunc(w.Create))
+	router.Get(RouteWebhookFind).Handler(ErrorFunc(w.Find))
+	router.Get(RouteWebhookUpdate).Handler(ErrorFunc(w.Update))
+	router.Get(RouteWebhookDelete).Handler(ErrorFunc(w.Delete))
+	router.Get(RouteWebhookDeliveries).Handler(ErrorFunc(w.Deliveries))
+	router.Get(RouteWebhookTest).Handler(ErrorFunc(w.Test))
+}
+
+// List handles webhooks listing. The secrets are not listed.
+func (w *Webhooks) List(rw http.ResponseWriter, r *http.Request) error {
+	var hooks, err = w.store.ListWebhooks()
+	if err != nil {
+		return err // 500
+	}
+
+	for i := range hooks {
+		hooks[i].Secret = ""
+	}
+	return writeJSON(rw, hooks, http.StatusOK) // 200
+}
+
+// Create handles webhook creation. A random secret is generated
+// if none is given, and returned only on creation.
+func (w *Webhooks) Create(rw http.ResponseWriter, r *http.Request) error {
+	var hook Webhook
+	var err = readJSON(r, &hook)
+	if err != nil {
+		return BadRequest{err} // 400
+	}
+
+	err = w.validate(hook)
+	if err != nil {
+		return BadRequest{err} // 400
+	}
+
+	if len(hook.Secret) == 0 {
+		var b = make([]byte, 32)
+		rand.Read(b)
+		hook.Secret = hex.EncodeToString(b)
+	}
+	hook.ID = ""
+	hook.Created = time.Now().UTC()
+
+	err = w.store.SaveWebhook(&hook)
+	if err != nil {
+		return err // 500
+	}
+	return writeJSON(rw, hook, http.StatusCreated) // 201
+}
+
+// Find handles webhook selection by ID.
+func (w *Webhooks) Find(rw http.ResponseWriter, r *http.Request) error {
+	var hook, err = w.store.FindWebhook(readID(rw, r))
+	if err != nil {
+		return err // 404, 500
+	}
+
+	hook.Secret = ""
+	return writeJSON(rw, hook, http.StatusOK) // 200
+}
+
+// Update handles webhook update. The secret is kept if none is given.
+func (w *Webhooks) Update(rw http.ResponseWriter, r *http.Request) error {
+	var hook Webhook
+	var err = readJSON(r, &hook)
+	if err != nil {
+		return BadRequest{err} // 400
+	}
+
+	err = w.validate(hook)
+	if err != nil {
+		return BadRequest{err} // 400
+	}
+
+	current, err := w.store.FindWebhook(readID(rw, r))
+	if err != nil {
+		return err // 404, 500
+	}
+	if len(hook.Secret) == 0 {
+		hook.Secret = current.Secret
+	}
+	hook.ID, hook.Created = current.ID, current.Created
+
+	err = w.store.SaveWebhook(&hook)
+	if err != nil {
+		return err // 500
+	}
+
+	hook.Secret = ""
+	return writeJSON(rw, hook, http.StatusOK) // 200
+}
+
+// Delete handles webhook deletion, with its deliveries.
+func (w *Webhooks) Delete(rw http.ResponseWriter, r *http.Request) error {
+	var err = w.store.DeleteWebhook(readID(rw, r))
+	if err != nil {
+		return err // 404, 500
+	}
+
+	rw.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// Deliveries handles the log of the deliveries of a webhook,
+// the most recent first, up to the "limit" query parameter.
+func (w *Webhooks) Deliveries(rw http.ResponseWriter, r *http.Request) error {
+	var limit, err = queryInt(r.URL.Query(), "limit", DefaultPageLimit)
+	if err != nil {
+		return err // 400
+	}
+	if limit == 0 || limit > MaxPageLimit {
+		return BadRequest{fmt.Errorf("web: limit must be between 1 and %d", MaxPageLimit)} // 400
+	}
+
+	var id = readID(rw, r)
+	_, err = w.store.FindWebhook(id)
+	if err != nil {
+		return err // 404, 500
+	}
+
+	deliveries, err := w.store.ListDeliveries(id, limit)
+	if err != nil {
+		return err // 500
+	}
+	return writeJSON(rw, deliveries, http.StatusOK) // 200
+}
+
+// Test handles a ping delivery to a webhook, sent at once without retries,
+// and returns the logged delivery.
+func (w *Webhooks) Test(rw http.ResponseWriter, r *http.Request) error {
+	var hook, err = w.store.FindWebhook(readID(rw, r))
+	if err != nil {
+		return err // 404, 500
+	}
+
+	var payload, _ = json.Marshal(WebhookEvent{Event: EventPing, Created: time.Now().UTC()})
+	var d = newDelivery(hook.ID, EventPing, payload)
+
+	// saved first for its id
+	d.Status = DeliveryFailed
+	err = w.store.SaveDelivery(&d)
+	if err != nil {
+		return err // 500
+	}
+
+	d.Attempts = 1
+	d.Code, err = w.send(hook, d)
+	d.Updated = time.Now().UTC()
+	if err != nil {
+		d.Error = err.Error()
+	} else {
+		d.Status = DeliveryDelivered
+	}
+
+	err = w.store.SaveDelivery(&d)
+	if err != nil {
+		return err // 500
+	}
+	return writeJSON(rw, d, http.StatusOK) // 200
+}
+
+// Store returns a Store publishing the events of the mutations
+// of the given store.
+func (w *Webhooks) Store(store Store) Store {
+	return webhookStore{store, w, context.Background()}
+}
+
+// webhookStore publishes the events of the mutations of a Store.
+type webhookStore struct {
+	Store
+	hooks *Webhooks
+	ctx   context.Context
+}
+
+func (s webhookStore) publish(event WebhookEvent) {
+	var err = s.hooks.Publish(event)
+	if err != nil {
+		contextLogger(s.ctx).Error("webhook: publish", "err", err, "event", event.Event)
+	}
+}
+
+// todoEvent returns the event of a todo saved from the previous one.
+func todoEvent(previous, todo Todo) string {
+	if todo.Completed() && !previous.Completed() {
+		return EventTodoCompleted
+	}
+	return EventTodoUpdated
+}
+
+func (s webhookStore) Save(t *Todo) error {
+	var previous Todo
+	if len(t.ID) != 0 {
+		previous, _ = s.Store.Find(t.ID)
+	}
+
+	var err = s.Store.Save(t)
+	if err != nil {
+		return err
+	}
+
+	var event = EventTodoCreated
+	if len(previous.ID) != 0 {
+		event = todoEvent(previous, *t)
+	}
+
+	var saved = *t
+	s.publish(WebhookEvent{Event: event, Todo: &saved})
+	return nil
+}
+
+func (s webhookStore) Delete(id string) error {
+	var err = s.Store.Delete(id)
+	if err == nil {
+		s.publish(WebhookEvent{Event: EventTodoDeleted, Todo: &Todo{ID: id}})
+	}
+	return err
+}
+
+func (s webhookStore) Patch(id string, patch TodoPatch) (Todo, error) {
+	var previous, _ = s.Store.Find(id)
+
+	var todo, err = s.Store.Patch(id, patch)
+	if err == nil {
+		s.publish(WebhookEvent{Event: todoEvent(previous, todo), Todo: &todo})
+	}
+	return todo, err
+}
+
+func (s webhookStore) Clear(status string) (int64, error) {
+	var count, err = s.Store.Clear(status)
+	if err == nil && count != 0 {
+		s.publish(WebhookEvent{Event: EventTodosCleared, Status: status, Count: count})
+	}
+	return count, err
+}
+
+func (s webhookStore) Toggle(status string) (int64, error) {
+	var count, err = s.Store.Toggle(status)
+	if err == nil && count != 0 {
+		s.publish(WebhookEvent{Event: EventTodosToggled, Status: status, Count: count})
+	}
+	return count, err
+}
+
+// Batch publishes an event per operation, updates as todo.updated,
+// or todos.changed when failed, as the operations of a failed batch
+// may be applied partly.
+func (s webhookStore) Batch(ops []Operation) (Results, error) {
+	var results, err = s.Store.Batch(ops)
+	if err != nil {
+		s.publish(WebhookEvent{Event: EventTodosChanged})
+		return results, err
+	}
+
+	var events = map[string]string{
+		OpCreate: EventTodoCreated,
+		OpUpdate: EventTodoUpdated,
+	}
+	for i, result := range results {
+		if result.Op == OpDelete {
+			s.publish(WebhookEvent{Event: EventTodoDeleted, Todo: &Todo{ID: ops[i].Todo.ID}})
+		} else if result.Todo != nil {
+			var todo = *result.Todo
+			s.publish(WebhookEvent{Event: events[result.Op], Todo: &todo})
+		}
+	}
+	return results, err
+}
+
+func (s webhookStore) Import(todos Todos) (int64, error) {
+	var count, err = s.Store.Import(todos)
+	if err == nil && count != 0 {
+		s.publish(WebhookEvent{Event: EventTodosImported, Count: count})
+	}
+	return count, err
+}
+
+func (s webhookStore) WithContext(ctx context.Context) Store {
+	return webhookStore{s.Store.WithContext(ctx), s.hooks, ctx}
+}